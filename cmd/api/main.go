@@ -2,17 +2,21 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 
+	"github.com/vele/temp_test_repo/internal/auth"
 	"github.com/vele/temp_test_repo/internal/config"
 	"github.com/vele/temp_test_repo/internal/event"
 	"github.com/vele/temp_test_repo/internal/service"
+	"github.com/vele/temp_test_repo/internal/storage/blob"
 	postgresstorage "github.com/vele/temp_test_repo/internal/storage/postgres"
 	httptransport "github.com/vele/temp_test_repo/internal/transport/http"
 	"github.com/vele/temp_test_repo/internal/transport/http/handler"
@@ -25,33 +29,148 @@ func main() {
 
 	log := logger.New(logrus.InfoLevel)
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(cfg, log, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(cfg, log, os.Args[2:])
+		return
+	}
+
 	repo, err := postgresstorage.NewRepository(cfg.PostgresDSN)
 	if err != nil {
 		log.WithError(err).Fatal("failed to connect to postgres")
 	}
 
-	publisher, err := event.NewRabbitPublisher(cfg.RabbitDSN, "user.events")
+	migrator, err := postgresstorage.NewMigrator(repo.DB())
+	if err != nil {
+		log.WithError(err).Fatal("failed to load migrations")
+	}
+	if err := migrator.EnsureCurrent(context.Background()); err != nil {
+		log.WithError(err).Fatal("refusing to start")
+	}
+
+	broker, err := event.DialConnection(cfg.RabbitDSN, event.ReconnectConfig{
+		MinBackoff: cfg.RabbitReconnectMin,
+		MaxBackoff: cfg.RabbitReconnectMax,
+	}, log)
 	if err != nil {
 		log.WithError(err).Fatal("failed to connect to rabbitmq")
 	}
-	defer publisher.Close()
+	defer broker.Close()
+
+	publisher, err := event.NewRabbitPublisher(broker, cfg.RabbitExchange, event.PublisherConfig{
+		Channels:             cfg.RabbitPublisherChannels,
+		LegacyFanoutExchange: cfg.RabbitLegacyExchange,
+		Encoding:             event.Encoding(cfg.EventEncoding),
+		Source:               cfg.EventSource,
+	})
+	if err != nil {
+		log.WithError(err).Fatal("failed to create rabbitmq publisher")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	outbox := postgresstorage.NewOutbox(repo)
+	eventStore := postgresstorage.NewEventStore(repo)
+	// Services record each event in the event store and the outbox, within
+	// the transaction of the change.
+	recorder := event.Publishers{eventStore, outbox}
+	hub := event.NewHub(cfg.EventStreamHistory, 0)
+	relayed, err := streamFeed(ctx, cfg, broker, hub, publisher, log)
+	if err != nil {
+		log.WithError(err).Fatal("failed to feed the event stream")
+	}
+	relay := event.NewOutboxRelay(outbox, relayed, event.RelayConfig{
+		PollInterval: cfg.OutboxPollInterval,
+		BatchSize:    cfg.OutboxBatchSize,
+	}, log)
+	go func() {
+		if err := relay.Run(ctx); err != nil && err != context.Canceled {
+			log.WithError(err).Error("outbox relay stopped")
+		}
+	}()
+
+	accountService := service.NewAccountService(repo, repo, recorder)
+	if err := accountService.Bootstrap(ctx, cfg.AdminUser, cfg.AdminPassword); err != nil {
+		log.WithError(err).Fatal("failed to bootstrap admin account")
+	}
 
-	userService := service.NewUserService(repo, repo, publisher)
-	userHandler := handler.NewUserHandler(userService)
-	authHandler := handler.NewAuthHandler(cfg.JWTSecret, cfg.AdminUser, cfg.AdminPassword, cfg.TokenTTL)
-	authMiddleware := middleware.NewAuth(cfg.JWTSecret)
+	keys := auth.NewHMACKeySet(cfg.JWTSecret)
+	if cfg.JWTSigningKeyFile != "" {
+		if keys, err = auth.LoadKeySet(cfg.JWTSigningKeyFile, cfg.JWTVerificationKeyFiles); err != nil {
+			log.WithError(err).Fatal("failed to load JWT keys")
+		}
+	} else {
+		log.Warn("JWT_SIGNING_KEY_FILE not set; signing tokens with the shared HS256 secret")
+	}
+	issuer := auth.NewTokenIssuer(keys, cfg.JWTIssuer, cfg.TokenTTL)
+	sessionService := service.NewSessionService(accountService, repo, repo, recorder, issuer, cfg.RefreshTTL)
+
+	apiKeyService := service.NewAPIKeyService(repo, repo, recorder)
+	userService := service.NewUserService(repo, repo, repo, recorder)
+	blobs, err := newBlobStore(ctx, cfg)
+	if err != nil {
+		log.WithError(err).Fatal("failed to open blob store")
+	}
+	fileContentService := service.NewFileContentService(userService, repo, blobs, cfg.MaxUploadBytes)
+	userHandler := handler.NewUserHandler(userService, fileContentService)
+	accountHandler := handler.NewAccountHandler(accountService)
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService)
+	eventService := service.NewEventService(eventStore,
+		event.NewReplayer(eventStore, cfg.EventReplayBatchSize, log),
+		newReplayPublisher(broker, cfg))
+	eventHandler := handler.NewEventHandler(eventService)
+	webhookHandler := handler.NewWebhookHandler(service.NewWebhookService(repo))
+	streamHandler := handler.NewStreamHandler(hub, cfg.EventStreamHeartbeat)
+	authHandler := handler.NewAuthHandler(sessionService, keys)
+	verifiers := auth.Verifiers{issuer}
+	if cfg.OIDCIssuer != "" {
+		roles, err := auth.ParseRoleMapping(cfg.OIDCRoleMapping)
+		if err != nil {
+			log.WithError(err).Fatal("invalid OIDC_ROLE_MAPPING")
+		}
+		oidc, err := auth.NewOIDCVerifier(auth.OIDCConfig{
+			Issuer:          cfg.OIDCIssuer,
+			Audience:        cfg.OIDCAudience,
+			JWKSURL:         cfg.OIDCJWKSURL,
+			JWKSFile:        cfg.OIDCJWKSFile,
+			RoleClaim:       cfg.OIDCRoleClaim,
+			RoleMapping:     roles,
+			RefreshInterval: cfg.OIDCJWKSRefresh,
+		})
+		if err != nil {
+			log.WithError(err).Fatal("failed to configure OIDC")
+		}
+		verifiers = append(verifiers, oidc)
+		log.Infof("accepting OIDC tokens from %s", cfg.OIDCIssuer)
+	}
+	authMiddleware := middleware.NewAuth(verifiers, sessionService, apiKeyService)
 
 	router := httptransport.NewRouter(httptransport.RouterDeps{
-		UserHandler: userHandler,
-		AuthHandler: authHandler,
-		Auth:        authMiddleware,
-		Logger:      log,
+		UserHandler:    userHandler,
+		AccountHandler: accountHandler,
+		APIKeyHandler:  apiKeyHandler,
+		EventHandler:   eventHandler,
+		WebhookHandler: webhookHandler,
+		StreamHandler:  streamHandler,
+		AuthHandler:    authHandler,
+		HealthHandler: handler.NewHealthHandler(map[string]handler.HealthCheck{
+			"postgres": repo.Ping,
+			"rabbitmq": broker.Check,
+		}),
+		Auth:   authMiddleware,
+		Logger: log,
 	})
 
 	server := &http.Server{
 		Addr:    cfg.Addr(),
 		Handler: router,
 	}
+	// Shutdown waits for open requests; end the event streams so it can.
+	server.RegisterOnShutdown(hub.Close)
 
 	go func() {
 		log.Infof("HTTP server listening on %s", cfg.Addr())
@@ -63,6 +182,53 @@ func main() {
 	waitForShutdown(log, server)
 }
 
+// streamFeed connects the event stream's hub to the source selected by
+// EVENT_STREAM_SOURCE and returns the publisher the outbox relay uses.
+// With "relay" the hub is fed before the broker, so the stream keeps going
+// while the broker is down; the relay's retries reach the hub again, but it
+// drops events it has already seen.
+func streamFeed(ctx context.Context, cfg config.Config, broker *event.Connection, hub *event.Hub, publisher event.Publisher, log *logrus.Logger) (event.Publisher, error) {
+	switch cfg.EventStreamSource {
+	case "relay":
+		return event.Publishers{hub, publisher}, nil
+	case "rabbitmq":
+		// One worker keeps the stream in publish order.
+		consumer, err := event.NewRabbitConsumer(broker, cfg.RabbitExchange, "user.events.stream."+uuid.NewString(), []string{"user.#"}, event.ConsumerConfig{
+			Workers:   1,
+			Transient: true,
+		}, log)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			if err := consumer.Consume(ctx, hub.Publish); err != nil && err != context.Canceled {
+				log.WithError(err).Error("event stream consumer stopped")
+			}
+		}()
+		return publisher, nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_STREAM_SOURCE %q", cfg.EventStreamSource)
+	}
+}
+
+func newBlobStore(ctx context.Context, cfg config.Config) (blob.Store, error) {
+	switch cfg.BlobStore {
+	case "local":
+		return blob.NewLocalStore(cfg.BlobDir)
+	case "s3":
+		return blob.NewS3Store(ctx, blob.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			UseSSL:    cfg.S3UseSSL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q", cfg.BlobStore)
+	}
+}
+
 func waitForShutdown(log *logrus.Logger, server *http.Server) {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
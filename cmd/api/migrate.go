@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vele/temp_test_repo/internal/config"
+	postgresstorage "github.com/vele/temp_test_repo/internal/storage/postgres"
+)
+
+const migrateUsage = `usage: server migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down [n]      roll back the last n migrations (default 1)
+  status        list migrations and whether they are applied
+  to <version>  migrate up or down to the given version`
+
+func runMigrate(cfg config.Config, log *logrus.Logger, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	repo, err := postgresstorage.NewRepository(cfg.PostgresDSN)
+	if err != nil {
+		log.WithError(err).Fatal("failed to connect to postgres")
+	}
+	defer repo.Close()
+
+	migrator, err := postgresstorage.NewMigrator(repo.DB())
+	if err != nil {
+		log.WithError(err).Fatal("failed to load migrations")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatalf("invalid step count %q", args[1])
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case "to":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			os.Exit(2)
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			log.Fatalf("invalid version %q", args[1])
+		}
+		err = migrator.To(ctx, version)
+	case "status":
+		err = printMigrationStatus(ctx, migrator)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.WithError(err).Fatalf("migrate %s failed", args[0])
+	}
+	if args[0] != "status" {
+		log.Infof("migrate %s completed", args[0])
+	}
+}
+
+func printMigrationStatus(ctx context.Context, migrator *postgresstorage.Migrator) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vele/temp_test_repo/internal/config"
+	"github.com/vele/temp_test_repo/internal/event"
+	postgresstorage "github.com/vele/temp_test_repo/internal/storage/postgres"
+)
+
+const replayUsage = `usage: server replay [flags] (-exchange <name> | -queue <name>)
+
+Re-publishes stored events in sequence order. Filters combine; without any
+every stored event is replayed.`
+
+func runReplay(cfg config.Config, log *logrus.Logger, args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, replayUsage)
+		flags.PrintDefaults()
+	}
+	var (
+		after    = flags.Int64("after", 0, "replay events after this sequence number")
+		to       = flags.Int64("to", 0, "replay events up to and including this sequence number")
+		types    = flags.String("type", "", "comma-separated event types, e.g. UserCreated,UserUpdated")
+		userID   = flags.Uint("user", 0, "replay only events of this user ID")
+		since    = flags.String("since", "", "replay events that occurred at or after this RFC 3339 time")
+		until    = flags.String("until", "", "replay events that occurred before this RFC 3339 time")
+		limit    = flags.Int("limit", 0, "replay at most this many events")
+		exchange = flags.String("exchange", "", "existing exchange to publish to, with the usual routing keys")
+		queue    = flags.String("queue", "", "existing queue to publish to")
+		again    = flags.Bool("reprocess", false, "have deduplicating consumers handle events they already handled")
+	)
+	_ = flags.Parse(args)
+
+	filter := event.EventFilter{
+		AfterSequence: *after,
+		ToSequence:    *to,
+		UserID:        *userID,
+		Limit:         *limit,
+	}
+	if *types != "" {
+		for _, name := range strings.Split(*types, ",") {
+			filter.Types = append(filter.Types, event.Type(strings.TrimSpace(name)))
+		}
+	}
+	var err error
+	if filter.Since, err = parseReplayTime(*since); err != nil {
+		log.WithError(err).Fatal("invalid -since")
+	}
+	if filter.Until, err = parseReplayTime(*until); err != nil {
+		log.WithError(err).Fatal("invalid -until")
+	}
+	target := event.ReplayTarget{Exchange: *exchange, Queue: *queue, Reprocess: *again}
+
+	repo, err := postgresstorage.NewRepository(cfg.PostgresDSN)
+	if err != nil {
+		log.WithError(err).Fatal("failed to connect to postgres")
+	}
+	defer repo.Close()
+
+	broker, err := event.DialConnection(cfg.RabbitDSN, event.ReconnectConfig{
+		MinBackoff: cfg.RabbitReconnectMin,
+		MaxBackoff: cfg.RabbitReconnectMax,
+	}, log)
+	if err != nil {
+		log.WithError(err).Fatal("failed to connect to rabbitmq")
+	}
+	defer broker.Close()
+
+	publisher, closePublisher, err := newReplayPublisher(broker, cfg)(target)
+	if err != nil {
+		flags.Usage()
+		log.WithError(err).Fatal("invalid replay target")
+	}
+	defer closePublisher()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	replayer := event.NewReplayer(postgresstorage.NewEventStore(repo), cfg.EventReplayBatchSize, log)
+	result, err := replayer.Replay(ctx, filter, publisher)
+	entry := log.WithFields(logrus.Fields{
+		"replayed":     result.Replayed,
+		"lastSequence": result.LastSequence,
+	})
+	if err != nil {
+		entry.WithError(err).Fatal("replay failed; resume with -after set to lastSequence")
+	}
+	entry.Info("replay completed")
+}
+
+// newReplayPublisher returns the factory that opens a publisher per replay
+// target, for both the replay command and the admin endpoint.
+func newReplayPublisher(broker *event.Connection, cfg config.Config) func(event.ReplayTarget) (event.Publisher, func(), error) {
+	return func(target event.ReplayTarget) (event.Publisher, func(), error) {
+		publisher, err := event.NewRabbitReplayPublisher(broker, target, event.PublisherConfig{
+			Encoding: event.Encoding(cfg.EventEncoding),
+			Source:   cfg.EventSource,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return publisher, publisher.Close, nil
+	}
+}
+
+func parseReplayTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -2,45 +2,234 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/vele/temp_test_repo/internal/config"
 	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/sink"
+	postgresstorage "github.com/vele/temp_test_repo/internal/storage/postgres"
+	"github.com/vele/temp_test_repo/internal/webhook"
 	"github.com/vele/temp_test_repo/pkg/logger"
 )
 
 func main() {
 	cfg := config.Load()
 	log := logger.New(logrus.InfoLevel)
+	if len(cfg.ConsumerSinks) == 0 {
+		log.Fatal("no sinks configured in CONSUMER_SINKS")
+	}
+
+	var repo *postgresstorage.Repository
+	if needsPostgres(cfg) {
+		var err error
+		if repo, err = openRepository(cfg); err != nil {
+			log.WithError(err).Fatal("failed to connect to postgres")
+		}
+		defer repo.Close()
+	}
 
-	consumer, err := event.NewRabbitConsumer(cfg.RabbitDSN, "user.events", "user.events.console")
+	broker, err := event.DialConnection(cfg.RabbitDSN, event.ReconnectConfig{
+		MinBackoff: cfg.RabbitReconnectMin,
+		MaxBackoff: cfg.RabbitReconnectMax,
+	}, log)
 	if err != nil {
-		log.WithError(err).Fatal("failed to create consumer")
+		log.WithError(err).Fatal("failed to connect to rabbitmq")
+	}
+	defer broker.Close()
+
+	processed, err := newProcessedStore(cfg, repo)
+	if err != nil {
+		log.WithError(err).Fatal("failed to open processed event store")
+	}
+	var dedup *event.Deduplicator
+	if processed != nil {
+		dedup = event.NewDeduplicator(processed, cfg.ConsumerDedupTTL, cfg.ConsumerDedupLease, log)
+	}
+
+	type runner struct {
+		name     string
+		queue    string
+		consumer *event.RabbitConsumer
+		handler  event.Handler
+		sink     sink.Sink
+	}
+	var runners []runner
+	for _, sc := range cfg.ConsumerSinks {
+		s, err := newSink(sc.Name, cfg, repo, log)
+		if err != nil {
+			log.WithError(err).WithField("sink", sc.Name).Fatal("failed to open sink")
+		}
+		defer s.Close()
+
+		registry := event.NewRegistry()
+		s.Register(registry)
+		handler := registry.Dispatch
+		if dedup != nil {
+			// Sinks share the store but each handles every event once.
+			handler = dedup.Scoped(sc.Name).Wrap(handler)
+		}
+
+		consumer, err := event.NewRabbitConsumer(broker, cfg.RabbitExchange, sc.Queue, sc.Bindings, event.ConsumerConfig{
+			Prefetch:       cfg.ConsumerPrefetch,
+			Workers:        cfg.ConsumerWorkers,
+			MaxRetries:     cfg.ConsumerMaxRetries,
+			RetryDelay:     cfg.ConsumerRetryDelay,
+			MaxRetryDelay:  cfg.ConsumerMaxRetryDelay,
+			LegacyExchange: cfg.RabbitLegacyExchange,
+		}, log)
+		if err != nil {
+			log.WithError(err).WithField("sink", sc.Name).Fatal("failed to create consumer")
+		}
+		runners = append(runners, runner{name: sc.Name, queue: sc.Queue, consumer: consumer, handler: handler, sink: s})
 	}
-	defer consumer.Close()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go func() {
-		if err := consumer.Consume(ctx, func(ctx context.Context, evt event.Event) error {
-			log.WithFields(logrus.Fields{
-				"type":   evt.Type,
-				"userID": evt.UserID,
-			}).Info("event received")
-			return nil
-		}); err != nil {
-			log.WithError(err).Error("consumer stopped")
+	var wg sync.WaitGroup
+	failed := make(chan struct{}, 2*len(runners))
+	if store, ok := processed.(*postgresstorage.ProcessedEvents); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sweepProcessedEvents(ctx, store, cfg.ConsumerDedupSweep, log)
+		}()
+	}
+	for _, r := range runners {
+		if bg, ok := r.sink.(sink.Runner); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := bg.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+					log.WithError(err).WithField("sink", r.name).Error("sink stopped")
+					failed <- struct{}{}
+				}
+			}()
 		}
-	}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entry := log.WithFields(logrus.Fields{"sink": r.name, "queue": r.queue})
+			entry.Info("consuming")
+			if err := r.consumer.Consume(ctx, r.handler); err != nil && !errors.Is(err, context.Canceled) {
+				entry.WithError(err).Error("consumer failed")
+				failed <- struct{}{}
+			}
+		}()
+	}
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop
+	select {
+	case <-stop:
+	case <-failed:
+	}
+	// Let in-flight events finish; unacknowledged ones are redelivered.
 	cancel()
+	wg.Wait()
 	log.Info("consumer stopped")
 }
+
+// newSink opens the sink called name.
+func newSink(name string, cfg config.Config, repo *postgresstorage.Repository, log *logrus.Logger) (sink.Sink, error) {
+	switch name {
+	case "log":
+		return sink.NewLogSink(log), nil
+	case "jsonl":
+		return sink.NewJSONLSink(cfg.SinkJSONLPath)
+	case "http":
+		if cfg.SinkHTTPURL == "" {
+			return nil, errors.New("SINK_HTTP_URL is required")
+		}
+		return sink.NewHTTPSink(cfg.SinkHTTPURL, &http.Client{Timeout: cfg.SinkHTTPTimeout}), nil
+	case "projection":
+		return sink.NewProjectionSink(postgresstorage.NewUserProjection(repo)), nil
+	case "webhooks":
+		return webhook.NewDispatcher(postgresstorage.NewWebhookDeliveries(repo), &http.Client{Timeout: cfg.WebhookTimeout}, webhook.Config{
+			PollInterval: cfg.WebhookPollInterval,
+			BatchSize:    cfg.WebhookBatchSize,
+			MaxAttempts:  cfg.WebhookMaxAttempts,
+			MinBackoff:   cfg.WebhookMinBackoff,
+			MaxBackoff:   cfg.WebhookMaxBackoff,
+		}, log), nil
+	default:
+		return nil, fmt.Errorf("unknown sink %q", name)
+	}
+}
+
+// newProcessedStore opens the store of handled event IDs selected by
+// CONSUMER_DEDUP_STORE. It returns nil for "none".
+func newProcessedStore(cfg config.Config, repo *postgresstorage.Repository) (event.ProcessedStore, error) {
+	switch cfg.ConsumerDedupStore {
+	case "none":
+		return nil, nil
+	case "memory":
+		return event.NewLRUProcessedStore(cfg.ConsumerDedupCapacity), nil
+	case "postgres":
+		return postgresstorage.NewProcessedEvents(repo), nil
+	default:
+		return nil, fmt.Errorf("unknown CONSUMER_DEDUP_STORE %q", cfg.ConsumerDedupStore)
+	}
+}
+
+// sweepProcessedEvents deletes expired processed event IDs every interval
+// until ctx is cancelled.
+func sweepProcessedEvents(ctx context.Context, store *postgresstorage.ProcessedEvents, interval time.Duration, log *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deleted, err := store.DeleteExpired(ctx)
+		if err != nil {
+			log.WithError(err).Warn("deleting expired processed events failed")
+			continue
+		}
+		log.WithField("deleted", deleted).Debug("deleted expired processed events")
+	}
+}
+
+// needsPostgres reports whether the dedup store or a sink keeps state in
+// Postgres.
+func needsPostgres(cfg config.Config) bool {
+	if cfg.ConsumerDedupStore == "postgres" {
+		return true
+	}
+	for _, sc := range cfg.ConsumerSinks {
+		if sc.Name == "projection" || sc.Name == "webhooks" {
+			return true
+		}
+	}
+	return false
+}
+
+// openRepository connects to Postgres and checks that the schema is current,
+// like the API server does. Neither applies migrations; that is left to
+// `server migrate up` or the compose migrate service.
+func openRepository(cfg config.Config) (*postgresstorage.Repository, error) {
+	repo, err := postgresstorage.NewRepository(cfg.PostgresDSN)
+	if err != nil {
+		return nil, err
+	}
+	migrator, err := postgresstorage.NewMigrator(repo.DB())
+	if err == nil {
+		err = migrator.EnsureCurrent(context.Background())
+	}
+	if err != nil {
+		repo.Close()
+		return nil, err
+	}
+	return repo, nil
+}
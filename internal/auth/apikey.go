@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key, which lets the middleware tell keys
+// from JWTs in a Bearer header and lets secret scanners recognise leaks.
+const APIKeyPrefix = "uak_"
+
+// GenerateAPIKey returns a new key of the form uak_<id>_<secret> and its
+// public prefix uak_<id>. Only the prefix and a hash of the key are stored.
+func GenerateAPIKey() (key, prefix string, err error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	secret, err := RandomSecret(32)
+	if err != nil {
+		return "", "", err
+	}
+	prefix = APIKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// ParseAPIKey returns the public prefix of key, or false if key is not
+// shaped like an API key.
+func ParseAPIKey(key string) (prefix string, ok bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if !ok || len(prefix) != 12 || secret == "" {
+		return "", false
+	}
+	return APIKeyPrefix + prefix, true
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JWK is the public part of a signing key as published in a JWK Set
+// (RFC 7517). The API publishes RSA and Ed25519 (OKP) keys; EC keys are
+// additionally accepted from external identity providers.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set. Symmetric keys are never included.
+func (s *KeySet) JWKS() JWKS {
+	out := JWKS{Keys: []JWK{}}
+	for _, key := range s.Keys() {
+		jwk, err := publicJWK(key.Public)
+		if err != nil {
+			continue
+		}
+		jwk.Use = "sig"
+		jwk.Kid = key.ID
+		jwk.Alg = key.Method.Alg()
+		out.Keys = append(out.Keys, jwk)
+	}
+	return out
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint, used as the key ID.
+func (j JWK) Thumbprint() string {
+	var canonical string
+	switch j.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, j.E, j.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, j.Crv, j.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PublicKey decodes the key material of j.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: decode n: %w", j.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil || len(e) > 4 {
+			return nil, fmt.Errorf("jwk %q: invalid exponent", j.Kid)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("jwk %q: RSA key of %d bits is too small", j.Kid, pub.N.BitLen())
+		}
+		return pub, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || j.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("jwk %q: invalid Ed25519 key", j.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("jwk %q: unsupported curve %q", j.Kid, j.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(j.X)
+		y, errY := base64.RawURLEncoding.DecodeString(j.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("jwk %q: invalid EC coordinates", j.Kid)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("jwk %q: point is not on curve", j.Kid)
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("jwk %q: unsupported key type %q", j.Kid, j.Kty)
+	}
+}
+
+func publicJWK(pub crypto.PublicKey) (JWK, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
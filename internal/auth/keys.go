@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSABits = 2048
+
+// Key is one entry of a KeySet. Private is nil for keys that may only verify.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// KeySet holds the key used to sign new tokens plus every key, current or
+// retired, whose tokens are still accepted. Keys are selected by the token's
+// "kid" header and must match the token's "alg", which prevents algorithm
+// confusion between keys.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	order   []string
+}
+
+// NewHMACKeySet returns a single-key HS256 set. Its secret is never published
+// through JWKS. Tokens without a kid header verify against it. Tokens minted
+// before key IDs were introduced still fail TokenIssuer.Verify, since they
+// carry no "iss" claim either; their holders have to log in again.
+func NewHMACKeySet(secret string) *KeySet {
+	key := &Key{ID: "hs256", Method: jwt.SigningMethodHS256, Private: []byte(secret), Public: []byte(secret)}
+	return &KeySet{signing: key, keys: map[string]*Key{key.ID: key}, order: []string{key.ID}}
+}
+
+// NewKeySet signs with signing (an *rsa.PrivateKey or ed25519.PrivateKey) and
+// additionally accepts tokens signed by the retired public keys.
+func NewKeySet(signing crypto.PrivateKey, retired ...crypto.PublicKey) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*Key)}
+
+	key, err := newAsymmetricKey(signing)
+	if err != nil {
+		return nil, err
+	}
+	set.signing = key
+	set.add(key)
+
+	for _, pub := range retired {
+		key, err := newAsymmetricKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		set.add(key)
+	}
+	return set, nil
+}
+
+// LoadKeySet reads the signing key and any retired verification keys from PEM
+// files. Verification files may hold public or private keys.
+func LoadKeySet(signingFile string, verificationFiles []string) (*KeySet, error) {
+	signing, err := readPEMKey(signingFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := signing.(crypto.Signer); !ok {
+		return nil, fmt.Errorf("%s: signing key must be a private key", signingFile)
+	}
+
+	retired := make([]crypto.PublicKey, 0, len(verificationFiles))
+	for _, file := range verificationFiles {
+		key, err := readPEMKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if signer, ok := key.(crypto.Signer); ok {
+			key = signer.Public()
+		}
+		retired = append(retired, key)
+	}
+	return NewKeySet(signing, retired...)
+}
+
+func (s *KeySet) SigningKey() *Key {
+	return s.signing
+}
+
+// Keys returns every key in the set, signing key first.
+func (s *KeySet) Keys() []*Key {
+	out := make([]*Key, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, s.keys[id])
+	}
+	return out
+}
+
+// Algorithms lists the "alg" values the set can verify.
+func (s *KeySet) Algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, id := range s.order {
+		alg := s.keys[id].Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// Keyfunc resolves the verification key for token, for use with jwt.Parse.
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var key *Key
+	if kid == "" {
+		if s.signing.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("token has no kid")
+		}
+		key = s.signing
+	} else if key = s.keys[kid]; key == nil {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("alg %q does not match key %q", token.Method.Alg(), key.ID)
+	}
+	return key.Public, nil
+}
+
+func (s *KeySet) add(key *Key) {
+	if _, exists := s.keys[key.ID]; exists {
+		return
+	}
+	s.keys[key.ID] = key
+	s.order = append(s.order, key.ID)
+}
+
+func newAsymmetricKey(k interface{}) (*Key, error) {
+	key := &Key{}
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		key.Private, key.Public, key.Method = k, &k.PublicKey, jwt.SigningMethodRS256
+	case *rsa.PublicKey:
+		key.Public, key.Method = k, jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.Private, key.Public, key.Method = k, k.Public(), jwt.SigningMethodEdDSA
+	case ed25519.PublicKey:
+		key.Public, key.Method = k, jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T (want RSA or Ed25519)", k)
+	}
+	if pub, ok := key.Public.(*rsa.PublicKey); ok && pub.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key of %d bits is too small, need at least %d", pub.N.BitLen(), minRSABits)
+	}
+	jwk, err := publicJWK(key.Public)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = jwk.Thumbprint()
+	return key, nil
+}
+
+func readPEMKey(file string) (interface{}, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", file)
+	}
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySet_RotationKeepsOldTokensValid(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	oldSet, err := NewKeySet(oldKey)
+	require.NoError(t, err)
+	oldToken, err := NewTokenIssuer(oldSet, "test", time.Minute).Issue(Principal{Subject: "ops"})
+	require.NoError(t, err)
+
+	rotated, err := NewKeySet(newKey, oldKey.Public())
+	require.NoError(t, err)
+	issuer := NewTokenIssuer(rotated, "test", time.Minute)
+
+	p, err := issuer.Verify(oldToken.Token)
+	require.NoError(t, err)
+	require.Equal(t, "ops", p.Subject)
+
+	newToken, err := issuer.Issue(Principal{Subject: "ops"})
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken.Token, jwt.MapClaims{})
+	require.NoError(t, err)
+	require.Equal(t, "RS256", parsed.Method.Alg())
+	require.Equal(t, rotated.SigningKey().ID, parsed.Header["kid"])
+
+	jwks := rotated.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	require.Equal(t, "RSA", jwks.Keys[0].Kty)
+	require.Equal(t, "OKP", jwks.Keys[1].Kty)
+	require.Equal(t, jwks.Keys[1].Kid, jwks.Keys[1].Thumbprint())
+}
+
+func TestKeySet_RejectsAlgorithmConfusion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	set, err := NewKeySet(key)
+	require.NoError(t, err)
+	issuer := NewTokenIssuer(set, "test", time.Minute)
+
+	// An HS256 token "signed" with the public RSA key must not verify.
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": "test",
+		"sub": "mallory",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	forged.Header["kid"] = set.SigningKey().ID
+	signed, err := forged.SignedString(pub)
+	require.NoError(t, err)
+
+	_, err = issuer.Verify(signed)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.Empty(t, NewHMACKeySet("secret").JWKS().Keys, "shared secrets are never published")
+}
+
+func TestLoadKeySet_ReadsPEMFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	_, current, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(current)
+	require.NoError(t, err)
+	signingFile := filepath.Join(dir, "current.pem")
+	require.NoError(t, os.WriteFile(signingFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	retired, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	retiredFile := filepath.Join(dir, "retired.pem")
+	require.NoError(t, os.WriteFile(retiredFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&retired.PublicKey)}), 0o600))
+
+	set, err := LoadKeySet(signingFile, []string{retiredFile})
+	require.NoError(t, err)
+	require.Equal(t, "EdDSA", set.SigningKey().Method.Alg())
+	require.ElementsMatch(t, []string{"EdDSA", "RS256"}, set.Algorithms())
+
+	_, err = LoadKeySet(retiredFile, nil)
+	require.Error(t, err, "a public key cannot sign")
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// minForcedRefresh bounds how often an unknown kid may trigger a JWKS fetch.
+	minForcedRefresh = 30 * time.Second
+	// refreshRetryDelay is how long a failed JWKS fetch blocks further attempts.
+	refreshRetryDelay = 30 * time.Second
+	maxJWKSBytes      = 1 << 20
+)
+
+// OIDCConfig describes an external OpenID Connect provider whose access
+// tokens the API accepts next to its own.
+type OIDCConfig struct {
+	Issuer   string
+	Audience string
+	// Exactly one of JWKSURL and JWKSFile should be set; the file form is
+	// meant for tests and air-gapped setups.
+	JWKSURL  string
+	JWKSFile string
+	// RoleClaim names the claim holding the caller's groups or roles. Dots
+	// address nested objects, e.g. "realm_access.roles".
+	RoleClaim string
+	// RoleMapping maps claim values to API roles. The highest mapped role
+	// wins; tokens without a mapped value authenticate without any scope.
+	RoleMapping     map[string]Role
+	RefreshInterval time.Duration
+	HTTPClient      *http.Client
+}
+
+// OIDCVerifier validates tokens issued by an external OpenID Connect
+// provider against its published JWK Set.
+type OIDCVerifier struct {
+	cfg  OIDCConfig
+	keys *remoteKeys
+}
+
+func NewOIDCVerifier(cfg OIDCConfig) (*OIDCVerifier, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("oidc: issuer is required")
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("oidc: audience is required")
+	}
+	if cfg.JWKSURL == "" && cfg.JWKSFile == "" {
+		return nil, errors.New("oidc: a JWKS URL or file is required")
+	}
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "groups"
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = 15 * time.Minute
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCVerifier{cfg: cfg, keys: &remoteKeys{cfg: cfg}}, nil
+}
+
+// Verify checks signature, issuer, audience and expiry and maps the token's
+// role claim to API scopes.
+func (v *OIDCVerifier) Verify(token string) (Principal, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, v.keyfunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(v.cfg.Issuer),
+		jwt.WithAudience(v.cfg.Audience),
+		jwt.WithExpirationRequired())
+	if err != nil || !parsed.Valid {
+		return Principal{}, ErrInvalidToken
+	}
+
+	p := Principal{}
+	p.Subject, _ = claims["sub"].(string)
+	p.TokenID, _ = claims["jti"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		p.ExpiresAt = exp.Time
+	}
+	if role, ok := v.mapRole(claims); ok {
+		p.Role = role
+		p.Scopes = role.Scopes()
+	}
+	return p, nil
+}
+
+func (v *OIDCVerifier) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := v.keys.get(kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.Alg != "" && key.Alg != token.Method.Alg() {
+		return nil, fmt.Errorf("alg %q does not match key %q", token.Method.Alg(), kid)
+	}
+	return key.public, nil
+}
+
+var roleRank = map[Role]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+func (v *OIDCVerifier) mapRole(claims jwt.MapClaims) (Role, bool) {
+	var value interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(v.cfg.RoleClaim, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		value = obj[part]
+	}
+
+	var values []string
+	switch val := value.(type) {
+	case string:
+		values = strings.Fields(val)
+	case []interface{}:
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	best, found := Role(""), false
+	for _, val := range values {
+		if role, ok := v.cfg.RoleMapping[val]; ok && roleRank[role] > roleRank[best] {
+			best, found = role, true
+		}
+	}
+	return best, found
+}
+
+type remoteKey struct {
+	Alg    string
+	public crypto.PublicKey
+}
+
+// remoteKeys caches a provider's JWK Set. Keys are refetched once
+// RefreshInterval has passed, or earlier when a token names an unknown kid.
+// Fetches run outside the lock and at most one at a time: callers holding a
+// cached key keep using it while a refresh is in flight. A failed refresh
+// keeps serving the previous keys and is not retried for refreshRetryDelay.
+type remoteKeys struct {
+	cfg OIDCConfig
+
+	mu        sync.Mutex
+	keys      map[string]remoteKey
+	fetchedAt time.Time
+	failedAt  time.Time
+	lastErr   error
+	inflight  chan struct{}
+}
+
+func (r *remoteKeys) get(kid string) (remoteKey, error) {
+	r.mu.Lock()
+	key, found := r.lookup(kid)
+	switch {
+	case r.due(found) && r.inflight == nil:
+		done := make(chan struct{})
+		r.inflight = done
+		r.mu.Unlock()
+		if found {
+			go r.refresh(done)
+			return key, nil
+		}
+		r.refresh(done)
+		r.mu.Lock()
+		key, found = r.lookup(kid)
+	case !found && r.inflight != nil:
+		done := r.inflight
+		r.mu.Unlock()
+		<-done
+		r.mu.Lock()
+		key, found = r.lookup(kid)
+	}
+	defer r.mu.Unlock()
+
+	if !found {
+		if r.keys == nil && r.lastErr != nil {
+			return remoteKey{}, r.lastErr
+		}
+		return remoteKey{}, fmt.Errorf("unknown kid %q", kid)
+	}
+	return key, nil
+}
+
+// due reports whether the keys should be refetched. r.mu must be held.
+func (r *remoteKeys) due(found bool) bool {
+	now := time.Now()
+	if now.Sub(r.failedAt) < refreshRetryDelay {
+		return false
+	}
+	age := now.Sub(r.fetchedAt)
+	return r.keys == nil || age > r.cfg.RefreshInterval || (!found && age > minForcedRefresh)
+}
+
+// lookup returns the key for kid. r.mu must be held.
+func (r *remoteKeys) lookup(kid string) (remoteKey, bool) {
+	if kid == "" && len(r.keys) == 1 {
+		for _, key := range r.keys {
+			return key, true
+		}
+	}
+	key, ok := r.keys[kid]
+	return key, ok
+}
+
+// refresh fetches the key set and closes done once the result is stored.
+func (r *remoteKeys) refresh(done chan struct{}) {
+	keys, err := r.load()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.failedAt = time.Now()
+		r.lastErr = err
+	} else {
+		r.keys = keys
+		r.fetchedAt = time.Now()
+		r.lastErr = nil
+	}
+	r.inflight = nil
+	close(done)
+}
+
+func (r *remoteKeys) load() (map[string]remoteKey, error) {
+	raw, err := r.fetch()
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetch jwks: %w", err)
+	}
+	var set JWKS
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("oidc: decode jwks: %w", err)
+	}
+	keys := make(map[string]remoteKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = remoteKey{Alg: jwk.Alg, public: pub}
+	}
+	return keys, nil
+}
+
+func (r *remoteKeys) fetch() ([]byte, error) {
+	if r.cfg.JWKSFile != "" {
+		return os.ReadFile(r.cfg.JWKSFile)
+	}
+	resp, err := r.cfg.HTTPClient.Get(r.cfg.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+}
+
+// ParseRoleMapping parses "claimValue=role" pairs separated by commas.
+func ParseRoleMapping(s string) (map[string]Role, error) {
+	mapping := make(map[string]Role)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		value, roleName, ok := strings.Cut(pair, "=")
+		role, known := ParseRole(strings.TrimSpace(roleName))
+		if !ok || strings.TrimSpace(value) == "" || !known {
+			return nil, fmt.Errorf("invalid role mapping %q", pair)
+		}
+		mapping[strings.TrimSpace(value)] = role
+	}
+	return mapping, nil
+}
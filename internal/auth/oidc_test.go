@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProvider struct {
+	mu      sync.Mutex
+	keys    *KeySet
+	fetches int
+	failing bool
+}
+
+func (f *fakeProvider) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetches++
+	if f.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(f.keys.JWKS())
+}
+
+func (f *fakeProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := f.keys.SigningKey()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Private)
+	require.NoError(t, err)
+	return signed
+}
+
+func newFakeProvider(t *testing.T) (*fakeProvider, *httptest.Server) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys, err := NewKeySet(key)
+	require.NoError(t, err)
+	provider := &fakeProvider{keys: keys}
+	server := httptest.NewServer(provider)
+	t.Cleanup(server.Close)
+	return provider, server
+}
+
+func idpClaims(groups ...string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":          "https://idp.example.com",
+		"aud":          "user-api",
+		"sub":          "alice@example.com",
+		"exp":          time.Now().Add(time.Minute).Unix(),
+		"realm_access": map[string]interface{}{"roles": groups},
+	}
+}
+
+func TestOIDCVerifier_ValidatesClaimsAndMapsRoles(t *testing.T) {
+	provider, server := newFakeProvider(t)
+	verifier, err := NewOIDCVerifier(OIDCConfig{
+		Issuer:      "https://idp.example.com",
+		Audience:    "user-api",
+		JWKSURL:     server.URL,
+		RoleClaim:   "realm_access.roles",
+		RoleMapping: map[string]Role{"api-readers": RoleViewer, "api-admins": RoleAdmin},
+	})
+	require.NoError(t, err)
+
+	p, err := verifier.Verify(provider.sign(t, idpClaims("api-readers", "api-admins")))
+	require.NoError(t, err)
+	require.Equal(t, "alice@example.com", p.Subject)
+	require.Equal(t, RoleAdmin, p.Role)
+	require.True(t, p.HasScope(ScopeAccountsManage))
+
+	p, err = verifier.Verify(provider.sign(t, idpClaims("unrelated")))
+	require.NoError(t, err)
+	require.Empty(t, p.Scopes)
+
+	wrongAudience := idpClaims("api-admins")
+	wrongAudience["aud"] = "another-api"
+	wrongIssuer := idpClaims("api-admins")
+	wrongIssuer["iss"] = "https://evil.example.com"
+	expired := idpClaims("api-admins")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	for name, claims := range map[string]jwt.MapClaims{"audience": wrongAudience, "issuer": wrongIssuer, "expired": expired} {
+		_, err := verifier.Verify(provider.sign(t, claims))
+		require.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+	require.Equal(t, 1, provider.fetches, "keys should be served from cache")
+}
+
+func TestOIDCVerifier_RefetchesKeysForUnknownKid(t *testing.T) {
+	provider, server := newFakeProvider(t)
+	verifier, err := NewOIDCVerifier(OIDCConfig{
+		Issuer:      "https://idp.example.com",
+		Audience:    "user-api",
+		JWKSURL:     server.URL,
+		RoleMapping: map[string]Role{"ops": RoleEditor},
+	})
+	require.NoError(t, err)
+
+	claims := idpClaims()
+	claims["groups"] = []string{"ops"}
+	_, err = verifier.Verify(provider.sign(t, claims))
+	require.NoError(t, err)
+
+	_, rotatedKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	provider.mu.Lock()
+	provider.keys, err = NewKeySet(rotatedKey)
+	provider.mu.Unlock()
+	require.NoError(t, err)
+
+	// Within minForcedRefresh an unknown kid is rejected without a fetch.
+	rotated := provider.sign(t, claims)
+	_, err = verifier.Verify(rotated)
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.Equal(t, 1, provider.fetches)
+
+	verifier.keys.fetchedAt = time.Now().Add(-minForcedRefresh - time.Second)
+	p, err := verifier.Verify(rotated)
+	require.NoError(t, err)
+	require.Equal(t, RoleEditor, p.Role)
+	require.Equal(t, 2, provider.fetches)
+}
+
+func TestOIDCVerifier_KeepsCachedKeysWhileProviderIsDown(t *testing.T) {
+	provider, server := newFakeProvider(t)
+	verifier, err := NewOIDCVerifier(OIDCConfig{
+		Issuer:      "https://idp.example.com",
+		Audience:    "user-api",
+		JWKSURL:     server.URL,
+		RoleMapping: map[string]Role{"ops": RoleEditor},
+	})
+	require.NoError(t, err)
+
+	claims := idpClaims()
+	claims["groups"] = []string{"ops"}
+	token := provider.sign(t, claims)
+	_, err = verifier.Verify(token)
+	require.NoError(t, err)
+
+	provider.mu.Lock()
+	provider.failing = true
+	provider.mu.Unlock()
+	verifier.keys.mu.Lock()
+	verifier.keys.fetchedAt = time.Now().Add(-time.Hour)
+	verifier.keys.mu.Unlock()
+
+	// The stale key is served while the refresh runs in the background.
+	_, err = verifier.Verify(token)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		verifier.keys.mu.Lock()
+		defer verifier.keys.mu.Unlock()
+		return verifier.keys.inflight == nil && !verifier.keys.failedAt.IsZero()
+	}, time.Second, 10*time.Millisecond)
+
+	// After the failure, neither known nor unknown kids refetch until
+	// refreshRetryDelay has passed.
+	for i := 0; i < 5; i++ {
+		_, err = verifier.Verify(token)
+		require.NoError(t, err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	other, err := NewKeySet(otherKey)
+	require.NoError(t, err)
+	unknown := jwt.NewWithClaims(other.SigningKey().Method, claims)
+	unknown.Header["kid"] = other.SigningKey().ID
+	signed, err := unknown.SignedString(other.SigningKey().Private)
+	require.NoError(t, err)
+	_, err = verifier.Verify(signed)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	require.Equal(t, 2, provider.fetches)
+}
+
+func TestNewOIDCVerifier_RequiresAudience(t *testing.T) {
+	_, err := NewOIDCVerifier(OIDCConfig{Issuer: "https://idp.example.com", JWKSURL: "https://idp.example.com/jwks"})
+	require.Error(t, err)
+}
+
+func TestVerifiers_AcceptsLocalAndProviderTokens(t *testing.T) {
+	provider, _ := newFakeProvider(t)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	raw, err := json.Marshal(provider.keys.JWKS())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, raw, 0o600))
+
+	oidc, err := NewOIDCVerifier(OIDCConfig{Issuer: "https://idp.example.com", Audience: "user-api", JWKSFile: file})
+	require.NoError(t, err)
+	local := NewTokenIssuer(NewHMACKeySet("secret"), "user-api", time.Minute)
+	verifiers := Verifiers{local, oidc}
+
+	issued, err := local.Issue(Principal{Subject: "admin", Role: RoleAdmin, Scopes: RoleAdmin.Scopes()})
+	require.NoError(t, err)
+	p, err := verifiers.Verify(issued.Token)
+	require.NoError(t, err)
+	require.Equal(t, "admin", p.Subject)
+
+	p, err = verifiers.Verify(provider.sign(t, idpClaims()))
+	require.NoError(t, err)
+	require.Equal(t, "alice@example.com", p.Subject)
+
+	_, err = verifiers.Verify("not-a-token")
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	mapping, err := ParseRoleMapping("api-admins=admin, api-editors=editor")
+	require.NoError(t, err)
+	require.Equal(t, map[string]Role{"api-admins": RoleAdmin, "api-editors": RoleEditor}, mapping)
+
+	_, err = ParseRoleMapping("api-admins=root")
+	require.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Scopes guarding the /api/v1 routes.
+const (
+	ScopeUsersRead      = "users:read"
+	ScopeUsersWrite     = "users:write"
+	ScopeUsersDelete    = "users:delete"
+	ScopeAccountsManage = "accounts:manage"
+	ScopeEventsManage   = "events:manage"
+	ScopeWebhooksManage = "webhooks:manage"
+)
+
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var roleScopes = map[Role][]string{
+	RoleViewer: {ScopeUsersRead},
+	RoleEditor: {ScopeUsersRead, ScopeUsersWrite},
+	RoleAdmin:  {ScopeUsersRead, ScopeUsersWrite, ScopeUsersDelete, ScopeAccountsManage, ScopeEventsManage, ScopeWebhooksManage},
+}
+
+// IsScope reports whether s is one of the scopes above.
+func IsScope(s string) bool {
+	for _, scope := range roleScopes[RoleAdmin] {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRole returns the role named by s and whether it is known.
+func ParseRole(s string) (Role, bool) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	_, ok := roleScopes[role]
+	return role, ok
+}
+
+// Scopes lists the scopes granted to the role.
+func (r Role) Scopes() []string {
+	scopes := roleScopes[r]
+	out := make([]string, len(scopes))
+	copy(out, scopes)
+	return out
+}
+
+// Principal is the authenticated caller of a request. TokenID and ExpiresAt
+// describe the credential it was derived from, for revocation.
+type Principal struct {
+	Subject   string    `json:"sub"`
+	AccountID uint      `json:"account_id,omitempty"`
+	Role      Role      `json:"role,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	TokenID   string    `json:"-"`
+	ExpiresAt time.Time `json:"-"`
+}
+
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingScopes returns the entries of required that p lacks.
+func (p Principal) MissingScopes(required ...string) []string {
+	var missing []string
+	for _, s := range required {
+		if !p.HasScope(s) {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
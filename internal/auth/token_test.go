@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenIssuer_RoundTripsPrincipal(t *testing.T) {
+	issuer := NewTokenIssuer(NewHMACKeySet("secret"), "test", time.Minute)
+
+	issued, err := issuer.Issue(Principal{
+		Subject:   "ops",
+		AccountID: 7,
+		Role:      RoleEditor,
+		Scopes:    RoleEditor.Scopes(),
+	})
+	require.NoError(t, err)
+
+	p, err := issuer.Verify(issued.Token)
+	require.NoError(t, err)
+	require.Equal(t, "ops", p.Subject)
+	require.Equal(t, uint(7), p.AccountID)
+	require.Equal(t, RoleEditor, p.Role)
+	require.Equal(t, []string{ScopeUsersRead, ScopeUsersWrite}, p.Scopes)
+	require.Equal(t, issued.ID, p.TokenID)
+	require.WithinDuration(t, issued.ExpiresAt, p.ExpiresAt, time.Second)
+}
+
+func TestTokenIssuer_RejectsForeignAlgorithmsAndExpiredTokens(t *testing.T) {
+	issuer := NewTokenIssuer(NewHMACKeySet("secret"), "test", time.Minute)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"sub": "mallory",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = issuer.Verify(unsigned)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	expired, err := NewTokenIssuer(NewHMACKeySet("secret"), "test", -time.Minute).Issue(Principal{Subject: "ops"})
+	require.NoError(t, err)
+	_, err = issuer.Verify(expired.Token)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
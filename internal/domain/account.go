@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// Account is an operator allowed to sign in to the API. It is unrelated to
+// the User records the API manages.
+type Account struct {
+	ID                uint      `json:"id"`
+	Username          string    `json:"username"`
+	PasswordHash      string    `json:"-"`
+	Role              string    `json:"role"`
+	Disabled          bool      `json:"disabled"`
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
@@ -11,4 +11,11 @@ var (
 	ErrInvalidInput = errors.New("invalid input")
 	// ErrUnauthorized fired when auth fails.
 	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden indicates the caller may not perform the operation.
+	ErrForbidden = errors.New("forbidden")
+	// ErrPreconditionFailed indicates the resource changed since the caller
+	// last read it.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrTooLarge indicates uploaded content exceeds the configured limit.
+	ErrTooLarge = errors.New("content too large")
 )
@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// RefreshToken is the server-side record of an issued refresh token. Tokens
+// issued by rotating one another share a FamilyID.
+type RefreshToken struct {
+	ID        uint
+	AccountID uint
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+}
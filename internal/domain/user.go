@@ -3,19 +3,30 @@ package domain
 import "time"
 
 type User struct {
-	ID        uint      `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Age       int       `json:"age"`
-	Files     []File    `json:"files,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uint       `json:"id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Age       int        `json:"age"`
+	Version   int64      `json:"version"`
+	Files     []File     `json:"files,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
+// File describes a document attached to a user. Path is unique per user.
+// StorageKey locates uploaded content in the blob store and is empty for
+// files recorded by reference only.
 type File struct {
-	ID        uint      `json:"id"`
-	UserID    uint      `json:"user_id"`
-	Name      string    `json:"name"`
-	Path      string    `json:"path"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          uint              `json:"id"`
+	UserID      uint              `json:"user_id"`
+	Name        string            `json:"name"`
+	Path        string            `json:"path"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type,omitempty"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	StorageKey  string            `json:"-"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
 }
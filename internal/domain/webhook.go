@@ -0,0 +1,44 @@
+package domain
+
+import "time"
+
+// Webhook subscribes an HTTP endpoint to user and file events. An empty
+// EventTypes receives all of them. Secret signs every delivery and is only
+// shown when the webhook is created.
+type Webhook struct {
+	ID         uint      `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"-"`
+	Active     bool      `json:"active"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Delivery states of a WebhookDelivery.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery records the delivery of one event to one webhook. A
+// pending delivery is attempted at NextAttemptAt; it fails for good once
+// its attempts are used up. ResponseStatus and LastError describe the
+// latest attempt.
+type WebhookDelivery struct {
+	ID             uint       `json:"id"`
+	WebhookID      uint       `json:"webhook_id"`
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Payload        []byte     `json:"-"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
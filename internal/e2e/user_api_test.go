@@ -1,20 +1,28 @@
 package e2e
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/vele/temp_test_repo/internal/auth"
 	"github.com/vele/temp_test_repo/internal/domain"
 	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/repository"
 	"github.com/vele/temp_test_repo/internal/service"
+	"github.com/vele/temp_test_repo/internal/storage/blob"
+	postgresstorage "github.com/vele/temp_test_repo/internal/storage/postgres"
 	"github.com/vele/temp_test_repo/internal/testutil"
 	httptransport "github.com/vele/temp_test_repo/internal/transport/http"
 	"github.com/vele/temp_test_repo/internal/transport/http/handler"
@@ -22,30 +30,7 @@ import (
 )
 
 func TestUserAPIEndToEnd(t *testing.T) {
-	dsn := testutil.StartPostgres(t)
-	repo := testutil.ConnectRepository(t, dsn)
-	t.Cleanup(func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		_ = repo.Truncate(ctx)
-		require.NoError(t, repo.Close())
-	})
-
-	publisher := event.NewInMemoryPublisher()
-	userSvc := service.NewUserService(repo, repo, publisher)
-
-	userHandler := handler.NewUserHandler(userSvc)
-	authHandler := handler.NewAuthHandler("secret", "admin", "password", time.Minute*15)
-	authMW := middleware.NewAuth("secret")
-
-	router := httptransport.NewRouter(httptransport.RouterDeps{
-		UserHandler: userHandler,
-		AuthHandler: authHandler,
-		Auth:        authMW,
-	})
-
-	server := httptest.NewServer(router)
-	t.Cleanup(server.Close)
+	server, publisher := setupAPI(t)
 
 	client := server.Client()
 	baseURL := server.URL
@@ -57,8 +42,14 @@ func TestUserAPIEndToEnd(t *testing.T) {
 	updated := updateUser(t, client, baseURL+"/api/v1/users", token, user.ID)
 	require.Equal(t, 31, updated.Age)
 
-	users := listUsers(t, client, baseURL+"/api/v1/users", token)
-	require.Len(t, users, 1)
+	page := listUsers(t, client, baseURL+"/api/v1/users?limit=10&sort=-created_at", token)
+	require.Len(t, page.Users, 1)
+	require.EqualValues(t, 1, page.Total)
+	require.Empty(t, page.NextCursor)
+
+	resp := doRequest(t, client, http.MethodGet, baseURL+"/api/v1/users?sort=password", token, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
 
 	f := addFile(t, client, baseURL+"/api/v1/users", token, user.ID)
 	require.Equal(t, "passport", f.Name)
@@ -73,22 +64,535 @@ func TestUserAPIEndToEnd(t *testing.T) {
 	deleteUser(t, client, baseURL+"/api/v1/users", token, user.ID)
 
 	events := publisher.Events()
-	require.Len(t, events, 3)
+	require.Len(t, events, 5)
 	require.Equal(t, event.UserCreated, events[0].Type)
 	require.Equal(t, event.UserUpdated, events[1].Type)
-	require.Equal(t, event.UserDeleted, events[2].Type)
+	require.Equal(t, event.FileAdded, events[2].Type)
+	require.Equal(t, event.UserFilesCleared, events[3].Type)
+	require.Equal(t, event.UserDeleted, events[4].Type)
+}
+
+func TestEvents_CarryRequestCorrelationID(t *testing.T) {
+	server, publisher := setupAPI(t)
+	client := server.Client()
+	token := login(t, client, server.URL+"/auth/login")
+
+	resp := doRequestWithHeaders(t, client, http.MethodPost, server.URL+"/api/v1/users", token,
+		map[string]string{middleware.CorrelationHeader: "req-42"},
+		service.CreateUserInput{Name: "Jane", Email: "jane@example.com", Age: 30})
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "req-42", resp.Header.Get(middleware.CorrelationHeader))
+
+	resp = doRequest(t, client, http.MethodGet, server.URL+"/api/v1/users", token, nil)
+	resp.Body.Close()
+	require.NotEmpty(t, resp.Header.Get(middleware.CorrelationHeader))
+
+	events := publisher.Events()
+	require.Len(t, events, 1)
+	require.NotEmpty(t, events[0].ID)
+	require.Equal(t, 1, events[0].Version)
+	require.Equal(t, "req-42", events[0].CorrelationID)
+}
+
+func TestAdminEvents_ListAndReplay(t *testing.T) {
+	server, publisher := setupAPI(t)
+	client := server.Client()
+	token := login(t, client, server.URL+"/auth/login")
+	base := server.URL + "/api/v1/admin/events"
+
+	user := createUser(t, client, server.URL+"/api/v1/users", token)
+	updateUser(t, client, server.URL+"/api/v1/users", token, user.ID)
+
+	resp := doRequest(t, client, http.MethodGet, base+"?type=UserUpdated&user_id="+itoa(user.ID), token, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var stored []event.StoredEvent
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stored))
+	require.Len(t, stored, 1)
+	require.Equal(t, event.UserUpdated, stored[0].Type)
+	require.EqualValues(t, 2, stored[0].Sequence)
+
+	resp = doRequest(t, client, http.MethodPost, base+"/replay", token, map[string]interface{}{
+		"target": map[string]string{"queue": "rebuild"},
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var result event.ReplayResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.Equal(t, event.ReplayResult{Replayed: 2, LastSequence: 2}, result)
+
+	events := publisher.Events()
+	require.Len(t, events, 4)
+	require.Equal(t, events[0].ID, events[2].ID, "replayed events keep their IDs")
+	require.Equal(t, events[1].ID, events[3].ID)
+
+	resp = doRequest(t, client, http.MethodPost, base+"/replay", token, map[string]interface{}{
+		"target": map[string]string{"queue": "rebuild", "exchange": "user.events.topic"},
+	})
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestWebhooks_ManageSubscriptions(t *testing.T) {
+	server, _ := setupAPI(t)
+	client := server.Client()
+	token := login(t, client, server.URL+"/auth/login")
+	base := server.URL + "/api/v1/webhooks"
+
+	resp := doRequest(t, client, http.MethodPost, base, token, map[string]interface{}{
+		"url":         "https://partner.example.com/hooks",
+		"event_types": []string{"UserCreated", "UserDeleted"},
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created service.CreatedWebhook
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	require.NotEmpty(t, created.Secret)
+	require.True(t, created.Active)
+
+	resp = doRequest(t, client, http.MethodGet, base, token, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var listed []map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	require.Len(t, listed, 1)
+	require.NotContains(t, listed[0], "secret", "the secret is only shown once")
+
+	resp = doRequest(t, client, http.MethodPut, base+"/"+itoa(created.ID), token, map[string]interface{}{"active": false})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var updated domain.Webhook
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&updated))
+	require.False(t, updated.Active)
+	require.Equal(t, created.EventTypes, updated.EventTypes)
+
+	resp = doRequest(t, client, http.MethodPost, base, token, map[string]interface{}{
+		"url":         "https://partner.example.com/hooks",
+		"event_types": []string{"LoginSucceeded"},
+	})
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "audit events cannot be subscribed to")
+
+	resp = doRequest(t, client, http.MethodGet, base+"/"+itoa(created.ID)+"/deliveries?status=failed", token, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var deliveries []domain.WebhookDelivery
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&deliveries))
+	require.Empty(t, deliveries)
+
+	resp = doRequest(t, client, http.MethodPost, base+"/"+itoa(created.ID)+"/deliveries/99/redeliver", token, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = doRequest(t, client, http.MethodDelete, base+"/"+itoa(created.ID), token, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = doRequest(t, client, http.MethodGet, base+"/"+itoa(created.ID), token, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestEventStream_FiltersAndResumes(t *testing.T) {
+	server, _ := setupAPI(t)
+	client := server.Client()
+	token := login(t, client, server.URL+"/auth/login")
+	base := server.URL + "/api/v1/events/stream"
+
+	stream := openStream(t, client, base+"?type=UserUpdated", token, "")
+	user := createUser(t, client, server.URL+"/api/v1/users", token)
+	updateUser(t, client, server.URL+"/api/v1/users", token, user.ID)
+
+	first := stream.next(t)
+	require.Equal(t, "UserUpdated", first.name, "UserCreated is filtered out")
+	var evt event.Event
+	require.NoError(t, json.Unmarshal([]byte(first.data), &evt))
+	require.Equal(t, first.id, evt.ID)
+	require.Equal(t, user.ID, evt.UserID)
+	stream.close()
+
+	// Events published while disconnected are sent on resume.
+	addFile(t, client, server.URL+"/api/v1/users", token, user.ID)
+	deleteFiles(t, client, server.URL+"/api/v1/users", token, user.ID)
+	resumed := openStream(t, client, base+"?user_id="+itoa(user.ID), token, first.id)
+	require.Equal(t, "FileAdded", resumed.next(t).name)
+	require.Equal(t, "UserFilesCleared", resumed.next(t).name)
+	resumed.close()
+
+	unknown := openStream(t, client, base, token, "no-such-event")
+	require.Equal(t, "resync", unknown.next(t).name)
+	unknown.close()
+
+	resp := doRequest(t, client, http.MethodGet, base+"?type=LoginFailed", token, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHealthz_ReportsDependencies(t *testing.T) {
+	server, _ := setupAPI(t)
+
+	resp := doRequest(t, server.Client(), http.MethodGet, server.URL+"/healthz", "", nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, "ok", body.Status)
+	require.Equal(t, "ok", body.Checks["postgres"])
+}
+
+func TestUserAPI_EnforcesScopes(t *testing.T) {
+	server, _ := setupAPI(t)
+	client := server.Client()
+	baseURL := server.URL
+
+	adminToken := login(t, client, baseURL+"/auth/login")
+	resp := doRequest(t, client, http.MethodPost, baseURL+"/api/v1/accounts", adminToken, service.CreateAccountInput{
+		Username: "viewer",
+		Password: "viewer-password",
+		Role:     "viewer",
+	})
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	user := createUser(t, client, baseURL+"/api/v1/users", adminToken)
+	viewerToken := loginAs(t, client, baseURL+"/auth/login", "viewer", "viewer-password")
+
+	page := listUsers(t, client, baseURL+"/api/v1/users", viewerToken)
+	require.Len(t, page.Users, 1)
+
+	resp = doRequest(t, client, http.MethodDelete, baseURL+"/api/v1/users/"+itoa(user.ID), viewerToken, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	var body struct {
+		Code    string   `json:"code"`
+		Missing []string `json:"missing_scopes"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, "insufficient_scope", body.Code)
+	require.Equal(t, []string{"users:delete"}, body.Missing)
+
+	resp2 := doRequest(t, client, http.MethodGet, baseURL+"/api/v1/accounts", viewerToken, nil)
+	resp2.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp2.StatusCode)
+}
+
+func TestAuth_RefreshRotationAndLogout(t *testing.T) {
+	server, _ := setupAPI(t)
+	client := server.Client()
+	baseURL := server.URL
+
+	first := loginPair(t, client, baseURL+"/auth/login", "admin", "password")
+	require.NotEmpty(t, first.RefreshToken)
+
+	second, status := refresh(t, client, baseURL+"/auth/refresh", first.RefreshToken)
+	require.Equal(t, http.StatusOK, status)
+	require.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	listUsers(t, client, baseURL+"/api/v1/users", second.AccessToken)
+
+	// Replaying the rotated token revokes the whole family, including the
+	// token that replaced it.
+	_, status = refresh(t, client, baseURL+"/auth/refresh", first.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, status)
+	_, status = refresh(t, client, baseURL+"/auth/refresh", second.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	session := loginPair(t, client, baseURL+"/auth/login", "admin", "password")
+	resp := doRequest(t, client, http.MethodPost, baseURL+"/auth/logout", session.AccessToken, map[string]string{
+		"refresh_token": session.RefreshToken,
+	})
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = doRequest(t, client, http.MethodGet, baseURL+"/api/v1/users", session.AccessToken, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	_, status = refresh(t, client, baseURL+"/auth/refresh", session.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestUserAPI_ConditionalRequests(t *testing.T) {
+	server, _ := setupAPI(t)
+	client := server.Client()
+	baseURL := server.URL
+	token := login(t, client, baseURL+"/auth/login")
+	user := createUser(t, client, baseURL+"/api/v1/users", token)
+	userURL := baseURL + "/api/v1/users/" + itoa(user.ID)
+
+	resp := doRequest(t, client, http.MethodGet, userURL, token, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	tag := resp.Header.Get("ETag")
+	require.Equal(t, `"1"`, tag)
+
+	resp = doRequestWithHeaders(t, client, http.MethodGet, userURL, token, map[string]string{"If-None-Match": tag}, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	name := "Renamed"
+	resp = doRequest(t, client, http.MethodPut, userURL, token, service.UpdateUserInput{Name: &name})
+	resp.Body.Close()
+	require.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+
+	resp = doRequestWithHeaders(t, client, http.MethodPut, userURL, token, map[string]string{"If-Match": tag}, service.UpdateUserInput{Name: &name})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `"2"`, resp.Header.Get("ETag"))
+
+	// A second writer still holding the old tag loses.
+	resp = doRequestWithHeaders(t, client, http.MethodPut, userURL, token, map[string]string{"If-Match": tag}, service.UpdateUserInput{Name: &name})
+	resp.Body.Close()
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp = doRequestWithHeaders(t, client, http.MethodDelete, userURL, token, map[string]string{"If-Match": tag}, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = doRequestWithHeaders(t, client, http.MethodDelete, userURL, token, map[string]string{"If-Match": `"2"`}, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestAPIKeys_AuthenticateServiceClients(t *testing.T) {
+	server, _ := setupAPI(t)
+	client := server.Client()
+	baseURL := server.URL
+	adminToken := login(t, client, baseURL+"/auth/login")
+
+	resp := doRequest(t, client, http.MethodPost, baseURL+"/api/v1/api-keys", adminToken, map[string]interface{}{
+		"name":   "nightly-export",
+		"scopes": []string{"users:read"},
+	})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created service.CreatedAPIKey
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	require.True(t, len(created.Key) > len(created.Prefix))
+	require.Equal(t, created.Prefix, created.Key[:len(created.Prefix)])
+
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/v1/users", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-API-Key", created.Key)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Keys work as Bearer tokens too, limited to their scopes.
+	listUsers(t, client, baseURL+"/api/v1/users", created.Key)
+	resp = doRequest(t, client, http.MethodPost, baseURL+"/api/v1/users", created.Key, map[string]interface{}{
+		"name": "Batch", "email": "batch@example.com", "age": 40,
+	})
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Keys have no session to end.
+	resp = doRequest(t, client, http.MethodPost, baseURL+"/auth/logout", created.Key, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = doRequest(t, client, http.MethodGet, baseURL+"/api/v1/api-keys", adminToken, nil)
+	var keys []domain.APIKey
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&keys))
+	resp.Body.Close()
+	require.Len(t, keys, 1)
+	require.NotNil(t, keys[0].LastUsedAt)
+
+	resp = doRequest(t, client, http.MethodDelete, baseURL+"/api/v1/api-keys/"+itoa(created.ID), adminToken, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = doRequest(t, client, http.MethodGet, baseURL+"/api/v1/users", created.Key, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestFiles_UploadRejectsUnknownAndRepeatedFields(t *testing.T) {
+	server, _ := setupAPI(t)
+	client := server.Client()
+	baseURL := server.URL
+	token := login(t, client, baseURL+"/auth/login")
+	user := createUser(t, client, baseURL+"/api/v1/users", token)
+	filesURL := baseURL + "/api/v1/users/" + itoa(user.ID) + "/files"
+
+	upload := func(fields ...string) int {
+		t.Helper()
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		for i := 0; i < len(fields); i += 2 {
+			require.NoError(t, writer.WriteField(fields[i], fields[i+1]))
+		}
+		part, err := writer.CreateFormFile("file", "hello.txt")
+		require.NoError(t, err)
+		_, err = part.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		resp := doRawRequest(t, client, http.MethodPost, filesURL, token, writer.FormDataContentType(), &form)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusBadRequest, upload("padding", "x"))
+	require.Equal(t, http.StatusBadRequest, upload("path", "/a.txt", "path", "/b.txt"))
+	require.Equal(t, http.StatusCreated, upload("path", "/a.txt", "name", "a.txt"))
+}
+
+func TestFiles_UploadAndDownloadContent(t *testing.T) {
+	server, _ := setupAPI(t)
+	client := server.Client()
+	baseURL := server.URL
+	token := login(t, client, baseURL+"/auth/login")
+	user := createUser(t, client, baseURL+"/api/v1/users", token)
+	filesURL := baseURL + "/api/v1/users/" + itoa(user.ID) + "/files"
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	require.NoError(t, writer.WriteField("path", "/notes/hello.txt"))
+	require.NoError(t, writer.WriteField("labels", `{"kind":"note"}`))
+	part, err := writer.CreateFormFile("file", "hello.txt")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("hello, world"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	resp := doRawRequest(t, client, http.MethodPost, filesURL, token, writer.FormDataContentType(), &form)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var file domain.File
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&file))
+	resp.Body.Close()
+	require.Equal(t, "hello.txt", file.Name)
+	require.Equal(t, "/notes/hello.txt", file.Path)
+	require.Equal(t, int64(12), file.Size)
+	require.Equal(t, "09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b", file.SHA256)
+	require.Equal(t, "note", file.Labels["kind"])
+	contentURL := filesURL + "/" + itoa(file.ID) + "/content"
+
+	resp = doRequest(t, client, http.MethodGet, contentURL, token, nil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "hello, world", string(body))
+	require.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Equal(t, `"`+file.SHA256+`"`, resp.Header.Get("ETag"))
+
+	resp = doRequestWithHeaders(t, client, http.MethodGet, contentURL, token, map[string]string{"Range": "bytes=7-"}, nil)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "bytes 7-11/12", resp.Header.Get("Content-Range"))
+	require.Equal(t, "world", string(body))
+
+	resp = doRawRequest(t, client, http.MethodPut, contentURL, token, "", strings.NewReader("<html><body>replaced</body></html>"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&file))
+	resp.Body.Close()
+	require.Equal(t, "text/html; charset=utf-8", file.ContentType)
+	require.Equal(t, int64(34), file.Size)
+
+	resp = doRawRequest(t, client, http.MethodPut, contentURL, token, "text/plain", bytes.NewReader(make([]byte, 2<<10)))
+	resp.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp = doRequest(t, client, http.MethodGet, contentURL, token, nil)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Equal(t, "<html><body>replaced</body></html>", string(body))
+
+	// Files recorded by reference have no content to download.
+	ref := addFile(t, client, baseURL+"/api/v1/users", token, user.ID)
+	resp = doRequest(t, client, http.MethodGet, filesURL+"/"+itoa(ref.ID)+"/content", token, nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func setupAPI(t *testing.T) (*httptest.Server, *event.InMemoryPublisher) {
+	t.Helper()
+
+	dsn := testutil.StartPostgres(t)
+	repo := testutil.ConnectRepository(t, dsn)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = repo.Truncate(ctx)
+		require.NoError(t, repo.Close())
+	})
+
+	publisher := event.NewInMemoryPublisher()
+	eventStore := postgresstorage.NewEventStore(repo)
+	// The stream's hub is fed directly rather than through the relay.
+	hub := event.NewHub(100, 0)
+	t.Cleanup(hub.Close)
+	userSvc := service.NewUserService(repo, repo, repo, event.Publishers{eventStore, publisher, hub})
+	// Replays go to the same in-memory publisher, whatever the target.
+	eventSvc := service.NewEventService(eventStore, event.NewReplayer(eventStore, 1, nil),
+		func(event.ReplayTarget) (event.Publisher, func(), error) { return publisher, func() {}, nil })
+
+	auditPublisher := event.NewInMemoryPublisher()
+	accountSvc := service.NewAccountService(repo, repo, auditPublisher)
+	require.NoError(t, accountSvc.Bootstrap(context.Background(), "admin", "password"))
+	issuer := auth.NewTokenIssuer(auth.NewHMACKeySet("secret"), "user-api-test", time.Minute*15)
+	sessionSvc := service.NewSessionService(accountSvc, repo, repo, auditPublisher, issuer, time.Hour)
+	apiKeySvc := service.NewAPIKeyService(repo, repo, auditPublisher)
+
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	fileSvc := service.NewFileContentService(userSvc, repo, blobs, 1<<10)
+	userHandler := handler.NewUserHandler(userSvc, fileSvc)
+	authHandler := handler.NewAuthHandler(sessionSvc, issuer.Keys())
+	authMW := middleware.NewAuth(issuer, sessionSvc, apiKeySvc)
+
+	router := httptransport.NewRouter(httptransport.RouterDeps{
+		UserHandler:    userHandler,
+		AccountHandler: handler.NewAccountHandler(accountSvc),
+		APIKeyHandler:  handler.NewAPIKeyHandler(apiKeySvc),
+		EventHandler:   handler.NewEventHandler(eventSvc),
+		WebhookHandler: handler.NewWebhookHandler(service.NewWebhookService(repo)),
+		StreamHandler:  handler.NewStreamHandler(hub, time.Minute),
+		AuthHandler:    authHandler,
+		HealthHandler:  handler.NewHealthHandler(map[string]handler.HealthCheck{"postgres": repo.Ping}),
+		Auth:           authMW,
+	})
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return server, publisher
 }
 
 func login(t *testing.T, client *http.Client, url string) string {
+	return loginAs(t, client, url, "admin", "password")
+}
+
+func loginAs(t *testing.T, client *http.Client, url, username, password string) string {
+	return loginPair(t, client, url, username, password).AccessToken
+}
+
+func loginPair(t *testing.T, client *http.Client, url, username, password string) service.TokenPair {
 	resp := doRequest(t, client, http.MethodPost, url, "", map[string]string{
-		"username": "admin",
-		"password": "password",
+		"username": username,
+		"password": password,
 	})
 	defer resp.Body.Close()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
-	var body map[string]string
-	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
-	return body["token"]
+	var pair service.TokenPair
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&pair))
+	return pair
+}
+
+func refresh(t *testing.T, client *http.Client, url, refreshToken string) (service.TokenPair, int) {
+	resp := doRequest(t, client, http.MethodPost, url, "", map[string]string{
+		"refresh_token": refreshToken,
+	})
+	defer resp.Body.Close()
+	var pair service.TokenPair
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&pair))
+	}
+	return pair, resp.StatusCode
 }
 
 func createUser(t *testing.T, client *http.Client, base, token string) domain.User {
@@ -104,19 +608,19 @@ func createUser(t *testing.T, client *http.Client, base, token string) domain.Us
 	return user
 }
 
-func listUsers(t *testing.T, client *http.Client, base, token string) []domain.User {
-	resp := doRequest(t, client, http.MethodGet, base, token, nil)
+func listUsers(t *testing.T, client *http.Client, url, token string) repository.UserPage {
+	resp := doRequest(t, client, http.MethodGet, url, token, nil)
 	defer resp.Body.Close()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
-	var users []domain.User
-	require.NoError(t, json.NewDecoder(resp.Body).Decode(&users))
-	return users
+	var page repository.UserPage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	return page
 }
 
 func updateUser(t *testing.T, client *http.Client, base, token string, userID uint) domain.User {
 	url := base + "/" + itoa(userID)
 	newAge := 31
-	resp := doRequest(t, client, http.MethodPut, url, token, service.UpdateUserInput{
+	resp := doRequestWithHeaders(t, client, http.MethodPut, url, token, map[string]string{"If-Match": "*"}, service.UpdateUserInput{
 		Age: &newAge,
 	})
 	defer resp.Body.Close()
@@ -158,13 +662,18 @@ func deleteFiles(t *testing.T, client *http.Client, base, token string, userID u
 
 func deleteUser(t *testing.T, client *http.Client, base, token string, userID uint) {
 	url := base + "/" + itoa(userID)
-	resp := doRequest(t, client, http.MethodDelete, url, token, nil)
+	resp := doRequestWithHeaders(t, client, http.MethodDelete, url, token, map[string]string{"If-Match": "*"}, nil)
 	defer resp.Body.Close()
 	require.Equal(t, http.StatusNoContent, resp.StatusCode)
 }
 
 func doRequest(t *testing.T, client *http.Client, method, url, token string, payload interface{}) *http.Response {
 	t.Helper()
+	return doRequestWithHeaders(t, client, method, url, token, nil, payload)
+}
+
+func doRequestWithHeaders(t *testing.T, client *http.Client, method, url, token string, headers map[string]string, payload interface{}) *http.Response {
+	t.Helper()
 
 	var body []byte
 	var err error
@@ -179,12 +688,92 @@ func doRequest(t *testing.T, client *http.Client, method, url, token string, pay
 	if token != "" {
 		req.Header.Set("Authorization", "Bearer "+token)
 	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 
 	resp, err := client.Do(req)
 	require.NoError(t, err)
 	return resp
 }
 
+func doRawRequest(t *testing.T, client *http.Client, method, url, token, contentType string, body io.Reader) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+type sseEvent struct {
+	id, name, data string
+}
+
+// sseStream reads Server-Sent Events from an open response on a separate
+// goroutine, so that tests can wait for events with a timeout.
+type sseStream struct {
+	resp   *http.Response
+	events chan sseEvent
+}
+
+func openStream(t *testing.T, client *http.Client, url, token, lastEventID string) *sseStream {
+	t.Helper()
+	headers := map[string]string{}
+	if lastEventID != "" {
+		headers["Last-Event-ID"] = lastEventID
+	}
+	resp := doRequestWithHeaders(t, client, http.MethodGet, url, token, headers, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	s := &sseStream{resp: resp, events: make(chan sseEvent, 16)}
+	go func() {
+		defer close(s.events)
+		scanner := bufio.NewScanner(resp.Body)
+		var evt sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if evt.name != "" {
+					s.events <- evt
+				}
+				evt = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				evt.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				evt.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				evt.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return s
+}
+
+func (s *sseStream) next(t *testing.T) sseEvent {
+	t.Helper()
+	select {
+	case evt, ok := <-s.events:
+		require.True(t, ok, "stream ended")
+		return evt
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return sseEvent{}
+	}
+}
+
+func (s *sseStream) close() {
+	s.resp.Body.Close()
+}
+
 func itoa(v uint) string {
 	return strconv.FormatUint(uint64(v), 10)
 }
@@ -0,0 +1,233 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Encoding selects how a RabbitPublisher lays out events on the wire.
+type Encoding string
+
+const (
+	// EncodingJSON publishes the Event envelope as the message body.
+	EncodingJSON Encoding = "json"
+	// EncodingCloudEventsStructured publishes a CloudEvents 1.0 JSON
+	// document with content type application/cloudevents+json.
+	EncodingCloudEventsStructured Encoding = "cloudevents-structured"
+	// EncodingCloudEventsBinary publishes the payload as the body and the
+	// CloudEvents attributes as "cloudEvents:"-prefixed headers, following
+	// the CloudEvents AMQP binding.
+	EncodingCloudEventsBinary Encoding = "cloudevents-binary"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsContentType = "application/cloudevents+json"
+	jsonContentType        = "application/json"
+)
+
+// cloudEvent is the structured-mode representation of an event. Envelope
+// fields without a CloudEvents counterpart travel as extension attributes,
+// whose names the specification limits to lowercase letters and digits.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	SchemaVersion   int             `json:"schemaversion,omitempty"`
+	UserID          uint            `json:"userid,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	CausationID     string          `json:"causationid,omitempty"`
+}
+
+// ParseEncoding validates an encoding name; the empty string selects
+// EncodingJSON.
+func ParseEncoding(name string) (Encoding, error) {
+	switch enc := Encoding(name); enc {
+	case "":
+		return EncodingJSON, nil
+	case EncodingJSON, EncodingCloudEventsStructured, EncodingCloudEventsBinary:
+		return enc, nil
+	default:
+		return "", fmt.Errorf("unknown event encoding %q", name)
+	}
+}
+
+// encodeMessage lays out evt as an AMQP message in the given encoding.
+func encodeMessage(evt Event, enc Encoding) (amqp.Publishing, error) {
+	msg := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		MessageId:    evt.ID,
+		Timestamp:    evt.OccurredAt,
+		Type:         string(evt.Type),
+	}
+	if enc == EncodingJSON {
+		body, err := json.Marshal(evt)
+		if err != nil {
+			return amqp.Publishing{}, fmt.Errorf("marshal event: %w", err)
+		}
+		msg.ContentType = jsonContentType
+		msg.Body = body
+		return msg, nil
+	}
+
+	ce, err := toCloudEvent(evt)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	if enc == EncodingCloudEventsStructured {
+		body, err := json.Marshal(ce)
+		if err != nil {
+			return amqp.Publishing{}, fmt.Errorf("marshal cloudevent: %w", err)
+		}
+		msg.ContentType = cloudEventsContentType
+		msg.Body = body
+		return msg, nil
+	}
+
+	headers := amqp.Table{
+		"cloudEvents:specversion": ce.SpecVersion,
+		"cloudEvents:id":          ce.ID,
+		"cloudEvents:source":      ce.Source,
+		"cloudEvents:type":        ce.Type,
+		"cloudEvents:time":        ce.Time.Format(time.RFC3339Nano),
+	}
+	optional := map[string]string{
+		"subject":       ce.Subject,
+		"correlationid": ce.CorrelationID,
+		"causationid":   ce.CausationID,
+	}
+	for name, value := range optional {
+		if value != "" {
+			headers["cloudEvents:"+name] = value
+		}
+	}
+	if ce.SchemaVersion != 0 {
+		headers["cloudEvents:schemaversion"] = int32(ce.SchemaVersion)
+	}
+	if ce.UserID != 0 {
+		headers["cloudEvents:userid"] = int64(ce.UserID)
+	}
+	msg.Headers = headers
+	msg.ContentType = ce.DataContentType
+	msg.Body = ce.Data
+	return msg, nil
+}
+
+func toCloudEvent(evt Event) (cloudEvent, error) {
+	ce := cloudEvent{
+		SpecVersion:   cloudEventsSpecVersion,
+		ID:            evt.ID,
+		Source:        evt.Source,
+		Type:          string(evt.Type),
+		Time:          evt.OccurredAt,
+		SchemaVersion: evt.Version,
+		UserID:        evt.UserID,
+		CorrelationID: evt.CorrelationID,
+		CausationID:   evt.CausationID,
+	}
+	if evt.UserID != 0 {
+		ce.Subject = "users/" + strconv.FormatUint(uint64(evt.UserID), 10)
+	}
+	if evt.Payload != nil {
+		data, err := json.Marshal(evt.Payload)
+		if err != nil {
+			return cloudEvent{}, fmt.Errorf("marshal %s payload: %w", evt.Type, err)
+		}
+		ce.DataContentType = jsonContentType
+		ce.Data = data
+	}
+	return ce, nil
+}
+
+// decodeMessage reads an event in any of the encodings, so consumers keep
+// working while publishers switch between them.
+func decodeMessage(contentType string, headers amqp.Table, body []byte) (Event, error) {
+	if strings.HasPrefix(contentType, cloudEventsContentType) {
+		var ce cloudEvent
+		if err := json.Unmarshal(body, &ce); err != nil {
+			return Event{}, fmt.Errorf("decode cloudevent: %w", err)
+		}
+		return fromCloudEvent(ce)
+	}
+	if _, ok := cloudEventsHeader(headers, "specversion"); ok {
+		ce := cloudEvent{DataContentType: contentType, Data: body}
+		ce.SpecVersion, _ = cloudEventsHeader(headers, "specversion")
+		ce.ID, _ = cloudEventsHeader(headers, "id")
+		ce.Source, _ = cloudEventsHeader(headers, "source")
+		ce.Type, _ = cloudEventsHeader(headers, "type")
+		ce.Subject, _ = cloudEventsHeader(headers, "subject")
+		ce.CorrelationID, _ = cloudEventsHeader(headers, "correlationid")
+		ce.CausationID, _ = cloudEventsHeader(headers, "causationid")
+		if v, ok := cloudEventsHeader(headers, "time"); ok {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return Event{}, fmt.Errorf("decode cloudevent time: %w", err)
+			}
+			ce.Time = t
+		}
+		if v, ok := cloudEventsHeader(headers, "schemaversion"); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return Event{}, fmt.Errorf("decode cloudevent schemaversion: %w", err)
+			}
+			ce.SchemaVersion = n
+		}
+		if v, ok := cloudEventsHeader(headers, "userid"); ok {
+			n, err := strconv.ParseUint(v, 10, 0)
+			if err != nil {
+				return Event{}, fmt.Errorf("decode cloudevent userid: %w", err)
+			}
+			ce.UserID = uint(n)
+		}
+		return fromCloudEvent(ce)
+	}
+
+	var evt Event
+	if err := json.Unmarshal(body, &evt); err != nil {
+		return Event{}, fmt.Errorf("decode event: %w", err)
+	}
+	return evt, nil
+}
+
+func fromCloudEvent(ce cloudEvent) (Event, error) {
+	if ce.SpecVersion != cloudEventsSpecVersion {
+		return Event{}, fmt.Errorf("unsupported cloudevents specversion %q", ce.SpecVersion)
+	}
+	evt := Event{
+		ID:            ce.ID,
+		Type:          Type(ce.Type),
+		Version:       ce.SchemaVersion,
+		Source:        ce.Source,
+		UserID:        ce.UserID,
+		CorrelationID: ce.CorrelationID,
+		CausationID:   ce.CausationID,
+		OccurredAt:    ce.Time,
+	}
+	if len(ce.Data) > 0 {
+		if err := json.Unmarshal(ce.Data, &evt.Payload); err != nil {
+			return Event{}, fmt.Errorf("decode cloudevent data: %w", err)
+		}
+	}
+	return evt, nil
+}
+
+// cloudEventsHeader reads a binary-mode attribute. The AMQP binding allows
+// both the "cloudEvents:" and the "cloudEvents_" prefix.
+func cloudEventsHeader(headers amqp.Table, name string) (string, bool) {
+	for _, prefix := range []string{"cloudEvents:", "cloudEvents_"} {
+		if v, ok := headers[prefix+name]; ok && v != nil {
+			return fmt.Sprint(v), true
+		}
+	}
+	return "", false
+}
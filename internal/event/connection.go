@@ -0,0 +1,244 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotConnected is returned while the broker connection is down.
+var ErrNotConnected = errors.New("rabbitmq not connected")
+
+// ConnectionState describes a Connection for health checks.
+type ConnectionState int32
+
+const (
+	StateConnected ConnectionState = iota
+	StateReconnecting
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ConnectionState(%d)", int32(s))
+	}
+}
+
+// ReconnectConfig bounds the wait between reconnect attempts, which doubles
+// from MinBackoff up to MaxBackoff. Zero values select the defaults.
+type ReconnectConfig struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Connection is a supervised broker connection shared by publishers and
+// consumers. When the broker goes away it redials with backoff and declares
+// the registered topology again before reporting itself connected.
+type Connection struct {
+	dsn string
+	cfg ReconnectConfig
+	log *logrus.Logger
+
+	mu       sync.RWMutex
+	conn     *amqp.Connection
+	state    ConnectionState
+	ready    chan struct{} // closed while connected
+	topology []func(*amqp.Channel) error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// DialConnection connects to the broker. The first dial must succeed; later
+// connection losses are repaired in the background.
+func DialConnection(dsn string, cfg ReconnectConfig, log *logrus.Logger) (*Connection, error) {
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if log == nil {
+		log = logrus.New()
+	}
+	c := &Connection{
+		dsn:   dsn,
+		cfg:   cfg,
+		log:   log,
+		state: StateReconnecting,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	closed, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	go c.supervise(closed)
+	return c, nil
+}
+
+// Declare registers topology, such as exchanges, queues and bindings, and
+// declares it right away when connected. It is declared again after every
+// reconnect, since a restarted broker may have lost non-durable entities.
+func (c *Connection) Declare(fn func(*amqp.Channel) error) error {
+	c.mu.Lock()
+	c.topology = append(c.topology, fn)
+	conn := c.conn
+	c.mu.Unlock()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("rabbitmq channel: %w", err)
+	}
+	defer ch.Close()
+	return fn(ch)
+}
+
+// Channel opens a channel on the current connection, or fails with
+// ErrNotConnected while reconnecting.
+func (c *Connection) Channel() (*amqp.Channel, error) {
+	c.mu.RLock()
+	conn, state := c.conn, c.state
+	c.mu.RUnlock()
+	if state != StateConnected {
+		return nil, ErrNotConnected
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("rabbitmq channel: %w", err)
+	}
+	return ch, nil
+}
+
+// State reports the current connection state.
+func (c *Connection) State() ConnectionState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// Check fails unless the connection is up. It suits health checks.
+func (c *Connection) Check(context.Context) error {
+	if state := c.State(); state != StateConnected {
+		return fmt.Errorf("%w: %s", ErrNotConnected, state)
+	}
+	return nil
+}
+
+// waitReady blocks until the connection is up, ctx is done or the
+// connection is closed.
+func (c *Connection) waitReady(ctx context.Context) error {
+	c.mu.RLock()
+	ready := c.ready
+	c.mu.RUnlock()
+	select {
+	case <-ready:
+		return nil
+	case <-c.done:
+		return ErrNotConnected
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops reconnecting and closes the current connection.
+func (c *Connection) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.state = StateClosed
+		if c.conn != nil {
+			err = c.conn.Close()
+		}
+	})
+	return err
+}
+
+// connect dials and declares the topology. The returned channel reports
+// when the new connection goes away.
+func (c *Connection) connect() (<-chan *amqp.Error, error) {
+	conn, err := amqp.Dial(c.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("rabbitmq dial: %w", err)
+	}
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.topology) > 0 {
+		ch, err := conn.Channel()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("rabbitmq channel: %w", err)
+		}
+		for _, declare := range c.topology {
+			if err := declare(ch); err != nil {
+				ch.Close()
+				conn.Close()
+				return nil, err
+			}
+		}
+		ch.Close()
+	}
+	select {
+	case <-c.done:
+		conn.Close()
+		return nil, ErrNotConnected
+	default:
+	}
+	c.conn = conn
+	c.state = StateConnected
+	close(c.ready)
+	return closed, nil
+}
+
+func (c *Connection) supervise(closed <-chan *amqp.Error) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case reason := <-closed:
+			c.mu.Lock()
+			if c.state == StateClosed {
+				c.mu.Unlock()
+				return
+			}
+			c.state = StateReconnecting
+			c.ready = make(chan struct{})
+			c.mu.Unlock()
+			c.log.WithField("reason", reason).Warn("rabbitmq connection lost; reconnecting")
+		}
+
+		backoff := c.cfg.MinBackoff
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-time.After(backoff):
+			}
+			var err error
+			if closed, err = c.connect(); err == nil {
+				c.log.Info("rabbitmq connection restored")
+				break
+			}
+			if backoff *= 2; backoff > c.cfg.MaxBackoff {
+				backoff = c.cfg.MaxBackoff
+			}
+			c.log.WithError(err).WithField("retryIn", backoff).Warn("rabbitmq reconnect failed")
+		}
+	}
+}
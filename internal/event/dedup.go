@@ -0,0 +1,198 @@
+package event
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ProcessedStore remembers the IDs of events that are being or have been
+// handled. Entries only need to be kept until they expire.
+type ProcessedStore interface {
+	// Claim records id until leaseUntil and reports whether this call did
+	// so. It must be atomic: of several concurrent claims for an id without
+	// an unexpired record, exactly one succeeds.
+	Claim(ctx context.Context, id string, leaseUntil time.Time) (bool, error)
+	// Complete keeps the record of a handled id until expiresAt.
+	Complete(ctx context.Context, id string, expiresAt time.Time) error
+	// Release forgets id, so that the event can be claimed again.
+	Release(ctx context.Context, id string) error
+}
+
+// Deduplicator makes handlers idempotent by skipping events whose ID was
+// already handled within the TTL. Broker redeliveries and outbox retries
+// can deliver an event more than once, and the copies may be handled by
+// several workers at the same moment. The ID is therefore claimed before
+// the handler runs: only the worker holding the claim handles the event,
+// and copies are acknowledged without calling the handler. The claim is a
+// short lease, kept for the TTL only once the handler succeeds; a failed
+// attempt releases it so that the retry runs, and one abandoned by a
+// crashed consumer expires in time for the broker's redelivery. Events
+// without an ID, published before the envelope carried one, are always
+// handled, and those re-sent by a replay with ReplayTarget.Reprocess are
+// deduplicated per replay.
+type Deduplicator struct {
+	store ProcessedStore
+	ttl   time.Duration
+	lease time.Duration
+	log   *logrus.Logger
+	scope string
+}
+
+// NewDeduplicator remembers handled events for ttl. lease bounds how long a
+// claim blocks copies while its handler runs, so it should outlast the
+// slowest handler.
+func NewDeduplicator(store ProcessedStore, ttl, lease time.Duration, log *logrus.Logger) *Deduplicator {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	if lease <= 0 {
+		lease = 5 * time.Minute
+	}
+	if log == nil {
+		log = logrus.New()
+	}
+	return &Deduplicator{store: store, ttl: ttl, lease: lease, log: log}
+}
+
+// Scoped returns a Deduplicator sharing d's store whose records are kept
+// apart from those of other scopes, so that several consumers can each
+// handle every event once.
+func (d *Deduplicator) Scoped(scope string) *Deduplicator {
+	scoped := *d
+	scoped.scope = scope
+	return &scoped
+}
+
+// Wrap returns handler guarded against duplicates.
+func (d *Deduplicator) Wrap(handler Handler) Handler {
+	return func(ctx context.Context, evt Event) error {
+		if evt.ID == "" {
+			return handler(ctx, evt)
+		}
+		key := evt.ID
+		if replay := ReplayID(ctx); replay != "" {
+			// A reprocessing replay is handled apart from earlier copies.
+			key = replay + "/" + key
+		}
+		if d.scope != "" {
+			key = d.scope + "/" + key
+		}
+		claimed, err := d.store.Claim(ctx, key, time.Now().UTC().Add(d.lease))
+		if err != nil {
+			return fmt.Errorf("claim event: %w", err)
+		}
+		if !claimed {
+			d.log.WithFields(logrus.Fields{
+				"eventID": evt.ID,
+				"type":    evt.Type,
+			}).Debug("skipping duplicate event")
+			return nil
+		}
+		if err := handler(ctx, evt); err != nil {
+			// Release even when shutting down, or the redelivery would be
+			// skipped as a duplicate until the claim expires.
+			if relErr := d.store.Release(context.WithoutCancel(ctx), key); relErr != nil {
+				d.log.WithError(relErr).WithField("eventID", evt.ID).Error("releasing event claim failed; redeliveries will be skipped until it expires")
+			}
+			return err
+		}
+		if err := d.store.Complete(context.WithoutCancel(ctx), key, time.Now().UTC().Add(d.ttl)); err != nil {
+			// The event was handled; a copy arriving after the lease runs
+			// out is handled again.
+			d.log.WithError(err).WithField("eventID", evt.ID).Warn("recording handled event failed")
+		}
+		return nil
+	}
+}
+
+// LRUProcessedStore keeps the most recently processed event IDs in memory.
+// It suits a single consumer process; use a shared store when several
+// processes consume the same queue or deduplication must survive restarts.
+type LRUProcessedStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front is the most recently seen
+	now      func() time.Time
+}
+
+type lruEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+// NewLRUProcessedStore keeps up to capacity IDs, evicting the least recently
+// seen first.
+func NewLRUProcessedStore(capacity int) *LRUProcessedStore {
+	if capacity <= 0 {
+		capacity = 10000
+	}
+	return &LRUProcessedStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+func (s *LRUProcessedStore) Claim(_ context.Context, id string, leaseUntil time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[id]; ok {
+		entry := el.Value.(*lruEntry)
+		s.order.MoveToFront(el)
+		if s.now().Before(entry.expiresAt) {
+			return false, nil
+		}
+		entry.expiresAt = leaseUntil
+		return true, nil
+	}
+	s.push(id, leaseUntil)
+	return true, nil
+}
+
+func (s *LRUProcessedStore) Complete(_ context.Context, id string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[id]; ok {
+		el.Value.(*lruEntry).expiresAt = expiresAt
+		return nil
+	}
+	s.push(id, expiresAt)
+	return nil
+}
+
+func (s *LRUProcessedStore) Release(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[id]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+// Len reports how many IDs are held, including expired ones not yet evicted.
+func (s *LRUProcessedStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *LRUProcessedStore) push(id string, expiresAt time.Time) {
+	s.entries[id] = s.order.PushFront(&lruEntry{id: id, expiresAt: expiresAt})
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+}
+
+func (s *LRUProcessedStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*lruEntry).id)
+}
+
+var _ ProcessedStore = (*LRUProcessedStore)(nil)
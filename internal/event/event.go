@@ -2,30 +2,223 @@ package event
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type Type string
 
 const (
-	UserCreated Type = "UserCreated"
-	UserUpdated Type = "UserUpdated"
-	UserDeleted Type = "UserDeleted"
+	UserCreated  Type = "UserCreated"
+	UserUpdated  Type = "UserUpdated"
+	UserDeleted  Type = "UserDeleted"
+	UserRestored Type = "UserRestored"
+	UserPurged   Type = "UserPurged"
+
+	// File events carry the file as payload; UserFilesCleared carries all
+	// files that were removed.
+	FileAdded        Type = "FileAdded"
+	FileDeleted      Type = "FileDeleted"
+	UserFilesCleared Type = "UserFilesCleared"
+
+	// Audit events about operator accounts. UserID is zero; the payload
+	// identifies the account.
+	LoginSucceeded         Type = "LoginSucceeded"
+	LoginFailed            Type = "LoginFailed"
+	AccountCreated         Type = "AccountCreated"
+	AccountDisabled        Type = "AccountDisabled"
+	AccountPasswordRotated Type = "AccountPasswordRotated"
+	RefreshTokenReused     Type = "RefreshTokenReused"
+	APIKeyCreated          Type = "APIKeyCreated"
+	APIKeyRevoked          Type = "APIKeyRevoked"
 )
 
+// routingKeys maps event types to topic routing keys. User and file events
+// share the "user." prefix and file events "user.file.", so consumers can
+// bind to families of events with patterns such as "user.file.*".
+var routingKeys = map[Type]string{
+	UserCreated:            "user.created",
+	UserUpdated:            "user.updated",
+	UserDeleted:            "user.deleted",
+	UserRestored:           "user.restored",
+	UserPurged:             "user.purged",
+	FileAdded:              "user.file.added",
+	FileDeleted:            "user.file.deleted",
+	UserFilesCleared:       "user.file.cleared",
+	LoginSucceeded:         "audit.login.succeeded",
+	LoginFailed:            "audit.login.failed",
+	AccountCreated:         "audit.account.created",
+	AccountDisabled:        "audit.account.disabled",
+	AccountPasswordRotated: "audit.account.password_rotated",
+	RefreshTokenReused:     "audit.token.reused",
+	APIKeyCreated:          "audit.api_key.created",
+	APIKeyRevoked:          "audit.api_key.revoked",
+}
+
+// RoutingKey is the topic routing key events of this type are published
+// with. Types without a registered key fall back to their lowercased name.
+func (t Type) RoutingKey() string {
+	if key, ok := routingKeys[t]; ok {
+		return key
+	}
+	return strings.ToLower(string(t))
+}
+
+// IsUserEvent reports whether t is a user or file event rather than an
+// audit event.
+func (t Type) IsUserEvent() bool {
+	return strings.HasPrefix(t.RoutingKey(), "user.")
+}
+
+// Event is the envelope every event travels in. ID is unique per event and
+// Version is the schema version of the payload, see Type.SchemaVersion.
+// CorrelationID is shared by all events caused by the same request, and
+// CausationID is the ID of the event whose handling produced this one.
+// Source identifies the publishing service and is set by the publisher.
+// Events recorded before the envelope was versioned have neither ID nor
+// Version.
 type Event struct {
-	Type       Type        `json:"type"`
-	UserID     uint        `json:"user_id"`
-	Payload    interface{} `json:"payload"`
-	OccurredAt time.Time   `json:"occurred_at"`
+	ID            string      `json:"id,omitempty"`
+	Type          Type        `json:"type"`
+	Version       int         `json:"version,omitempty"`
+	Source        string      `json:"source,omitempty"`
+	UserID        uint        `json:"user_id"`
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	CausationID   string      `json:"causation_id,omitempty"`
+	Payload       interface{} `json:"payload"`
+	OccurredAt    time.Time   `json:"occurred_at"`
+}
+
+// New builds an event of the current schema version with a fresh ID. The
+// correlation and causation IDs are taken from ctx; without a correlation
+// ID the event starts a new chain and correlates to itself.
+func New(ctx context.Context, typ Type, userID uint, payload interface{}) Event {
+	evt := Event{
+		ID:          uuid.NewString(),
+		Type:        typ,
+		Version:     typ.SchemaVersion(),
+		UserID:      userID,
+		CausationID: causationID(ctx),
+		Payload:     payload,
+		OccurredAt:  time.Now().UTC(),
+	}
+	evt.CorrelationID = CorrelationID(ctx)
+	if evt.CorrelationID == "" {
+		evt.CorrelationID = evt.ID
+	}
+	return evt
+}
+
+type contextKey int
+
+const (
+	correlationKey contextKey = iota
+	causationKey
+	replayKey
+)
+
+// WithCorrelationID returns a context whose events carry id as their
+// correlation ID.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey, id)
+}
+
+// CorrelationID returns the correlation ID set on ctx, if any.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey).(string)
+	return id
+}
+
+// WithReplayID returns a context for handling a copy of an event re-sent
+// by the replay with the given ID; see ReplayTarget.Reprocess.
+func WithReplayID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, replayKey, id)
+}
+
+// ReplayID returns the ID of the replay that re-sent the event handled in
+// ctx, if any.
+func ReplayID(ctx context.Context) string {
+	id, _ := ctx.Value(replayKey).(string)
+	return id
+}
+
+func causationID(ctx context.Context) string {
+	id, _ := ctx.Value(causationKey).(string)
+	return id
+}
+
+// ContextFor returns the context to handle evt in: events published while
+// handling it share its correlation ID and name it as their cause.
+func ContextFor(ctx context.Context, evt Event) context.Context {
+	if evt.CorrelationID != "" {
+		ctx = WithCorrelationID(ctx, evt.CorrelationID)
+	}
+	if evt.ID != "" {
+		ctx = context.WithValue(ctx, causationKey, evt.ID)
+	}
+	return ctx
+}
+
+// DecodePayload converts the payload into v. Events read from the broker
+// hold a generic JSON value; events published in-process hold the original
+// value. Both are handled by a JSON round trip.
+func (e Event) DecodePayload(v interface{}) error {
+	raw, err := json.Marshal(e.Payload)
+	if err != nil {
+		return fmt.Errorf("encode %s payload: %w", e.Type, err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("decode %s payload: %w", e.Type, err)
+	}
+	return nil
 }
 
 type Publisher interface {
 	Publish(ctx context.Context, evt Event) error
 }
 
+// Publishers publishes every event to each publisher in turn and stops at
+// the first failure. Combining transactional publishers, such as the event
+// store and the outbox, records an event in all of them or in none.
+type Publishers []Publisher
+
+func (ps Publishers) Publish(ctx context.Context, evt Event) error {
+	for _, p := range ps {
+		if err := p.Publish(ctx, evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type Consumer interface {
 	Consume(ctx context.Context, handler Handler) error
 }
 
 type Handler func(ctx context.Context, evt Event) error
+
+// Permanent marks a handler error as one that retrying cannot fix, such as
+// an event the handler does not understand. Consumers dead-letter such
+// deliveries at once.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// IsPermanent reports whether err, or an error it wraps, was marked with
+// Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
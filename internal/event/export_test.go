@@ -0,0 +1,10 @@
+package event
+
+// DropConnection closes the underlying broker connection as if the broker
+// had gone away.
+func DropConnection(c *Connection) {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	conn.Close()
+}
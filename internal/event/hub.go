@@ -0,0 +1,157 @@
+package event
+
+import (
+	"context"
+	"sync"
+)
+
+// Hub fans events out to in-process subscribers, such as the clients of
+// the event stream. It keeps the most recent events so that a subscriber
+// that reconnects can resume after the last event it saw. Publishing never
+// blocks: a subscriber that falls a full buffer behind is dropped and has
+// to resubscribe.
+type Hub struct {
+	mu      sync.Mutex
+	subs    map[*Subscription]struct{}
+	history []Event // oldest first, at most historySize
+	seen    map[string]struct{}
+	size    int
+	buffer  int
+	closed  bool
+}
+
+// Subscription receives the events of a Hub that match its filter. C is
+// closed when the subscription ends, either by Close or because the
+// subscriber fell behind; Lagged tells the two apart.
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	match  func(Event) bool
+	hub    *Hub
+	lagged bool
+}
+
+// NewHub keeps the last historySize events and buffers up to bufferSize
+// events per subscriber.
+func NewHub(historySize, bufferSize int) *Hub {
+	if historySize <= 0 {
+		historySize = 1000
+	}
+	if bufferSize <= 0 {
+		bufferSize = 64
+	}
+	return &Hub{
+		subs:   make(map[*Subscription]struct{}),
+		seen:   make(map[string]struct{}),
+		size:   historySize,
+		buffer: bufferSize,
+	}
+}
+
+// Publish hands evt to every matching subscriber. Events whose ID is still
+// in the history were published before and are ignored, so feeding the hub
+// from a path that may repeat events is safe.
+func (h *Hub) Publish(_ context.Context, evt Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if evt.ID != "" {
+		if _, ok := h.seen[evt.ID]; ok {
+			return nil
+		}
+		h.seen[evt.ID] = struct{}{}
+	}
+	h.history = append(h.history, evt)
+	if len(h.history) > h.size {
+		delete(h.seen, h.history[0].ID)
+		h.history = h.history[1:]
+	}
+	for sub := range h.subs {
+		if !sub.match(evt) {
+			continue
+		}
+		select {
+		case sub.ch <- evt:
+		default:
+			sub.lagged = true
+			h.remove(sub)
+		}
+	}
+	return nil
+}
+
+// Subscribe starts receiving events matching match, or every event if it
+// is nil. With a lastEventID, backlog holds the matching events published
+// after it; resumed is false when that event is no longer in the history,
+// in which case events may have been missed.
+func (h *Hub) Subscribe(lastEventID string, match func(Event) bool) (sub *Subscription, backlog []Event, resumed bool) {
+	if match == nil {
+		match = func(Event) bool { return true }
+	}
+	ch := make(chan Event, h.buffer)
+	sub = &Subscription{C: ch, ch: ch, match: match, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return sub, nil, true
+	}
+	h.subs[sub] = struct{}{}
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+	for i := len(h.history) - 1; i >= 0; i-- {
+		if h.history[i].ID != lastEventID {
+			continue
+		}
+		for _, evt := range h.history[i+1:] {
+			if match(evt) {
+				backlog = append(backlog, evt)
+			}
+		}
+		return sub, backlog, true
+	}
+	return sub, nil, false
+}
+
+// Subscribers reports how many subscriptions are active.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Close ends every subscription, now and in future, e.g. so that streaming
+// responses finish when the server shuts down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		h.remove(sub)
+	}
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// Lagged reports whether the subscription was dropped for falling behind.
+// Read it once C is closed.
+func (s *Subscription) Lagged() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.lagged
+}
+
+var _ Publisher = (*Hub)(nil)
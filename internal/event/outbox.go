@@ -0,0 +1,143 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OutboxMessage is an event recorded in the outbox that still awaits delivery.
+type OutboxMessage struct {
+	ID       uint64
+	Event    Event
+	Attempts int
+}
+
+// OutboxStore gives the relay access to undelivered outbox messages.
+// Pending is expected to lock the returned rows for the surrounding
+// transaction so that concurrent relays never deliver the same message.
+type OutboxStore interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	Pending(ctx context.Context, limit int) ([]OutboxMessage, error)
+	MarkDelivered(ctx context.Context, id uint64) error
+	MarkFailed(ctx context.Context, id uint64, nextAttempt time.Time, reason string) error
+}
+
+type RelayConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	MaxBackoff   time.Duration
+}
+
+// OutboxRelay drains the outbox to a Publisher, retrying failed deliveries
+// with exponential backoff.
+type OutboxRelay struct {
+	store     OutboxStore
+	publisher Publisher
+	cfg       RelayConfig
+	log       *logrus.Logger
+}
+
+func NewOutboxRelay(store OutboxStore, publisher Publisher, cfg RelayConfig, log *logrus.Logger) *OutboxRelay {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Minute
+	}
+	if log == nil {
+		log = logrus.New()
+	}
+	return &OutboxRelay{
+		store:     store,
+		publisher: publisher,
+		cfg:       cfg,
+		log:       log,
+	}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			delivered, err := r.Flush(ctx)
+			if err != nil {
+				r.log.WithError(err).Warn("outbox relay flush failed")
+				break
+			}
+			if delivered < r.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush delivers one batch of due messages and returns how many were
+// published. Messages no queue is bound to receive (ErrUnroutable) count as
+// delivered. The batch stops at the first other failure and the failed message is
+// rescheduled with backoff. Messages queued behind it are not held back, so
+// the next poll may deliver them first: consumers must not rely on delivery
+// order.
+func (r *OutboxRelay) Flush(ctx context.Context) (int, error) {
+	delivered := 0
+	err := r.store.WithinTransaction(ctx, func(ctx context.Context) error {
+		msgs, err := r.store.Pending(ctx, r.cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("load pending: %w", err)
+		}
+		for _, msg := range msgs {
+			err := r.publisher.Publish(ctx, msg.Event)
+			if errors.Is(err, ErrUnroutable) {
+				// No queue is bound for this event type, and retrying will
+				// not change that until someone subscribes. The event stays
+				// in the event store and can be replayed from there.
+				r.log.WithError(err).WithFields(logrus.Fields{
+					"outboxID": msg.ID,
+					"type":     msg.Event.Type,
+				}).Warn("outbox message unroutable; dropping")
+				err = nil
+			}
+			if err != nil {
+				next := time.Now().UTC().Add(r.backoff(msg.Attempts + 1))
+				r.log.WithError(err).WithFields(logrus.Fields{
+					"outboxID": msg.ID,
+					"type":     msg.Event.Type,
+					"attempts": msg.Attempts + 1,
+				}).Warn("outbox delivery failed")
+				return r.store.MarkFailed(ctx, msg.ID, next, err.Error())
+			}
+			if err := r.store.MarkDelivered(ctx, msg.ID); err != nil {
+				return fmt.Errorf("mark delivered: %w", err)
+			}
+			delivered++
+		}
+		return nil
+	})
+	return delivered, err
+}
+
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= r.cfg.MaxBackoff {
+			return r.cfg.MaxBackoff
+		}
+	}
+	return d
+}
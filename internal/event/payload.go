@@ -0,0 +1,110 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+)
+
+var (
+	// ErrUnknownType is returned for payloads of event types this build does
+	// not know.
+	ErrUnknownType = errors.New("unknown event type")
+	// ErrUnsupportedVersion is returned for payloads of a newer schema
+	// version than this build understands.
+	ErrUnsupportedVersion = errors.New("unsupported event version")
+)
+
+// UserChanges is the payload of UserUpdated from version 2 on: the user
+// after the update, as in version 1, plus the fields that changed keyed by
+// their JSON name. After a JSON round trip the values are generic JSON
+// values, e.g. float64 for "age".
+type UserChanges struct {
+	domain.User
+	Changes map[string]FieldChange `json:"changes"`
+}
+
+// FieldChange holds the values of a field before and after an update.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// FilesCleared is the payload of UserFilesCleared.
+type FilesCleared struct {
+	Files []domain.File `json:"files"`
+}
+
+// AccountAudit is the payload of account audit events.
+type AccountAudit struct {
+	AccountID uint   `json:"account_id,omitempty"`
+	Username  string `json:"username"`
+	ClientIP  string `json:"client_ip,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// APIKeyAudit is the payload of API key audit events.
+type APIKeyAudit struct {
+	KeyID  uint   `json:"key_id"`
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+	Actor  string `json:"actor,omitempty"`
+}
+
+// payloadTypes returns a pointer to a new payload value per event type. A
+// nil function means events of the type carry no payload.
+var payloadTypes = map[Type]func() interface{}{
+	UserCreated:            func() interface{} { return &domain.User{} },
+	UserUpdated:            func() interface{} { return &UserChanges{} },
+	UserDeleted:            nil,
+	UserRestored:           func() interface{} { return &domain.User{} },
+	UserPurged:             nil,
+	FileAdded:              func() interface{} { return &domain.File{} },
+	FileDeleted:            func() interface{} { return &domain.File{} },
+	UserFilesCleared:       func() interface{} { return &FilesCleared{} },
+	LoginSucceeded:         func() interface{} { return &AccountAudit{} },
+	LoginFailed:            func() interface{} { return &AccountAudit{} },
+	AccountCreated:         func() interface{} { return &AccountAudit{} },
+	AccountDisabled:        func() interface{} { return &AccountAudit{} },
+	AccountPasswordRotated: func() interface{} { return &AccountAudit{} },
+	RefreshTokenReused:     func() interface{} { return &AccountAudit{} },
+	APIKeyCreated:          func() interface{} { return &APIKeyAudit{} },
+	APIKeyRevoked:          func() interface{} { return &APIKeyAudit{} },
+}
+
+// schemaVersions holds the payload schema version of types past version 1.
+// Bump a type's version whenever its payload changes.
+var schemaVersions = map[Type]int{
+	UserUpdated: 2, // adds UserChanges.Changes
+}
+
+// SchemaVersion is the payload schema version events of this type are
+// published with.
+func (t Type) SchemaVersion() int {
+	if v, ok := schemaVersions[t]; ok {
+		return v
+	}
+	return 1
+}
+
+// TypedPayload decodes the payload into the type registered for the event
+// type, e.g. *domain.File for FileAdded. It returns nil for types without a
+// payload. Events without a version are treated as version 1.
+func (e Event) TypedPayload() (interface{}, error) {
+	newPayload, ok := payloadTypes[e.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, e.Type)
+	}
+	if e.Version > e.Type.SchemaVersion() {
+		return nil, fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, e.Type, e.Version)
+	}
+	if newPayload == nil {
+		return nil, nil
+	}
+	payload := newPayload()
+	if err := e.DecodePayload(payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -2,137 +2,626 @@ package event
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
 )
 
+// PublisherConfig tunes a RabbitPublisher. Zero values select the defaults.
+type PublisherConfig struct {
+	// Channels is the size of the channel pool and so the number of
+	// publishes awaiting a broker confirm at the same time.
+	Channels int
+	// LegacyFanoutExchange, when set, names a fanout exchange that is bound
+	// to the topic exchange with "#", so that queues still bound to the old
+	// fanout exchange keep receiving every event during a migration.
+	LegacyFanoutExchange string
+	// Encoding is the wire format of published events, EncodingJSON by
+	// default.
+	Encoding Encoding
+	// Source is set on events that have none; it becomes the CloudEvents
+	// source attribute.
+	Source string
+}
+
+var (
+	// ErrNacked means the broker refused responsibility for a message.
+	ErrNacked = errors.New("rabbitmq nacked the message")
+	// ErrUnroutable means no queue is bound to receive a message.
+	ErrUnroutable = errors.New("rabbitmq could not route the message")
+)
+
+// RabbitPublisher publishes events to a topic exchange, with the routing key
+// of their type (see Type.RoutingKey), over a pool of long-lived channels in
+// confirm mode. Publish returns only once the broker has taken
+// responsibility for the message. Messages are mandatory, so one that no
+// queue would receive fails with ErrUnroutable instead of being dropped.
+// While the connection is down Publish fails with ErrNotConnected; the
+// outbox relay retries failed deliveries.
 type RabbitPublisher struct {
-	conn     *amqp.Connection
+	conn     *Connection
 	exchange string
+	encoding Encoding
+	source   string
+	slots    chan struct{}
+	idle     chan *confirmChannel
+
+	// routingKey, when set, replaces the routing key of the event type.
+	routingKey string
+	// replayID, when set, is sent in the replayHeader of every message.
+	replayID string
 }
 
-func NewRabbitPublisher(dsn, exchange string) (*RabbitPublisher, error) {
-	conn, err := amqp.Dial(dsn)
-	if err != nil {
-		return nil, fmt.Errorf("rabbitmq dial: %w", err)
-	}
+// confirmChannel is a pooled channel. It is used by one publish at a time,
+// so a return seen before the confirm belongs to that publish.
+type confirmChannel struct {
+	ch      *amqp.Channel
+	returns chan amqp.Return
+}
 
-	ch, err := conn.Channel()
+func NewRabbitPublisher(conn *Connection, exchange string, cfg PublisherConfig) (*RabbitPublisher, error) {
+	if cfg.Channels <= 0 {
+		cfg.Channels = 4
+	}
+	encoding, err := ParseEncoding(string(cfg.Encoding))
 	if err != nil {
-		return nil, fmt.Errorf("rabbitmq channel: %w", err)
+		return nil, err
 	}
-	defer ch.Close()
-
-	if err := ch.ExchangeDeclare(exchange, "fanout", true, false, false, false, nil); err != nil {
-		return nil, fmt.Errorf("declare exchange: %w", err)
+	err = conn.Declare(func(ch *amqp.Channel) error {
+		if err := ch.ExchangeDeclare(exchange, "topic", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("declare exchange: %w", err)
+		}
+		if cfg.LegacyFanoutExchange == "" {
+			return nil
+		}
+		if err := ch.ExchangeDeclare(cfg.LegacyFanoutExchange, "fanout", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("declare legacy exchange: %w", err)
+		}
+		if err := ch.ExchangeBind(cfg.LegacyFanoutExchange, "#", exchange, false, nil); err != nil {
+			return fmt.Errorf("bind legacy exchange: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &RabbitPublisher{
 		conn:     conn,
 		exchange: exchange,
+		encoding: encoding,
+		source:   cfg.Source,
+		slots:    make(chan struct{}, cfg.Channels),
+		idle:     make(chan *confirmChannel, cfg.Channels),
 	}, nil
 }
 
-func (p *RabbitPublisher) Publish(ctx context.Context, evt Event) error {
-	ch, err := p.conn.Channel()
+// ReplayTarget names where replayed events go: an existing exchange, which
+// receives them with their usual routing keys, or an existing queue.
+//
+// Replayed events keep their IDs, so a Deduplicator skips those it handled
+// before. With Reprocess the messages carry the ID of the replay, which the
+// Deduplicator keys its records by instead: every event of the replay is
+// handled again, and still only once.
+type ReplayTarget struct {
+	Exchange  string `json:"exchange,omitempty"`
+	Queue     string `json:"queue,omitempty"`
+	Reprocess bool   `json:"reprocess,omitempty"`
+}
+
+// NewRabbitReplayPublisher publishes to target, which must name exactly one
+// exchange or queue. Unlike NewRabbitPublisher it declares nothing: events
+// for a missing exchange or queue fail with ErrUnroutable. Close the
+// publisher when done.
+func NewRabbitReplayPublisher(conn *Connection, target ReplayTarget, cfg PublisherConfig) (*RabbitPublisher, error) {
+	if (target.Exchange == "") == (target.Queue == "") {
+		return nil, errors.New("replay target needs either an exchange or a queue")
+	}
+	if cfg.Channels <= 0 {
+		cfg.Channels = 1
+	}
+	encoding, err := ParseEncoding(string(cfg.Encoding))
 	if err != nil {
-		return fmt.Errorf("rabbitmq channel: %w", err)
+		return nil, err
 	}
-	defer ch.Close()
+	p := &RabbitPublisher{
+		conn:     conn,
+		exchange: target.Exchange,
+		encoding: encoding,
+		source:   cfg.Source,
+		slots:    make(chan struct{}, cfg.Channels),
+		idle:     make(chan *confirmChannel, cfg.Channels),
+	}
+	if target.Queue != "" {
+		// The default exchange routes by queue name.
+		p.routingKey = target.Queue
+	}
+	if target.Reprocess {
+		p.replayID = uuid.NewString()
+	}
+	return p, nil
+}
 
-	payload, err := json.Marshal(evt)
+// Close closes the pooled channels. Call it once no publish is in flight.
+func (p *RabbitPublisher) Close() {
+	for {
+		select {
+		case pc := <-p.idle:
+			pc.ch.Close()
+		default:
+			return
+		}
+	}
+}
+
+// Publish sends evt and waits for the broker's confirm, bounded by ctx.
+func (p *RabbitPublisher) Publish(ctx context.Context, evt Event) error {
+	if evt.Source == "" {
+		evt.Source = p.source
+	}
+	msg, err := encodeMessage(evt, p.encoding)
 	if err != nil {
-		return fmt.Errorf("marshal event: %w", err)
+		return err
+	}
+	if p.replayID != "" {
+		if msg.Headers == nil {
+			msg.Headers = amqp.Table{}
+		}
+		msg.Headers[replayHeader] = p.replayID
 	}
 
-	return ch.PublishWithContext(ctx, p.exchange, "", false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        payload,
-	})
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	pc, err := p.channel()
+	if err != nil {
+		return err
+	}
+	key := p.routingKey
+	if key == "" {
+		key = evt.Type.RoutingKey()
+	}
+	err = p.publish(ctx, pc, key, msg)
+	if err != nil && !errors.Is(err, ErrNacked) && !errors.Is(err, ErrUnroutable) {
+		// A confirm or return may still arrive for this message; do not let
+		// the next publish mistake it for its own.
+		pc.ch.Close()
+		return err
+	}
+	p.idle <- pc
+	return err
 }
 
-func (p *RabbitPublisher) Close() error {
-	if p.conn != nil {
-		return p.conn.Close()
+func (p *RabbitPublisher) publish(ctx context.Context, pc *confirmChannel, key string, msg amqp.Publishing) error {
+	confirm, err := pc.ch.PublishWithDeferredConfirmWithContext(ctx, p.exchange, key, true, false, msg)
+	if err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	// The broker sends a return before the confirm of the same message.
+	select {
+	case ret, ok := <-pc.returns:
+		if ok {
+			return fmt.Errorf("%w: %d %s", ErrUnroutable, ret.ReplyCode, ret.ReplyText)
+		}
+	default:
+	}
+	if !acked {
+		return ErrNacked
 	}
 	return nil
 }
 
+// channel takes an idle pooled channel or opens a new one. Channels of a
+// lost connection are discarded.
+func (p *RabbitPublisher) channel() (*confirmChannel, error) {
+idle:
+	for {
+		select {
+		case pc := <-p.idle:
+			if !pc.ch.IsClosed() {
+				return pc, nil
+			}
+		default:
+			break idle
+		}
+	}
+
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("enable confirms: %w", err)
+	}
+	return &confirmChannel{
+		ch:      ch,
+		returns: ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+// ConsumerConfig tunes a RabbitConsumer. Zero values select the defaults.
+type ConsumerConfig struct {
+	// Prefetch is how many unacknowledged deliveries the broker sends ahead
+	// of the workers.
+	Prefetch int
+	// Workers is how many deliveries are handled concurrently.
+	Workers int
+	// MaxRetries is how often a failed delivery is retried before it is
+	// dead-lettered.
+	MaxRetries int
+	// RetryDelay is the wait before the first retry. Each further retry
+	// doubles it, up to MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// LegacyExchange names the fanout exchange the queue was bound to before
+	// the move to a topic exchange. The old binding is removed so that
+	// events are not delivered twice.
+	LegacyExchange string
+	// Transient declares the queue exclusive to the connection and deleted
+	// with it, for consumers that only care about events while they run.
+	// It has no retry or dead-letter queues; failed deliveries are dropped.
+	Transient bool
+}
+
+// retryCountHeader counts how often a delivery has been retried, and
+// replayHeader carries the ID of a replay sent with ReplayTarget.Reprocess.
+const (
+	retryCountHeader = "x-retry-count"
+	replayHeader     = "x-replay-id"
+)
+
+// RabbitConsumer delivers events from a durable queue bound to the topic
+// exchange with the given patterns, e.g. "user.*" or "user.file.#".
+// Deliveries are acknowledged once the handler succeeds. A failed delivery
+// is parked in a delay queue whose TTL routes it back to the queue, and
+// after MaxRetries it goes to the dead-letter exchange "<queue>.dlx", which
+// feeds the queue "<queue>.dead". Undecodable messages and Permanent errors
+// are dead-lettered at once.
 type RabbitConsumer struct {
-	conn     *amqp.Connection
+	conn     *Connection
 	exchange string
 	queue    string
+	bindings []string
+	cfg      ConsumerConfig
+	log      *logrus.Logger
 }
 
-func NewRabbitConsumer(dsn, exchange, queue string) (*RabbitConsumer, error) {
-	conn, err := amqp.Dial(dsn)
-	if err != nil {
-		return nil, fmt.Errorf("rabbitmq dial: %w", err)
+// NewRabbitConsumer binds queue to exchange with each of bindings; no
+// bindings subscribes to every event. Bindings are only ever added, so a
+// pattern dropped from bindings must be unbound from the queue by hand.
+func NewRabbitConsumer(conn *Connection, exchange, queue string, bindings []string, cfg ConsumerConfig, log *logrus.Logger) (*RabbitConsumer, error) {
+	if len(bindings) == 0 {
+		bindings = []string{"#"}
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Prefetch <= 0 {
+		cfg.Prefetch = 2 * cfg.Workers
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = time.Second
+	}
+	if cfg.MaxRetryDelay <= 0 {
+		cfg.MaxRetryDelay = 5 * time.Minute
+	}
+	if log == nil {
+		log = logrus.New()
 	}
 
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, fmt.Errorf("rabbitmq channel: %w", err)
+	c := &RabbitConsumer{
+		conn:     conn,
+		exchange: exchange,
+		queue:    queue,
+		bindings: bindings,
+		cfg:      cfg,
+		log:      log,
 	}
-	defer ch.Close()
+	if err := conn.Declare(c.declare); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-	if err := ch.ExchangeDeclare(exchange, "fanout", true, false, false, false, nil); err != nil {
-		return nil, fmt.Errorf("declare exchange: %w", err)
+func (c *RabbitConsumer) declare(ch *amqp.Channel) error {
+	if err := ch.ExchangeDeclare(c.exchange, "topic", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare exchange: %w", err)
 	}
 
-	if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
-		return nil, fmt.Errorf("declare queue: %w", err)
+	if _, err := ch.QueueDeclare(c.queue, !c.cfg.Transient, c.cfg.Transient, c.cfg.Transient, false, nil); err != nil {
+		return fmt.Errorf("declare queue: %w", err)
 	}
 
-	if err := ch.QueueBind(queue, "", exchange, false, nil); err != nil {
-		return nil, fmt.Errorf("queue bind: %w", err)
+	for _, pattern := range c.bindings {
+		if err := ch.QueueBind(c.queue, pattern, c.exchange, false, nil); err != nil {
+			return fmt.Errorf("queue bind %s: %w", pattern, err)
+		}
+	}
+	if c.cfg.Transient {
+		return nil
 	}
 
-	return &RabbitConsumer{
-		conn:     conn,
-		exchange: exchange,
-		queue:    queue,
-	}, nil
+	if c.cfg.LegacyExchange != "" {
+		// Declaring first makes the unbind safe on brokers that never had
+		// the legacy exchange.
+		if err := ch.ExchangeDeclare(c.cfg.LegacyExchange, "fanout", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("declare legacy exchange: %w", err)
+		}
+		if err := ch.QueueUnbind(c.queue, "", c.cfg.LegacyExchange, nil); err != nil {
+			return fmt.Errorf("legacy queue unbind: %w", err)
+		}
+	}
+
+	// Delay queues have no consumers: expired messages are dead-lettered
+	// through the default exchange straight back onto the work queue.
+	for attempt := 1; attempt <= c.cfg.MaxRetries; attempt++ {
+		delay := c.retryDelay(attempt)
+		if _, err := ch.QueueDeclare(c.retryQueue(delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": c.queue,
+		}); err != nil {
+			return fmt.Errorf("declare retry queue: %w", err)
+		}
+	}
+
+	if err := ch.ExchangeDeclare(c.DeadLetterExchange(), "fanout", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare dead-letter exchange: %w", err)
+	}
+	if _, err := ch.QueueDeclare(c.DeadLetterQueue(), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare dead-letter queue: %w", err)
+	}
+	if err := ch.QueueBind(c.DeadLetterQueue(), "", c.DeadLetterExchange(), false, nil); err != nil {
+		return fmt.Errorf("dead-letter queue bind: %w", err)
+	}
+	return nil
+}
+
+// DeadLetterExchange is where deliveries go that failed for good.
+func (c *RabbitConsumer) DeadLetterExchange() string {
+	return c.queue + ".dlx"
+}
+
+// DeadLetterQueue collects the messages sent to DeadLetterExchange.
+func (c *RabbitConsumer) DeadLetterQueue() string {
+	return c.queue + ".dead"
+}
+
+// retryQueue names the delay queue by its TTL, so that changing the retry
+// delays declares new queues instead of clashing with existing ones.
+func (c *RabbitConsumer) retryQueue(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", c.queue, delay.Milliseconds())
+}
+
+// retryDelay is the wait before the given retry, counting from 1.
+func (c *RabbitConsumer) retryDelay(attempt int) time.Duration {
+	d := c.cfg.RetryDelay
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= c.cfg.MaxRetryDelay {
+			return c.cfg.MaxRetryDelay
+		}
+	}
+	return d
 }
 
+// Consume hands deliveries to handler on Workers goroutines until ctx is
+// cancelled or the connection is closed. Handler errors do not stop
+// consumption; the delivery is retried or dead-lettered instead. When the
+// connection drops, consumption resumes once it has been restored.
 func (c *RabbitConsumer) Consume(ctx context.Context, handler Handler) error {
+	for {
+		if err := c.conn.waitReady(ctx); err != nil {
+			return err
+		}
+		err := c.consume(ctx, handler)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.log.WithError(err).Warn("consumer interrupted; resuming after reconnect")
+		// The connection may not have noticed the failure yet; give it a
+		// moment rather than spinning on a dead channel.
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// consume runs the workers on one channel until it closes or ctx is done.
+func (c *RabbitConsumer) consume(ctx context.Context, handler Handler) error {
 	ch, err := c.conn.Channel()
 	if err != nil {
-		return fmt.Errorf("rabbitmq channel: %w", err)
+		return err
 	}
 	defer ch.Close()
 
-	deliveries, err := ch.Consume(c.queue, "", true, false, false, false, nil)
+	if err := ch.Qos(c.cfg.Prefetch, 0, false); err != nil {
+		return fmt.Errorf("set prefetch: %w", err)
+	}
+	// Retry and dead-letter copies are published on this channel; confirms
+	// let republish wait until the broker holds the copy before the original
+	// is acked.
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("enable confirms: %w", err)
+	}
+
+	deliveries, err := ch.Consume(c.queue, "", false, false, false, false, nil)
 	if err != nil {
 		return fmt.Errorf("queue consume: %w", err)
 	}
 
-	for {
-		select {
-		case d, ok := <-deliveries:
-			if !ok {
-				return nil
-			}
-			var evt Event
-			if err := json.Unmarshal(d.Body, &evt); err != nil {
-				continue
-			}
-			if handler != nil {
-				if err := handler(ctx, evt); err != nil {
-					return err
+	var wg sync.WaitGroup
+	for i := 0; i < c.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case d, ok := <-deliveries:
+					if !ok {
+						return
+					}
+					c.handle(ctx, ch, d, handler)
+				case <-ctx.Done():
+					return
 				}
 			}
-		case <-ctx.Done():
-			return ctx.Err()
-		}
+		}()
 	}
+	wg.Wait()
+	return errors.New("delivery channel closed")
 }
 
-func (c *RabbitConsumer) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
+func (c *RabbitConsumer) handle(ctx context.Context, ch *amqp.Channel, d amqp.Delivery, handler Handler) {
+	evt, err := decodeMessage(d.ContentType, d.Headers, d.Body)
+	if err != nil {
+		c.deadLetter(ctx, ch, d, err)
+		return
+	}
+	if handler == nil {
+		c.ack(d)
+		return
+	}
+
+	hctx := ContextFor(ctx, evt)
+	if id, ok := d.Headers[replayHeader].(string); ok && id != "" {
+		hctx = WithReplayID(hctx, id)
+	}
+	err = handler(hctx, evt)
+	if err == nil {
+		c.ack(d)
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down: hand the delivery back for the next consumer.
+		c.nack(d)
+		return
+	}
+
+	entry := c.log.WithError(err).WithFields(logrus.Fields{
+		"eventID": evt.ID,
+		"type":    evt.Type,
+		"userID":  evt.UserID,
+	})
+	if c.cfg.Transient {
+		entry.Warn("event handling failed; dropping")
+		c.ack(d)
+		return
+	}
+	retries := retryCount(d.Headers)
+	if IsPermanent(err) || retries >= c.cfg.MaxRetries {
+		entry.WithField("retries", retries).Warn("event handling failed; dead-lettering")
+		c.deadLetter(ctx, ch, d, err)
+		return
+	}
+
+	delay := c.retryDelay(retries + 1)
+	entry.WithFields(logrus.Fields{
+		"retry": retries + 1,
+		"delay": delay,
+	}).Info("event handling failed; retrying")
+	headers := copyHeaders(d.Headers)
+	headers[retryCountHeader] = int32(retries + 1)
+	if err := c.republish(ctx, ch, d, "", c.retryQueue(delay), headers); err != nil {
+		c.log.WithError(err).Warn("scheduling retry failed; requeueing")
+		c.nack(d)
+		return
+	}
+	c.ack(d)
+}
+
+func (c *RabbitConsumer) deadLetter(ctx context.Context, ch *amqp.Channel, d amqp.Delivery, cause error) {
+	if c.cfg.Transient {
+		c.log.WithError(cause).Warn("dropping undecodable message")
+		c.ack(d)
+		return
+	}
+	headers := copyHeaders(d.Headers)
+	headers["x-error"] = cause.Error()
+	if err := c.republish(ctx, ch, d, c.DeadLetterExchange(), "", headers); err != nil {
+		c.log.WithError(err).Warn("dead-lettering failed; requeueing")
+		c.nack(d)
+		return
+	}
+	c.ack(d)
+}
+
+// republish copies d to exchange and returns once the broker has confirmed
+// the copy, so that acking the original can never lose the message.
+func (c *RabbitConsumer) republish(ctx context.Context, ch *amqp.Channel, d amqp.Delivery, exchange, key string, headers amqp.Table) error {
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    d.MessageId,
+		Timestamp:    d.Timestamp,
+		Body:         d.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrNacked
 	}
 	return nil
 }
+
+func (c *RabbitConsumer) ack(d amqp.Delivery) {
+	if err := d.Ack(false); err != nil {
+		c.log.WithError(err).Warn("ack failed")
+	}
+}
+
+func (c *RabbitConsumer) nack(d amqp.Delivery) {
+	if err := d.Nack(false, true); err != nil {
+		c.log.WithError(err).Warn("nack failed")
+	}
+}
+
+// retryCount reads retryCountHeader, which may come back as any integer
+// type depending on the client that last published the message.
+func retryCount(headers amqp.Table) int {
+	switch v := headers[retryCountHeader].(type) {
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	out := make(amqp.Table, len(headers)+1)
+	for k, v := range headers {
+		out[k] = v
+	}
+	return out
+}
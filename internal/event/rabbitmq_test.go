@@ -0,0 +1,515 @@
+package event_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/testutil"
+)
+
+func TestRabbitConsumer_RetriesThenDeadLetters(t *testing.T) {
+	dsn := testutil.RabbitDSN(t)
+	exchange, queue := uniqueTopology(t, dsn)
+
+	conn := dial(t, dsn)
+	consumer, err := event.NewRabbitConsumer(conn, exchange, queue, nil, event.ConsumerConfig{
+		Workers:    2,
+		MaxRetries: 2,
+		RetryDelay: 50 * time.Millisecond,
+	}, nil)
+	require.NoError(t, err)
+	publisher, err := event.NewRabbitPublisher(conn, exchange, event.PublisherConfig{})
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	attempts := map[uint]int{}
+	handled := make(chan uint, 16)
+	handler := func(_ context.Context, evt event.Event) error {
+		mu.Lock()
+		attempts[evt.UserID]++
+		n := attempts[evt.UserID]
+		mu.Unlock()
+		switch {
+		case evt.UserID == 1 && n < 3:
+			return errors.New("flaky")
+		case evt.UserID == 2:
+			return errors.New("always fails")
+		case evt.UserID == 3:
+			return event.Permanent(errors.New("unsupported"))
+		}
+		handled <- evt.UserID
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- consumer.Consume(ctx, handler) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	for id := uint(1); id <= 3; id++ {
+		require.NoError(t, publisher.Publish(context.Background(), event.Event{Type: event.UserUpdated, UserID: id}))
+	}
+	rawPublish(t, dsn, exchange, []byte("not json"))
+
+	select {
+	case id := <-handled:
+		require.Equal(t, uint(1), id)
+	case <-time.After(10 * time.Second):
+		t.Fatal("flaky event was not redelivered")
+	}
+
+	dead := drain(t, dsn, consumer.DeadLetterQueue(), 3)
+	errs := map[string]bool{}
+	for _, msg := range dead {
+		errs[fmt.Sprint(msg.Headers["x-error"])] = true
+	}
+	require.True(t, errs["always fails"])
+	require.True(t, errs["unsupported"])
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 3, attempts[1])
+	require.Equal(t, 3, attempts[2]) // first delivery + MaxRetries
+	require.Equal(t, 1, attempts[3])
+}
+
+func TestRabbitConsumer_SlowEventDoesNotBlockOthers(t *testing.T) {
+	dsn := testutil.RabbitDSN(t)
+	exchange, queue := uniqueTopology(t, dsn)
+
+	conn := dial(t, dsn)
+	consumer, err := event.NewRabbitConsumer(conn, exchange, queue, nil, event.ConsumerConfig{Workers: 2}, nil)
+	require.NoError(t, err)
+	publisher, err := event.NewRabbitPublisher(conn, exchange, event.PublisherConfig{})
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	fast := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.Consume(ctx, func(_ context.Context, evt event.Event) error {
+			if evt.UserID == 1 {
+				<-release
+				return nil
+			}
+			fast <- struct{}{}
+			return nil
+		})
+	}()
+	t.Cleanup(func() {
+		close(release)
+		cancel()
+		<-done
+	})
+
+	require.NoError(t, publisher.Publish(context.Background(), event.Event{Type: event.UserCreated, UserID: 1}))
+	require.NoError(t, publisher.Publish(context.Background(), event.Event{Type: event.UserCreated, UserID: 2}))
+	select {
+	case <-fast:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second event waited for the first")
+	}
+}
+
+func TestConnection_ReconnectsAndRedeclaresTopology(t *testing.T) {
+	dsn := testutil.RabbitDSN(t)
+	exchange, queue := uniqueTopology(t, dsn)
+
+	// A long backoff keeps the connection down long enough to observe it.
+	conn, err := event.DialConnection(dsn, event.ReconnectConfig{MinBackoff: time.Second}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	consumer, err := event.NewRabbitConsumer(conn, exchange, queue, nil, event.ConsumerConfig{}, nil)
+	require.NoError(t, err)
+	publisher, err := event.NewRabbitPublisher(conn, exchange, event.PublisherConfig{})
+	require.NoError(t, err)
+
+	received := make(chan uint, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.Consume(ctx, func(_ context.Context, evt event.Event) error {
+			received <- evt.UserID
+			return nil
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	require.NoError(t, publisher.Publish(context.Background(), event.Event{Type: event.UserCreated, UserID: 1}))
+	requireReceived(t, received, 1)
+
+	// Remove the topology behind the connection's back, then drop it; the
+	// reconnect has to declare everything again.
+	deleteTopology(dsn, exchange, queue)
+	event.DropConnection(conn)
+	require.Eventually(t, func() bool { return conn.State() == event.StateReconnecting }, 5*time.Second, 10*time.Millisecond)
+	require.ErrorIs(t, publisher.Publish(context.Background(), event.Event{Type: event.UserCreated, UserID: 2}), event.ErrNotConnected)
+	require.Error(t, conn.Check(context.Background()))
+
+	require.Eventually(t, func() bool { return conn.State() == event.StateConnected }, 10*time.Second, 50*time.Millisecond)
+	require.NoError(t, conn.Check(context.Background()))
+	require.Eventually(t, func() bool {
+		return publisher.Publish(context.Background(), event.Event{Type: event.UserCreated, UserID: 3}) == nil
+	}, 5*time.Second, 100*time.Millisecond)
+	requireReceived(t, received, 3)
+}
+
+func TestRabbitConsumer_ReceivesOnlyBoundRoutingKeys(t *testing.T) {
+	dsn := testutil.RabbitDSN(t)
+	exchange, queue := uniqueTopology(t, dsn)
+	legacy, legacyQueue := exchange+".legacy", queue+".legacy"
+	t.Cleanup(func() { deleteTopology(dsn, legacy, legacyQueue) })
+
+	conn := dial(t, dsn)
+	publisher, err := event.NewRabbitPublisher(conn, exchange, event.PublisherConfig{LegacyFanoutExchange: legacy})
+	require.NoError(t, err)
+	// A queue from before the migration, still bound to the fanout exchange.
+	bindQueue(t, dsn, legacy, legacyQueue)
+	bindQueue(t, dsn, legacy, queue)
+	consumer, err := event.NewRabbitConsumer(conn, exchange, queue, []string{"user.file.*"}, event.ConsumerConfig{
+		LegacyExchange: legacy,
+	}, nil)
+	require.NoError(t, err)
+
+	received := make(chan event.Type, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.Consume(ctx, func(_ context.Context, evt event.Event) error {
+			received <- evt.Type
+			return nil
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	for _, typ := range []event.Type{event.UserCreated, event.FileAdded, event.UserUpdated, event.FileDeleted} {
+		require.NoError(t, publisher.Publish(context.Background(), event.Event{Type: typ, UserID: 1}))
+	}
+	for _, want := range []event.Type{event.FileAdded, event.FileDeleted} {
+		select {
+		case got := <-received:
+			require.Equal(t, want, got)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s not received", want)
+		}
+	}
+	select {
+	case got := <-received:
+		t.Fatalf("unexpected %s", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.Len(t, drain(t, dsn, legacyQueue, 4), 4)
+}
+
+func TestRabbitPublisher_CloudEventsReachConsumers(t *testing.T) {
+	dsn := testutil.RabbitDSN(t)
+	conn := dial(t, dsn)
+
+	for _, enc := range []event.Encoding{event.EncodingCloudEventsStructured, event.EncodingCloudEventsBinary} {
+		t.Run(string(enc), func(t *testing.T) {
+			exchange, queue := uniqueTopology(t, dsn)
+			publisher, err := event.NewRabbitPublisher(conn, exchange, event.PublisherConfig{Encoding: enc, Source: "/test"})
+			require.NoError(t, err)
+			consumer, err := event.NewRabbitConsumer(conn, exchange, queue, nil, event.ConsumerConfig{}, nil)
+			require.NoError(t, err)
+
+			received := make(chan event.Event, 1)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- consumer.Consume(ctx, func(_ context.Context, evt event.Event) error {
+					received <- evt
+					return nil
+				})
+			}()
+			t.Cleanup(func() {
+				cancel()
+				<-done
+			})
+
+			sent := event.New(context.Background(), event.UserDeleted, 5, nil)
+			require.NoError(t, publisher.Publish(context.Background(), sent))
+			select {
+			case got := <-received:
+				require.Equal(t, sent.ID, got.ID)
+				require.Equal(t, event.UserDeleted, got.Type)
+				require.Equal(t, uint(5), got.UserID)
+				require.Equal(t, "/test", got.Source)
+			case <-time.After(10 * time.Second):
+				t.Fatal("event not received")
+			}
+		})
+	}
+}
+
+func dial(t *testing.T, dsn string) *event.Connection {
+	t.Helper()
+	conn, err := event.DialConnection(dsn, event.ReconnectConfig{MinBackoff: 50 * time.Millisecond}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func requireReceived(t *testing.T, received <-chan uint, want uint) {
+	t.Helper()
+	select {
+	case got := <-received:
+		require.Equal(t, want, got)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("event %d not received", want)
+	}
+}
+
+// uniqueTopology returns exchange and queue names private to the test and
+// deletes everything the consumer declared for them afterwards.
+func uniqueTopology(t *testing.T, dsn string) (exchange, queue string) {
+	t.Helper()
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	exchange = "test.events." + suffix
+	queue = "test.events.queue." + suffix
+	t.Cleanup(func() { deleteTopology(dsn, exchange, queue) })
+	return exchange, queue
+}
+
+// deleteTopology removes what a consumer of queue declares.
+func deleteTopology(dsn, exchange, queue string) {
+	conn, err := amqp.Dial(dsn)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	for _, name := range []string{queue, queue + ".dead"} {
+		_, _ = ch.QueueDelete(name, false, false, false)
+	}
+	for _, ms := range []int{50, 100, 200, 400, 800, 1000, 2000, 4000, 8000, 16000} {
+		_, _ = ch.QueueDelete(fmt.Sprintf("%s.retry.%dms", queue, ms), false, false, false)
+	}
+	_ = ch.ExchangeDelete(exchange, false, false)
+	_ = ch.ExchangeDelete(queue+".dlx", false, false)
+}
+
+func rawPublish(t *testing.T, dsn, exchange string, body []byte) {
+	t.Helper()
+	conn, err := amqp.Dial(dsn)
+	require.NoError(t, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+	defer ch.Close()
+	require.NoError(t, ch.PublishWithContext(context.Background(), exchange, "", false, false, amqp.Publishing{Body: body}))
+}
+
+// drain waits until n messages could be read from queue.
+func drain(t *testing.T, dsn, queue string, n int) []amqp.Delivery {
+	t.Helper()
+	conn, err := amqp.Dial(dsn)
+	require.NoError(t, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+	defer ch.Close()
+
+	var out []amqp.Delivery
+	deadline := time.Now().Add(10 * time.Second)
+	for len(out) < n && time.Now().Before(deadline) {
+		msg, ok, err := ch.Get(queue, true)
+		require.NoError(t, err)
+		if !ok {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		out = append(out, msg)
+	}
+	require.Len(t, out, n, "messages in %s", queue)
+	return out
+}
+
+func TestRabbitPublisher_ConfirmsConcurrentPublishes(t *testing.T) {
+	dsn := testutil.RabbitDSN(t)
+	exchange, queue := uniqueTopology(t, dsn)
+	publisher, err := event.NewRabbitPublisher(dial(t, dsn), exchange, event.PublisherConfig{Channels: 4})
+	require.NoError(t, err)
+	bindQueue(t, dsn, exchange, queue)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(id uint) {
+			defer wg.Done()
+			errs <- publisher.Publish(context.Background(), event.Event{Type: event.UserCreated, UserID: id})
+		}(uint(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	// Every confirmed message is already in the queue.
+	require.Equal(t, 100, queueDepth(t, dsn, queue))
+}
+
+func TestRabbitPublisher_RejectsUnroutableMessages(t *testing.T) {
+	dsn := testutil.RabbitDSN(t)
+	exchange, queue := uniqueTopology(t, dsn)
+	publisher, err := event.NewRabbitPublisher(dial(t, dsn), exchange, event.PublisherConfig{Channels: 1})
+	require.NoError(t, err)
+
+	err = publisher.Publish(context.Background(), event.Event{Type: event.UserCreated, UserID: 1})
+	require.ErrorIs(t, err, event.ErrUnroutable)
+
+	// The channel stays usable once a queue is bound.
+	bindQueue(t, dsn, exchange, queue)
+	require.NoError(t, publisher.Publish(context.Background(), event.Event{Type: event.UserCreated, UserID: 2}))
+	require.Equal(t, 1, queueDepth(t, dsn, queue))
+}
+
+func TestRabbitReplayPublisher_ReprocessBypassesDeduplication(t *testing.T) {
+	dsn := testutil.RabbitDSN(t)
+	exchange, queue := uniqueTopology(t, dsn)
+	conn := dial(t, dsn)
+	publisher, err := event.NewRabbitPublisher(conn, exchange, event.PublisherConfig{})
+	require.NoError(t, err)
+	consumer, err := event.NewRabbitConsumer(conn, exchange, queue, []string{"#"}, event.ConsumerConfig{}, nil)
+	require.NoError(t, err)
+
+	received := make(chan uint, 8)
+	handler := event.NewDeduplicator(event.NewLRUProcessedStore(10), time.Hour, time.Minute, nil).Wrap(func(_ context.Context, evt event.Event) error {
+		received <- evt.UserID
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- consumer.Consume(ctx, handler) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	evt := event.New(context.Background(), event.UserCreated, 1, nil)
+	require.NoError(t, publisher.Publish(context.Background(), evt))
+	requireReceived(t, received, 1)
+
+	replay := func(reprocess bool) {
+		t.Helper()
+		replayer, err := event.NewRabbitReplayPublisher(conn, event.ReplayTarget{Queue: queue, Reprocess: reprocess}, event.PublisherConfig{})
+		require.NoError(t, err)
+		defer replayer.Close()
+		require.NoError(t, replayer.Publish(context.Background(), evt))
+	}
+	replay(false)
+	replay(true)
+	requireReceived(t, received, 1)
+	select {
+	case <-received:
+		t.Fatal("the plain replay was not skipped")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// BenchmarkPublish compares confirmed publishes over the channel pool with
+// opening a channel per event, as the publisher used to.
+func BenchmarkPublish(b *testing.B) {
+	dsn := testutil.RabbitDSN(b)
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	exchange, queue := "bench.events."+suffix, "bench.events.queue."+suffix
+	b.Cleanup(func() { deleteTopology(dsn, exchange, queue) })
+
+	conn, err := event.DialConnection(dsn, event.ReconnectConfig{}, nil)
+	require.NoError(b, err)
+	b.Cleanup(func() { conn.Close() })
+	publisher, err := event.NewRabbitPublisher(conn, exchange, event.PublisherConfig{Channels: 8})
+	require.NoError(b, err)
+	bindQueue(b, dsn, exchange, queue)
+	evt := event.Event{Type: event.UserUpdated, UserID: 1, Payload: map[string]string{"name": "bench"}}
+
+	b.Run("channel-per-event", func(b *testing.B) {
+		body, err := json.Marshal(evt)
+		require.NoError(b, err)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				ch, err := conn.Channel()
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				if err := ch.Confirm(false); err != nil {
+					b.Error(err)
+					return
+				}
+				confirm, err := ch.PublishWithDeferredConfirmWithContext(context.Background(), exchange, "", true, false, amqp.Publishing{
+					ContentType:  "application/json",
+					DeliveryMode: amqp.Persistent,
+					Body:         body,
+				})
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				confirm.Wait()
+				ch.Close()
+			}
+		})
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := publisher.Publish(context.Background(), evt); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
+
+// bindQueue declares queue and binds it to exchange, which must exist.
+func bindQueue(tb testing.TB, dsn, exchange, queue string) {
+	tb.Helper()
+	conn, err := amqp.Dial(dsn)
+	require.NoError(tb, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(tb, err)
+	defer ch.Close()
+	_, err = ch.QueueDeclare(queue, true, false, false, false, nil)
+	require.NoError(tb, err)
+	require.NoError(tb, ch.QueueBind(queue, "#", exchange, false, nil))
+}
+
+func queueDepth(t *testing.T, dsn, queue string) int {
+	t.Helper()
+	conn, err := amqp.Dial(dsn)
+	require.NoError(t, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+	defer ch.Close()
+	q, err := ch.QueueDeclarePassive(queue, true, false, false, false, nil)
+	require.NoError(t, err)
+	return q.Messages
+}
@@ -0,0 +1,44 @@
+package event
+
+import "context"
+
+// Registry routes events to the handlers registered for their type.
+// Handlers registered with HandleAll see every event after the handlers of
+// its type. Events without any handler are ignored, so a queue may receive
+// more types than a consumer cares about.
+type Registry struct {
+	byType map[Type][]Handler
+	all    []Handler
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byType: map[Type][]Handler{}}
+}
+
+// Handle registers h for events of the given types.
+func (r *Registry) Handle(h Handler, types ...Type) {
+	for _, t := range types {
+		r.byType[t] = append(r.byType[t], h)
+	}
+}
+
+// HandleAll registers h for events of every type.
+func (r *Registry) HandleAll(h Handler) {
+	r.all = append(r.all, h)
+}
+
+// Dispatch runs the handlers for evt in registration order and stops at the
+// first error. A retried event runs all of them again.
+func (r *Registry) Dispatch(ctx context.Context, evt Event) error {
+	for _, h := range r.byType[evt.Type] {
+		if err := h(ctx, evt); err != nil {
+			return err
+		}
+	}
+	for _, h := range r.all {
+		if err := h(ctx, evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
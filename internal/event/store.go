@@ -0,0 +1,100 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StoredEvent is an event as recorded in the event store. Sequence orders
+// all events globally.
+type StoredEvent struct {
+	Sequence int64 `json:"sequence"`
+	Event
+}
+
+// EventFilter selects stored events. Zero fields do not filter. Events are
+// returned in sequence order, starting after AfterSequence and ending with
+// ToSequence.
+type EventFilter struct {
+	AfterSequence int64
+	ToSequence    int64
+	Types         []Type
+	UserID        uint
+	Since         time.Time
+	Until         time.Time
+	Limit         int
+}
+
+// EventStore reads the events recorded so far. Sequence numbers are
+// assigned when an event is recorded, so an event whose transaction commits
+// late may appear behind a higher sequence that was already read.
+type EventStore interface {
+	Events(ctx context.Context, filter EventFilter) ([]StoredEvent, error)
+}
+
+// ReplayResult reports how far a replay got. A replay that stopped early
+// can be resumed with AfterSequence set to LastSequence.
+type ReplayResult struct {
+	Replayed     int   `json:"replayed"`
+	LastSequence int64 `json:"last_sequence"`
+}
+
+// Replayer re-publishes stored events, e.g. to let a new consumer build its
+// state or to reprocess events after a bug. Replayed events keep their IDs,
+// so consumers that deduplicate skip those they handled within their TTL
+// unless the target marks them for reprocessing; see ReplayTarget.
+type Replayer struct {
+	store     EventStore
+	batchSize int
+	log       *logrus.Logger
+}
+
+func NewReplayer(store EventStore, batchSize int, log *logrus.Logger) *Replayer {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	if log == nil {
+		log = logrus.New()
+	}
+	return &Replayer{store: store, batchSize: batchSize, log: log}
+}
+
+// Replay publishes the events matching filter to target in sequence order,
+// at most filter.Limit of them when it is set. It stops at the first
+// failure.
+func (r *Replayer) Replay(ctx context.Context, filter EventFilter, target Publisher) (ReplayResult, error) {
+	result := ReplayResult{LastSequence: filter.AfterSequence}
+	for {
+		page := filter
+		page.AfterSequence = result.LastSequence
+		page.Limit = r.batchSize
+		if filter.Limit > 0 && filter.Limit-result.Replayed < page.Limit {
+			page.Limit = filter.Limit - result.Replayed
+		}
+		if page.Limit == 0 {
+			return result, nil
+		}
+
+		events, err := r.store.Events(ctx, page)
+		if err != nil {
+			return result, fmt.Errorf("load events: %w", err)
+		}
+		for _, stored := range events {
+			if err := target.Publish(ctx, stored.Event); err != nil {
+				return result, fmt.Errorf("replay event %d: %w", stored.Sequence, err)
+			}
+			result.Replayed++
+			result.LastSequence = stored.Sequence
+		}
+		r.log.WithFields(logrus.Fields{
+			"replayed":     result.Replayed,
+			"lastSequence": result.LastSequence,
+		}).Debug("replayed batch")
+		if len(events) < page.Limit {
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+)
+
+type AccountRepository interface {
+	ListAccounts(ctx context.Context) ([]domain.Account, error)
+	CountAccounts(ctx context.Context) (int64, error)
+	GetAccountByID(ctx context.Context, id uint) (*domain.Account, error)
+	GetAccountByUsername(ctx context.Context, username string) (*domain.Account, error)
+	CreateAccount(ctx context.Context, account *domain.Account) error
+	UpdateAccount(ctx context.Context, account *domain.Account) error
+}
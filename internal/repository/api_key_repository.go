@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+)
+
+type APIKeyRepository interface {
+	ListAPIKeys(ctx context.Context) ([]domain.APIKey, error)
+	GetAPIKeyByID(ctx context.Context, id uint) (*domain.APIKey, error)
+	// GetAPIKeyByPrefix returns nil, nil when no key has the prefix.
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error)
+	CreateAPIKey(ctx context.Context, key *domain.APIKey) error
+	RevokeAPIKey(ctx context.Context, id uint, at time.Time) error
+	TouchAPIKey(ctx context.Context, id uint, at time.Time) error
+}
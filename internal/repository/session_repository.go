@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+)
+
+type SessionRepository interface {
+	CreateRefreshToken(ctx context.Context, token *domain.RefreshToken) error
+	// GetRefreshTokenByHash locks the row for the surrounding transaction and
+	// returns nil when no token matches.
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*domain.RefreshToken, error)
+	MarkRefreshTokenRotated(ctx context.Context, id uint, at time.Time) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, at time.Time) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
@@ -2,21 +2,80 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/vele/temp_test_repo/internal/domain"
 )
 
 type UserRepository interface {
-	List(ctx context.Context) ([]domain.User, error)
+	List(ctx context.Context, query UserQuery) (UserPage, error)
 	GetByID(ctx context.Context, id uint) (*domain.User, error)
 	GetByEmail(ctx context.Context, email string) (*domain.User, error)
 	Create(ctx context.Context, user *domain.User) error
+	// Update saves user if its stored version still equals user.Version and
+	// then increments user.Version. A stale version yields
+	// domain.ErrPreconditionFailed.
 	Update(ctx context.Context, user *domain.User) error
-	Delete(ctx context.Context, id uint) error
+	// Delete soft-deletes the user. A non-zero version must match the stored one.
+	Delete(ctx context.Context, id uint, version int64) error
+	// GetDeletedByID returns a soft-deleted user, or domain.ErrNotFound.
+	GetDeletedByID(ctx context.Context, id uint) (*domain.User, error)
+	// Restore undoes a soft delete and increments the user's version.
+	Restore(ctx context.Context, id uint) error
+	// Purge permanently removes the user, deleted or not, with its files.
+	// A non-zero version must match the stored one.
+	Purge(ctx context.Context, id uint, version int64) error
 }
 
+// UserSortField names a column users can be ordered by.
+type UserSortField string
+
+const (
+	SortByID        UserSortField = "id"
+	SortByName      UserSortField = "name"
+	SortByEmail     UserSortField = "email"
+	SortByAge       UserSortField = "age"
+	SortByCreatedAt UserSortField = "created_at"
+)
+
+// UserQuery selects one page of users. Cursor is the opaque NextCursor of a
+// previous page and is only valid with the same sort order.
+type UserQuery struct {
+	Limit         int
+	Cursor        string
+	SortField     UserSortField
+	SortDesc      bool
+	EmailPrefix   string
+	NameContains  string
+	MinAge        *int
+	MaxAge        *int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Deleted selects soft-deleted users instead of live ones.
+	Deleted bool
+}
+
+// UserPage is a slice of users plus the metadata needed to fetch the next one.
+// Total counts every user matching the filters, regardless of the cursor.
+type UserPage struct {
+	Users      []domain.User `json:"data"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Total      int64         `json:"total"`
+}
+
+// FileRepository stores file metadata. Add and UpdateFile return
+// domain.ErrConflict when the user already has a file with the same path.
 type FileRepository interface {
 	ListByUser(ctx context.Context, userID uint) ([]domain.File, error)
+	GetFile(ctx context.Context, userID, fileID uint) (*domain.File, error)
 	Add(ctx context.Context, file *domain.File) error
+	UpdateFile(ctx context.Context, file *domain.File) error
+	DeleteFile(ctx context.Context, userID, fileID uint) error
 	DeleteByUser(ctx context.Context, userID uint) error
 }
+
+// Transactor runs fn atomically. Repositories invoked with the context handed
+// to fn take part in the same transaction.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+)
+
+// DeliveryFilter pages through the deliveries of a webhook, newest first.
+// An empty Status matches every state.
+type DeliveryFilter struct {
+	WebhookID uint
+	Status    string
+	BeforeID  uint
+	Limit     int
+}
+
+type WebhookRepository interface {
+	ListWebhooks(ctx context.Context) ([]domain.Webhook, error)
+	GetWebhookByID(ctx context.Context, id uint) (*domain.Webhook, error)
+	CreateWebhook(ctx context.Context, webhook *domain.Webhook) error
+	UpdateWebhook(ctx context.Context, webhook *domain.Webhook) error
+	// DeleteWebhook removes the webhook and its deliveries.
+	DeleteWebhook(ctx context.Context, id uint) error
+
+	ListDeliveries(ctx context.Context, filter DeliveryFilter) ([]domain.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, webhookID, id uint) (*domain.WebhookDelivery, error)
+	// RescheduleDelivery makes a failed delivery pending again with a fresh
+	// set of attempts. It returns domain.ErrConflict for deliveries that
+	// are not failed.
+	RescheduleDelivery(ctx context.Context, webhookID, id uint) error
+}
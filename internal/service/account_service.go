@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/repository"
+)
+
+const minPasswordLength = 8
+
+// dummyHash is compared against when a username does not exist, so that
+// failed logins take the same time whether or not the account is known.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+type AccountService struct {
+	accounts  repository.AccountRepository
+	tx        repository.Transactor
+	publisher event.Publisher
+}
+
+func NewAccountService(accounts repository.AccountRepository, tx repository.Transactor, publisher event.Publisher) *AccountService {
+	return &AccountService{
+		accounts:  accounts,
+		tx:        tx,
+		publisher: publisher,
+	}
+}
+
+// CreateAccountInput describes a new account. Role defaults to viewer.
+type CreateAccountInput struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role"`
+}
+
+type RotatePasswordInput struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// AccountAudit is the payload of account audit events.
+type AccountAudit = event.AccountAudit
+
+// Bootstrap creates the first account when none exist yet. It is a no-op once
+// any account has been created.
+func (s *AccountService) Bootstrap(ctx context.Context, username, password string) error {
+	count, err := s.accounts.CountAccounts(ctx)
+	if err != nil {
+		return fmt.Errorf("count accounts: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = s.createAccount(ctx, CreateAccountInput{Username: username, Password: password, Role: string(auth.RoleAdmin)})
+	return err
+}
+
+func (s *AccountService) GetAccount(ctx context.Context, id uint) (domain.Account, error) {
+	account, err := s.accounts.GetAccountByID(ctx, id)
+	if err != nil {
+		return domain.Account{}, err
+	}
+	return *account, nil
+}
+
+func (s *AccountService) ListAccounts(ctx context.Context) ([]domain.Account, error) {
+	return s.accounts.ListAccounts(ctx)
+}
+
+// CreateAccount creates an account for the caller in ctx. Callers may only
+// grant roles whose scopes they hold themselves; others yield
+// domain.ErrForbidden.
+func (s *AccountService) CreateAccount(ctx context.Context, input CreateAccountInput) (domain.Account, error) {
+	role, err := parseAccountRole(input.Role)
+	if err != nil {
+		return domain.Account{}, err
+	}
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok || len(principal.MissingScopes(role.Scopes()...)) > 0 {
+		return domain.Account{}, domain.ErrForbidden
+	}
+	return s.createAccount(ctx, input)
+}
+
+func (s *AccountService) createAccount(ctx context.Context, input CreateAccountInput) (domain.Account, error) {
+	username := normalizeUsername(input.Username)
+	if username == "" || len(input.Password) < minPasswordLength {
+		return domain.Account{}, domain.ErrInvalidInput
+	}
+	role, err := parseAccountRole(input.Role)
+	if err != nil {
+		return domain.Account{}, err
+	}
+
+	existing, err := s.accounts.GetAccountByUsername(ctx, username)
+	if err != nil {
+		return domain.Account{}, fmt.Errorf("check username: %w", err)
+	}
+	if existing != nil {
+		return domain.Account{}, domain.ErrConflict
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return domain.Account{}, fmt.Errorf("hash password: %w", err)
+	}
+	now := time.Now().UTC()
+	account := domain.Account{
+		Username:          username,
+		PasswordHash:      string(hash),
+		Role:              string(role),
+		PasswordChangedAt: now,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.accounts.CreateAccount(ctx, &account); err != nil {
+			return fmt.Errorf("create account: %w", err)
+		}
+		return s.audit(ctx, event.AccountCreated, AccountAudit{AccountID: account.ID, Username: account.Username})
+	})
+	if err != nil {
+		return domain.Account{}, err
+	}
+	return account, nil
+}
+
+// DisableAccount blocks future logins for the account. Callers cannot disable
+// their own account, which would otherwise risk locking out the last admin.
+func (s *AccountService) DisableAccount(ctx context.Context, id uint) (domain.Account, error) {
+	if p, ok := auth.PrincipalFromContext(ctx); ok && p.AccountID == id {
+		return domain.Account{}, domain.ErrForbidden
+	}
+	account, err := s.accounts.GetAccountByID(ctx, id)
+	if err != nil {
+		return domain.Account{}, err
+	}
+	if account.Disabled {
+		return *account, nil
+	}
+	account.Disabled = true
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.accounts.UpdateAccount(ctx, account); err != nil {
+			return fmt.Errorf("disable account: %w", err)
+		}
+		return s.audit(ctx, event.AccountDisabled, AccountAudit{AccountID: account.ID, Username: account.Username})
+	})
+	if err != nil {
+		return domain.Account{}, err
+	}
+	return *account, nil
+}
+
+// RotatePassword sets a new password for the account. Taking over an
+// account with scopes the caller lacks would escalate its privileges, so
+// such accounts yield domain.ErrForbidden.
+func (s *AccountService) RotatePassword(ctx context.Context, id uint, input RotatePasswordInput) error {
+	if len(input.Password) < minPasswordLength {
+		return domain.ErrInvalidInput
+	}
+	account, err := s.accounts.GetAccountByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok || len(principal.MissingScopes(auth.Role(account.Role).Scopes()...)) > 0 {
+		return domain.ErrForbidden
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	account.PasswordHash = string(hash)
+	account.PasswordChangedAt = time.Now().UTC()
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.accounts.UpdateAccount(ctx, account); err != nil {
+			return fmt.Errorf("rotate password: %w", err)
+		}
+		return s.audit(ctx, event.AccountPasswordRotated, AccountAudit{AccountID: account.ID, Username: account.Username})
+	})
+}
+
+// parseAccountRole returns the role named s, or viewer if s is empty.
+func parseAccountRole(s string) (auth.Role, error) {
+	if s == "" {
+		return auth.RoleViewer, nil
+	}
+	role, ok := auth.ParseRole(s)
+	if !ok {
+		return "", domain.ErrInvalidInput
+	}
+	return role, nil
+}
+
+// Authenticate verifies a username/password pair. Every failure yields
+// domain.ErrUnauthorized and a LoginFailed audit event; success emits
+// LoginSucceeded.
+func (s *AccountService) Authenticate(ctx context.Context, username, password, clientIP string) (domain.Account, error) {
+	username = normalizeUsername(username)
+	account, err := s.accounts.GetAccountByUsername(ctx, username)
+	if err != nil {
+		return domain.Account{}, fmt.Errorf("load account: %w", err)
+	}
+
+	hash := dummyHash
+	if account != nil {
+		hash = []byte(account.PasswordHash)
+	}
+	match := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+
+	reason := ""
+	switch {
+	case account == nil:
+		reason = "unknown account"
+	case !match:
+		reason = "wrong password"
+	case account.Disabled:
+		reason = "account disabled"
+	}
+	if reason != "" {
+		audit := AccountAudit{Username: username, ClientIP: clientIP, Reason: reason}
+		if account != nil {
+			audit.AccountID = account.ID
+		}
+		if err := s.audit(ctx, event.LoginFailed, audit); err != nil {
+			return domain.Account{}, err
+		}
+		return domain.Account{}, domain.ErrUnauthorized
+	}
+
+	audit := AccountAudit{AccountID: account.ID, Username: account.Username, ClientIP: clientIP}
+	if err := s.audit(ctx, event.LoginSucceeded, audit); err != nil {
+		return domain.Account{}, err
+	}
+	return *account, nil
+}
+
+func (s *AccountService) audit(ctx context.Context, typ event.Type, payload AccountAudit) error {
+	evt := event.New(ctx, typ, 0, payload)
+	if err := s.publisher.Publish(ctx, evt); err != nil {
+		return fmt.Errorf("publish %s: %w", typ, err)
+	}
+	return nil
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// adminContext carries a principal allowed to manage every account.
+func adminContext() context.Context {
+	return auth.WithPrincipal(context.Background(), auth.Principal{Subject: "admin", Scopes: auth.RoleAdmin.Scopes()})
+}
+
+func TestBootstrap_SeedsOnlyFirstAccount(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAccountService(repo, repo, publisher)
+	ctx := context.Background()
+
+	require.NoError(t, svc.Bootstrap(ctx, "Admin", "changeme"))
+	require.NoError(t, svc.Bootstrap(ctx, "other", "changeme"))
+
+	accounts, err := svc.ListAccounts(ctx)
+	require.NoError(t, err)
+	require.Len(t, accounts, 1)
+	require.Equal(t, "admin", accounts[0].Username)
+	require.NotEqual(t, "changeme", accounts[0].PasswordHash)
+}
+
+func TestAuthenticate_EmitsAuditEvents(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAccountService(repo, repo, publisher)
+	ctx := adminContext()
+
+	_, err := svc.CreateAccount(ctx, CreateAccountInput{Username: "ops", Password: "correct-horse"})
+	require.NoError(t, err)
+
+	_, err = svc.Authenticate(ctx, "ops", "wrong-horse", "10.0.0.1")
+	require.ErrorIs(t, err, domain.ErrUnauthorized)
+
+	_, err = svc.Authenticate(ctx, "nobody", "correct-horse", "10.0.0.1")
+	require.ErrorIs(t, err, domain.ErrUnauthorized)
+
+	account, err := svc.Authenticate(ctx, "OPS", "correct-horse", "10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, "ops", account.Username)
+
+	events := publisher.Events()
+	require.Len(t, events, 4)
+	require.Equal(t, event.AccountCreated, events[0].Type)
+	require.Equal(t, event.LoginFailed, events[1].Type)
+	require.Equal(t, event.LoginFailed, events[2].Type)
+	require.Equal(t, event.LoginSucceeded, events[3].Type)
+	require.Equal(t, "10.0.0.1", events[3].Payload.(AccountAudit).ClientIP)
+}
+
+func TestDisableAccount_BlocksLogin(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAccountService(repo, repo, publisher)
+	ctx := adminContext()
+
+	account, err := svc.CreateAccount(ctx, CreateAccountInput{Username: "temp", Password: "temporary-pass"})
+	require.NoError(t, err)
+
+	_, err = svc.DisableAccount(ctx, account.ID)
+	require.NoError(t, err)
+
+	_, err = svc.Authenticate(ctx, "temp", "temporary-pass", "")
+	require.ErrorIs(t, err, domain.ErrUnauthorized)
+}
+
+func TestDisableAccount_RefusesOwnAccount(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAccountService(repo, repo, publisher)
+	ctx := adminContext()
+
+	account, err := svc.CreateAccount(ctx, CreateAccountInput{Username: "self", Password: "self-password", Role: "admin"})
+	require.NoError(t, err)
+
+	ctx = auth.WithPrincipal(ctx, auth.Principal{Subject: "self", AccountID: account.ID})
+	_, err = svc.DisableAccount(ctx, account.ID)
+	require.ErrorIs(t, err, domain.ErrForbidden)
+}
+
+func TestRotatePassword_ReplacesCredential(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAccountService(repo, repo, publisher)
+	ctx := adminContext()
+
+	account, err := svc.CreateAccount(ctx, CreateAccountInput{Username: "rotate", Password: "first-password"})
+	require.NoError(t, err)
+
+	require.ErrorIs(t, svc.RotatePassword(ctx, account.ID, RotatePasswordInput{Password: "short"}), domain.ErrInvalidInput)
+	require.NoError(t, svc.RotatePassword(ctx, account.ID, RotatePasswordInput{Password: "second-password"}))
+
+	_, err = svc.Authenticate(ctx, "rotate", "first-password", "")
+	require.ErrorIs(t, err, domain.ErrUnauthorized)
+	_, err = svc.Authenticate(ctx, "rotate", "second-password", "")
+	require.NoError(t, err)
+}
+
+func TestCreateAccount_RejectsDuplicateUsername(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAccountService(repo, repo, publisher)
+	ctx := adminContext()
+
+	_, err := svc.CreateAccount(ctx, CreateAccountInput{Username: "dup", Password: "password-one"})
+	require.NoError(t, err)
+	_, err = svc.CreateAccount(ctx, CreateAccountInput{Username: " DUP ", Password: "password-two"})
+	require.ErrorIs(t, err, domain.ErrConflict)
+}
+
+func TestCreateAccount_RefusesRolesTheCallerLacks(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAccountService(repo, repo, publisher)
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "uak_0123456789ab", Scopes: []string{auth.ScopeAccountsManage, auth.ScopeUsersRead}})
+
+	_, err := svc.CreateAccount(ctx, CreateAccountInput{Username: "root", Password: "root-password", Role: "admin"})
+	require.ErrorIs(t, err, domain.ErrForbidden)
+	_, err = svc.CreateAccount(context.Background(), CreateAccountInput{Username: "anon", Password: "anon-password"})
+	require.ErrorIs(t, err, domain.ErrForbidden)
+
+	account, err := svc.CreateAccount(ctx, CreateAccountInput{Username: "reader", Password: "reader-password"})
+	require.NoError(t, err)
+	require.Equal(t, string(auth.RoleViewer), account.Role)
+}
+
+func TestRotatePassword_RefusesAccountsWithScopesTheCallerLacks(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAccountService(repo, repo, publisher)
+	admin, err := svc.CreateAccount(adminContext(), CreateAccountInput{Username: "root", Password: "root-password", Role: "admin"})
+	require.NoError(t, err)
+	viewer, err := svc.CreateAccount(adminContext(), CreateAccountInput{Username: "reader", Password: "reader-password"})
+	require.NoError(t, err)
+
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "uak_0123456789ab", Scopes: []string{auth.ScopeAccountsManage, auth.ScopeUsersRead}})
+	require.ErrorIs(t, svc.RotatePassword(ctx, admin.ID, RotatePasswordInput{Password: "taken-over"}), domain.ErrForbidden)
+	_, err = svc.Authenticate(ctx, "root", "root-password", "")
+	require.NoError(t, err, "the password is unchanged")
+
+	require.NoError(t, svc.RotatePassword(ctx, viewer.ID, RotatePasswordInput{Password: "new-reader-password"}))
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/repository"
+)
+
+// lastUsedGranularity limits how often a key's last_used_at is written, so
+// that busy clients do not cause a database write per request.
+const lastUsedGranularity = time.Minute
+
+// APIKeyService manages API keys for service-to-service clients and
+// authenticates requests presenting them.
+type APIKeyService struct {
+	keys      repository.APIKeyRepository
+	tx        repository.Transactor
+	publisher event.Publisher
+}
+
+func NewAPIKeyService(keys repository.APIKeyRepository, tx repository.Transactor, publisher event.Publisher) *APIKeyService {
+	return &APIKeyService{
+		keys:      keys,
+		tx:        tx,
+		publisher: publisher,
+	}
+}
+
+// CreateAPIKeyInput describes a new key. Keys without ExpiresAt never expire.
+type CreateAPIKeyInput struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedAPIKey carries the plaintext key, which is only available once.
+type CreatedAPIKey struct {
+	domain.APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyAudit is the payload of API key audit events.
+type APIKeyAudit = event.APIKeyAudit
+
+func (s *APIKeyService) ListAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	return s.keys.ListAPIKeys(ctx)
+}
+
+// CreateAPIKey mints a key for the scopes in input. The caller must hold
+// every requested scope itself, so a key can never grant more than its
+// creator; otherwise domain.ErrForbidden is returned.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, input CreateAPIKeyInput) (CreatedAPIKey, error) {
+	if len(input.Scopes) == 0 {
+		return CreatedAPIKey{}, domain.ErrInvalidInput
+	}
+	for _, scope := range input.Scopes {
+		if !auth.IsScope(scope) {
+			return CreatedAPIKey{}, domain.ErrInvalidInput
+		}
+	}
+	now := time.Now().UTC()
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(now) {
+		return CreatedAPIKey{}, domain.ErrInvalidInput
+	}
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok || len(principal.MissingScopes(input.Scopes...)) > 0 {
+		return CreatedAPIKey{}, domain.ErrForbidden
+	}
+
+	plaintext, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		return CreatedAPIKey{}, fmt.Errorf("generate api key: %w", err)
+	}
+	actor := actorFromContext(ctx)
+	key := domain.APIKey{
+		Name:      input.Name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(plaintext),
+		Scopes:    input.Scopes,
+		CreatedBy: actor,
+		ExpiresAt: input.ExpiresAt,
+		CreatedAt: now,
+	}
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.keys.CreateAPIKey(ctx, &key); err != nil {
+			return fmt.Errorf("create api key: %w", err)
+		}
+		return s.audit(ctx, event.APIKeyCreated, APIKeyAudit{KeyID: key.ID, Prefix: key.Prefix, Name: key.Name, Actor: actor})
+	})
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	return CreatedAPIKey{APIKey: key, Key: plaintext}, nil
+}
+
+// RevokeAPIKey makes the key unusable. Revoking a revoked key is a no-op.
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id uint) error {
+	key, err := s.keys.GetAPIKeyByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.keys.RevokeAPIKey(ctx, id, time.Now().UTC()); err != nil {
+			return fmt.Errorf("revoke api key: %w", err)
+		}
+		return s.audit(ctx, event.APIKeyRevoked, APIKeyAudit{KeyID: key.ID, Prefix: key.Prefix, Name: key.Name, Actor: actorFromContext(ctx)})
+	})
+}
+
+// AuthenticateAPIKey resolves a presented key to a principal carrying the
+// key's scopes. Unknown, revoked and expired keys yield domain.ErrUnauthorized.
+func (s *APIKeyService) AuthenticateAPIKey(ctx context.Context, plaintext string) (auth.Principal, error) {
+	prefix, ok := auth.ParseAPIKey(plaintext)
+	if !ok {
+		return auth.Principal{}, domain.ErrUnauthorized
+	}
+	key, err := s.keys.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return auth.Principal{}, fmt.Errorf("load api key: %w", err)
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashToken(plaintext))) != 1 {
+		return auth.Principal{}, domain.ErrUnauthorized
+	}
+	now := time.Now().UTC()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return auth.Principal{}, domain.ErrUnauthorized
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedGranularity {
+		if err := s.keys.TouchAPIKey(ctx, key.ID, now); err != nil {
+			return auth.Principal{}, fmt.Errorf("touch api key: %w", err)
+		}
+	}
+
+	principal := auth.Principal{Subject: key.Prefix, Scopes: key.Scopes}
+	if key.ExpiresAt != nil {
+		principal.ExpiresAt = *key.ExpiresAt
+	}
+	return principal, nil
+}
+
+func (s *APIKeyService) audit(ctx context.Context, typ event.Type, payload APIKeyAudit) error {
+	evt := event.New(ctx, typ, 0, payload)
+	if err := s.publisher.Publish(ctx, evt); err != nil {
+		return fmt.Errorf("publish %s: %w", typ, err)
+	}
+	return nil
+}
+
+func actorFromContext(ctx context.Context) string {
+	if p, ok := auth.PrincipalFromContext(ctx); ok {
+		return p.Subject
+	}
+	return ""
+}
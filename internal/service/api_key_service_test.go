@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+func TestAPIKey_AuthenticatesWithItsScopes(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAPIKeyService(repo, repo, publisher)
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "admin", Scopes: auth.RoleAdmin.Scopes()})
+
+	created, err := svc.CreateAPIKey(ctx, CreateAPIKeyInput{Name: "export", Scopes: []string{auth.ScopeUsersRead}})
+	require.NoError(t, err)
+	require.Equal(t, "admin", created.CreatedBy)
+	require.NotContains(t, created.KeyHash, created.Key)
+
+	p, err := svc.AuthenticateAPIKey(ctx, created.Key)
+	require.NoError(t, err)
+	require.Equal(t, created.Prefix, p.Subject)
+	require.Equal(t, []string{auth.ScopeUsersRead}, p.Scopes)
+
+	keys, err := svc.ListAPIKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.NotNil(t, keys[0].LastUsedAt)
+
+	_, err = svc.AuthenticateAPIKey(ctx, created.Prefix+"_wrong-secret")
+	require.ErrorIs(t, err, domain.ErrUnauthorized)
+
+	require.NoError(t, svc.RevokeAPIKey(ctx, created.ID))
+	_, err = svc.AuthenticateAPIKey(ctx, created.Key)
+	require.ErrorIs(t, err, domain.ErrUnauthorized)
+
+	events := publisher.Events()
+	require.Len(t, events, 2)
+	require.Equal(t, event.APIKeyCreated, events[0].Type)
+	require.Equal(t, event.APIKeyRevoked, events[1].Type)
+}
+
+func TestAPIKey_RejectsUnknownScopesAndPastExpiry(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAPIKeyService(repo, repo, publisher)
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "admin", Scopes: auth.RoleAdmin.Scopes()})
+
+	_, err := svc.CreateAPIKey(ctx, CreateAPIKeyInput{Name: "bad", Scopes: []string{"users:everything"}})
+	require.ErrorIs(t, err, domain.ErrInvalidInput)
+
+	past := time.Now().Add(-time.Minute)
+	_, err = svc.CreateAPIKey(ctx, CreateAPIKeyInput{Name: "old", Scopes: []string{auth.ScopeUsersRead}, ExpiresAt: &past})
+	require.ErrorIs(t, err, domain.ErrInvalidInput)
+}
+
+func TestAPIKey_CannotGrantScopesTheCallerLacks(t *testing.T) {
+	_, repo, publisher := setupService(t)
+	svc := NewAPIKeyService(repo, repo, publisher)
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "uak_0123456789ab", Scopes: []string{auth.ScopeAccountsManage, auth.ScopeUsersRead}})
+
+	_, err := svc.CreateAPIKey(ctx, CreateAPIKeyInput{Name: "escalate", Scopes: []string{auth.ScopeUsersRead, auth.ScopeUsersDelete}})
+	require.ErrorIs(t, err, domain.ErrForbidden)
+	_, err = svc.CreateAPIKey(context.Background(), CreateAPIKeyInput{Name: "anonymous", Scopes: []string{auth.ScopeUsersRead}})
+	require.ErrorIs(t, err, domain.ErrForbidden)
+
+	created, err := svc.CreateAPIKey(ctx, CreateAPIKeyInput{Name: "reader", Scopes: []string{auth.ScopeUsersRead}})
+	require.NoError(t, err)
+	require.Equal(t, []string{auth.ScopeUsersRead}, created.Scopes)
+}
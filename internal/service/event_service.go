@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// maxReplayEvents bounds a replay started through the API; larger ranges
+// are replayed in several requests or with the replay command.
+const maxReplayEvents = 10000
+
+// ReplayPublisherFactory opens a publisher for a replay target. The service
+// calls close once the replay is done.
+type ReplayPublisherFactory func(target event.ReplayTarget) (publisher event.Publisher, close func(), err error)
+
+// EventService reads the event store and replays stored events.
+type EventService struct {
+	store      event.EventStore
+	replayer   *event.Replayer
+	publishers ReplayPublisherFactory
+}
+
+func NewEventService(store event.EventStore, replayer *event.Replayer, publishers ReplayPublisherFactory) *EventService {
+	return &EventService{store: store, replayer: replayer, publishers: publishers}
+}
+
+// EventQuery carries the filters shared by listing and replaying events.
+// Types are event type names such as "UserCreated"; Since is inclusive and
+// Until exclusive.
+type EventQuery struct {
+	AfterSequence int64      `form:"after_sequence" json:"after_sequence"`
+	ToSequence    int64      `form:"to_sequence" json:"to_sequence"`
+	Types         []string   `form:"type" json:"types"`
+	UserID        uint       `form:"user_id" json:"user_id"`
+	Since         *time.Time `form:"since" json:"since"`
+	Until         *time.Time `form:"until" json:"until"`
+	Limit         int        `form:"limit" json:"limit"`
+}
+
+// ReplayInput selects the events to replay and where to send them.
+type ReplayInput struct {
+	EventQuery
+	Target event.ReplayTarget `json:"target"`
+}
+
+// ListEvents pages through stored events in sequence order. Continue with
+// AfterSequence set to the last sequence returned.
+func (s *EventService) ListEvents(ctx context.Context, query EventQuery) ([]event.StoredEvent, error) {
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
+	if query.Limit > maxPageSize {
+		return nil, domain.ErrInvalidInput
+	}
+	filter, err := query.filter()
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Events(ctx, filter)
+}
+
+// Replay re-publishes matching events to the target, at most
+// maxReplayEvents per call. When the result reports that many, resume with
+// AfterSequence set to its LastSequence.
+func (s *EventService) Replay(ctx context.Context, input ReplayInput) (event.ReplayResult, error) {
+	if (input.Target.Exchange == "") == (input.Target.Queue == "") {
+		return event.ReplayResult{}, domain.ErrInvalidInput
+	}
+	if input.Limit == 0 {
+		input.Limit = maxReplayEvents
+	}
+	if input.Limit > maxReplayEvents {
+		return event.ReplayResult{}, domain.ErrInvalidInput
+	}
+	filter, err := input.filter()
+	if err != nil {
+		return event.ReplayResult{}, err
+	}
+	publisher, closePublisher, err := s.publishers(input.Target)
+	if err != nil {
+		return event.ReplayResult{}, fmt.Errorf("open replay target: %w", err)
+	}
+	defer closePublisher()
+	return s.replayer.Replay(ctx, filter, publisher)
+}
+
+func (q EventQuery) filter() (event.EventFilter, error) {
+	if q.Limit < 0 || q.AfterSequence < 0 || q.ToSequence < 0 {
+		return event.EventFilter{}, domain.ErrInvalidInput
+	}
+	if q.ToSequence != 0 && q.ToSequence <= q.AfterSequence {
+		return event.EventFilter{}, domain.ErrInvalidInput
+	}
+	if q.Since != nil && q.Until != nil && !q.Until.After(*q.Since) {
+		return event.EventFilter{}, domain.ErrInvalidInput
+	}
+	filter := event.EventFilter{
+		AfterSequence: q.AfterSequence,
+		ToSequence:    q.ToSequence,
+		UserID:        q.UserID,
+		Limit:         q.Limit,
+	}
+	for _, name := range q.Types {
+		filter.Types = append(filter.Types, event.Type(name))
+	}
+	if q.Since != nil {
+		filter.Since = *q.Since
+	}
+	if q.Until != nil {
+		filter.Until = *q.Until
+	}
+	return filter, nil
+}
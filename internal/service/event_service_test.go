@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+	postgresstorage "github.com/vele/temp_test_repo/internal/storage/postgres"
+)
+
+func TestEventStore_RecordsAndReplaysFilteredEvents(t *testing.T) {
+	_, repo, _ := setupService(t)
+	ctx := context.Background()
+	store := postgresstorage.NewEventStore(repo)
+	users := NewUserService(repo, repo, repo, event.Publishers{store, postgresstorage.NewOutbox(repo)})
+
+	start := time.Now().UTC()
+	alice, err := users.CreateUser(ctx, CreateUserInput{Name: "Alice", Email: "alice@example.com", Age: 30})
+	require.NoError(t, err)
+	bob, err := users.CreateUser(ctx, CreateUserInput{Name: "Bob", Email: "bob@example.com", Age: 30})
+	require.NoError(t, err)
+	require.NoError(t, users.DeleteUser(ctx, alice.ID, 0))
+
+	// A failed change records nothing.
+	_, err = users.CreateUser(ctx, CreateUserInput{Name: "Bob", Email: "bob@example.com", Age: 30})
+	require.ErrorIs(t, err, domain.ErrConflict)
+
+	target := event.NewInMemoryPublisher()
+	var opened event.ReplayTarget
+	svc := NewEventService(store, event.NewReplayer(store, 1, nil), func(t event.ReplayTarget) (event.Publisher, func(), error) {
+		opened = t
+		return target, func() {}, nil
+	})
+
+	all, err := svc.ListEvents(ctx, EventQuery{})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	for i, stored := range all {
+		require.EqualValues(t, i+1, stored.Sequence)
+	}
+
+	since := start.Add(-time.Second)
+	result, err := svc.Replay(ctx, ReplayInput{
+		EventQuery: EventQuery{Types: []string{string(event.UserCreated)}, Since: &since},
+		Target:     event.ReplayTarget{Queue: "rebuild"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, event.ReplayResult{Replayed: 2, LastSequence: 2}, result)
+	require.Equal(t, event.ReplayTarget{Queue: "rebuild"}, opened)
+	replayed := target.Events()
+	require.Equal(t, alice.ID, replayed[0].UserID)
+	require.Equal(t, bob.ID, replayed[1].UserID)
+	require.Equal(t, all[0].ID, replayed[0].ID)
+
+	mine, err := svc.ListEvents(ctx, EventQuery{UserID: alice.ID, AfterSequence: 1})
+	require.NoError(t, err)
+	require.Len(t, mine, 1)
+	require.Equal(t, event.UserDeleted, mine[0].Type)
+
+	_, err = svc.Replay(ctx, ReplayInput{})
+	require.ErrorIs(t, err, domain.ErrInvalidInput)
+	_, err = svc.ListEvents(ctx, EventQuery{AfterSequence: 3, ToSequence: 2})
+	require.ErrorIs(t, err, domain.ErrInvalidInput)
+}
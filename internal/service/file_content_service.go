@@ -0,0 +1,261 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/repository"
+	"github.com/vele/temp_test_repo/internal/storage/blob"
+)
+
+// sniffLen is the number of leading bytes http.DetectContentType inspects.
+const sniffLen = 512
+
+// FileContentService stores uploaded file content in a blob store and keeps
+// the file records in step with it. Metadata-only operations stay on
+// UserService.
+type FileContentService struct {
+	users    *UserService
+	files    repository.FileRepository
+	blobs    blob.Store
+	maxBytes int64
+}
+
+func NewFileContentService(users *UserService, files repository.FileRepository, blobs blob.Store, maxBytes int64) *FileContentService {
+	return &FileContentService{
+		users:    users,
+		files:    files,
+		blobs:    blobs,
+		maxBytes: maxBytes,
+	}
+}
+
+// MaxBytes is the largest file content the service accepts.
+func (s *FileContentService) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+// Upload stores content and records it as a new file of the user. Size,
+// SHA256 and StorageKey are taken from the stored content; an empty or
+// generic ContentType is replaced by one sniffed from the first bytes.
+func (s *FileContentService) Upload(ctx context.Context, userID uint, input FileInput, content io.Reader) (domain.File, error) {
+	if _, err := s.users.GetUser(ctx, userID); err != nil {
+		return domain.File{}, err
+	}
+	stored, err := s.store(ctx, userID, input.ContentType, content)
+	if err != nil {
+		return domain.File{}, err
+	}
+	input.Size = stored.size
+	input.SHA256 = stored.sha256
+	input.ContentType = stored.contentType
+	input.StorageKey = stored.key
+
+	file, err := s.users.AddFile(ctx, userID, input)
+	if err != nil {
+		_ = s.blobs.Delete(ctx, stored.key)
+		return domain.File{}, err
+	}
+	return file, nil
+}
+
+// ReplaceContent stores new content for an existing file and then drops the
+// previous content.
+func (s *FileContentService) ReplaceContent(ctx context.Context, userID, fileID uint, contentType string, content io.Reader) (domain.File, error) {
+	file, err := s.users.GetFile(ctx, userID, fileID)
+	if err != nil {
+		return domain.File{}, err
+	}
+	stored, err := s.store(ctx, userID, contentType, content)
+	if err != nil {
+		return domain.File{}, err
+	}
+	previous := file.StorageKey
+	file.Size = stored.size
+	file.SHA256 = stored.sha256
+	file.ContentType = stored.contentType
+	file.StorageKey = stored.key
+
+	if err := s.files.UpdateFile(ctx, &file); err != nil {
+		_ = s.blobs.Delete(ctx, stored.key)
+		if err == domain.ErrNotFound {
+			return domain.File{}, err
+		}
+		return domain.File{}, fmt.Errorf("update file: %w", err)
+	}
+	s.deleteBlobs(ctx, previous)
+	return file, nil
+}
+
+// Open returns a file together with its content. Files recorded by
+// reference only have no content and yield domain.ErrNotFound.
+func (s *FileContentService) Open(ctx context.Context, userID, fileID uint) (domain.File, io.ReadSeekCloser, error) {
+	file, err := s.users.GetFile(ctx, userID, fileID)
+	if err != nil {
+		return domain.File{}, nil, err
+	}
+	if file.StorageKey == "" {
+		return domain.File{}, nil, domain.ErrNotFound
+	}
+	content, err := s.blobs.Open(ctx, file.StorageKey)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			return domain.File{}, nil, err
+		}
+		return domain.File{}, nil, fmt.Errorf("open file content: %w", err)
+	}
+	return file, content, nil
+}
+
+// DeleteFile removes a file and its content.
+func (s *FileContentService) DeleteFile(ctx context.Context, userID, fileID uint) error {
+	file, err := s.users.GetFile(ctx, userID, fileID)
+	if err != nil {
+		return err
+	}
+	if err := s.users.DeleteFile(ctx, userID, fileID); err != nil {
+		return err
+	}
+	s.deleteBlobs(ctx, file.StorageKey)
+	return nil
+}
+
+// DeleteFiles removes all files of a user and their content.
+func (s *FileContentService) DeleteFiles(ctx context.Context, userID uint) error {
+	files, err := s.users.ListFiles(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.users.DeleteFiles(ctx, userID); err != nil {
+		return err
+	}
+	s.deleteBlobs(ctx, storageKeys(files)...)
+	return nil
+}
+
+// PurgeUser permanently removes a user as UserService.PurgeUser does, and
+// also the content of its files.
+func (s *FileContentService) PurgeUser(ctx context.Context, id uint, version int64) error {
+	files, err := s.files.ListByUser(ctx, id)
+	if err != nil {
+		return fmt.Errorf("list files: %w", err)
+	}
+	if err := s.users.PurgeUser(ctx, id, version); err != nil {
+		return err
+	}
+	s.deleteBlobs(ctx, storageKeys(files)...)
+	return nil
+}
+
+// deleteBlobs removes content whose file record is already gone. Failures
+// only leave unreferenced blobs behind, so they are not reported.
+func (s *FileContentService) deleteBlobs(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if key != "" {
+			_ = s.blobs.Delete(ctx, key)
+		}
+	}
+}
+
+type storedBlob struct {
+	key         string
+	size        int64
+	sha256      string
+	contentType string
+}
+
+// store writes content under a fresh key, measuring and hashing it on the
+// way through. Content over the size limit is rejected with
+// domain.ErrTooLarge.
+func (s *FileContentService) store(ctx context.Context, userID uint, contentType string, content io.Reader) (storedBlob, error) {
+	key, err := newStorageKey(userID)
+	if err != nil {
+		return storedBlob{}, err
+	}
+	limited := &limitReader{r: content, remaining: s.maxBytes}
+	buffered := bufio.NewReaderSize(limited, sniffLen)
+	head, _ := buffered.Peek(sniffLen)
+	if limited.exceeded {
+		return storedBlob{}, domain.ErrTooLarge
+	}
+	contentType = strings.TrimSpace(contentType)
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(head)
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	err = s.blobs.Put(ctx, key, io.TeeReader(buffered, io.MultiWriter(hash, counter)), contentType)
+	if limited.exceeded {
+		_ = s.blobs.Delete(ctx, key)
+		return storedBlob{}, domain.ErrTooLarge
+	}
+	if err != nil {
+		return storedBlob{}, fmt.Errorf("store file content: %w", err)
+	}
+	return storedBlob{
+		key:         key,
+		size:        counter.n,
+		sha256:      hex.EncodeToString(hash.Sum(nil)),
+		contentType: contentType,
+	}, nil
+}
+
+// newStorageKey returns a random key grouped by user. Keys are never reused,
+// so replacing content cannot race with a download of the old content.
+func newStorageKey(userID uint) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate storage key: %w", err)
+	}
+	return fmt.Sprintf("users/%d/%s", userID, hex.EncodeToString(buf)), nil
+}
+
+func storageKeys(files []domain.File) []string {
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		keys = append(keys, file.StorageKey)
+	}
+	return keys
+}
+
+// limitReader fails with domain.ErrTooLarge once more than remaining bytes
+// have been read.
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, domain.ErrTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		return 0, domain.ErrTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
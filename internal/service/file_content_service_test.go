@@ -0,0 +1,59 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/storage/blob"
+)
+
+func TestFileContent_UploadReplaceAndPurge(t *testing.T) {
+	users, repo, _ := setupService(t)
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	svc := NewFileContentService(users, repo, blobs, 64)
+	ctx := context.Background()
+
+	user, err := users.CreateUser(ctx, CreateUserInput{
+		Name:  "Uploader",
+		Email: "uploader@example.com",
+		Age:   33,
+	})
+	require.NoError(t, err)
+
+	file, err := svc.Upload(ctx, user.ID, FileInput{Name: "notes", Path: "/notes.txt"}, strings.NewReader("plain notes"))
+	require.NoError(t, err)
+	require.Equal(t, int64(11), file.Size)
+	require.Equal(t, "text/plain; charset=utf-8", file.ContentType)
+	require.Len(t, file.SHA256, 64)
+	firstKey := file.StorageKey
+
+	_, err = svc.Upload(ctx, user.ID, FileInput{Name: "big", Path: "/big.bin"}, bytes.NewReader(make([]byte, 65)))
+	require.ErrorIs(t, err, domain.ErrTooLarge)
+	_, err = svc.Upload(ctx, user.ID, FileInput{Name: "copy", Path: "/notes.txt"}, strings.NewReader("dup"))
+	require.ErrorIs(t, err, domain.ErrConflict)
+
+	replaced, err := svc.ReplaceContent(ctx, user.ID, file.ID, "text/markdown", strings.NewReader("# notes"))
+	require.NoError(t, err)
+	require.Equal(t, "text/markdown", replaced.ContentType)
+	require.NotEqual(t, firstKey, replaced.StorageKey)
+	_, err = blobs.Open(ctx, firstKey)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+
+	_, content, err := svc.Open(ctx, user.ID, file.ID)
+	require.NoError(t, err)
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+	require.NoError(t, content.Close())
+	require.Equal(t, "# notes", string(data))
+
+	require.NoError(t, svc.PurgeUser(ctx, user.ID, 0))
+	_, err = blobs.Open(ctx, replaced.StorageKey)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+}
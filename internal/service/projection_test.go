@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	postgresstorage "github.com/vele/temp_test_repo/internal/storage/postgres"
+)
+
+func TestUserProjection_IgnoresEventsRetriedOutOfOrder(t *testing.T) {
+	_, repo, _ := setupService(t)
+	ctx := context.Background()
+	projection := postgresstorage.NewUserProjection(repo)
+
+	user := func() postgresstorage.UserProjectionModel {
+		t.Helper()
+		var model postgresstorage.UserProjectionModel
+		require.NoError(t, repo.DB().First(&model, "user_id = ?", 1).Error)
+		return model
+	}
+	liveFiles := func() []uint {
+		t.Helper()
+		var ids []uint
+		require.NoError(t, repo.DB().Model(&postgresstorage.UserFileProjectionModel{}).
+			Where("deleted_at IS NULL").Order("file_id").Pluck("file_id", &ids).Error)
+		return ids
+	}
+
+	v1 := domain.User{ID: 1, Name: "Alice", Email: "alice@example.com", Age: 30, Version: 1}
+	v2 := domain.User{ID: 1, Name: "Alicia", Email: "alice@example.com", Age: 30, Version: 2}
+	require.NoError(t, projection.UpsertUser(ctx, v1))
+	require.NoError(t, projection.UpsertUser(ctx, v2))
+	require.NoError(t, projection.UpsertUser(ctx, v1))
+	require.Equal(t, "Alicia", user().Name, "older versions are ignored")
+
+	// Soft deletes keep the version, so a retried event of that version
+	// must not undo them; a restore bumps it.
+	require.NoError(t, projection.MarkUserDeleted(ctx, 1, time.Now()))
+	require.NoError(t, projection.UpsertUser(ctx, v2))
+	require.NotNil(t, user().DeletedAt)
+	require.NoError(t, projection.UpsertUser(ctx, domain.User{ID: 1, Name: "Alicia", Version: 3}))
+	require.Nil(t, user().DeletedAt)
+
+	require.NoError(t, projection.PutFile(ctx, domain.File{ID: 10, UserID: 1, Path: "/a"}))
+	require.NoError(t, projection.PutFile(ctx, domain.File{ID: 11, UserID: 1, Path: "/b"}))
+	require.NoError(t, projection.RemoveFile(ctx, 1, 10))
+	require.NoError(t, projection.PutFile(ctx, domain.File{ID: 10, UserID: 1, Path: "/a"}))
+	// A delete applied before its FileAdded leaves a tombstone.
+	require.NoError(t, projection.RemoveFile(ctx, 1, 12))
+	require.NoError(t, projection.PutFile(ctx, domain.File{ID: 12, UserID: 1, Path: "/c"}))
+	require.Equal(t, []uint{11}, liveFiles())
+
+	require.NoError(t, projection.RemoveUser(ctx, 1))
+	require.NoError(t, projection.UpsertUser(ctx, domain.User{ID: 1, Name: "Alicia", Version: 4}))
+	require.NoError(t, projection.PutFile(ctx, domain.File{ID: 13, UserID: 1, Path: "/d"}))
+	purged := user()
+	require.NotNil(t, purged.PurgedAt)
+	require.Empty(t, purged.Name)
+	require.Empty(t, purged.Email)
+	require.Empty(t, liveFiles())
+
+	// A purge applied before the user was ever projected also sticks.
+	require.NoError(t, projection.RemoveUser(ctx, 2))
+	require.NoError(t, projection.UpsertUser(ctx, domain.User{ID: 2, Name: "Bob", Version: 1}))
+	var bob postgresstorage.UserProjectionModel
+	require.NoError(t, repo.DB().First(&bob, "user_id = ?", 2).Error)
+	require.NotNil(t, bob.PurgedAt)
+	require.Empty(t, bob.Name)
+}
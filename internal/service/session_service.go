@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/repository"
+)
+
+// SessionService issues short-lived access tokens together with rotating
+// refresh tokens. Each refresh token may be used once; presenting an already
+// rotated token is treated as theft and revokes its whole family.
+type SessionService struct {
+	accounts   *AccountService
+	sessions   repository.SessionRepository
+	tx         repository.Transactor
+	publisher  event.Publisher
+	issuer     *auth.TokenIssuer
+	refreshTTL time.Duration
+}
+
+func NewSessionService(accounts *AccountService, sessions repository.SessionRepository, tx repository.Transactor, publisher event.Publisher, issuer *auth.TokenIssuer, refreshTTL time.Duration) *SessionService {
+	return &SessionService{
+		accounts:   accounts,
+		sessions:   sessions,
+		tx:         tx,
+		publisher:  publisher,
+		issuer:     issuer,
+		refreshTTL: refreshTTL,
+	}
+}
+
+type TokenPair struct {
+	AccessToken      string `json:"token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+// Login authenticates the account and starts a new refresh token family.
+func (s *SessionService) Login(ctx context.Context, username, password, clientIP string) (TokenPair, error) {
+	account, err := s.accounts.Authenticate(ctx, username, password, clientIP)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	familyID, err := auth.RandomID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return s.issue(ctx, account, familyID)
+}
+
+// Refresh exchanges a refresh token for a new pair.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	// Revoking a family must commit, so the closure reports it through
+	// reused and familyRevoked rather than an error that would roll it back.
+	var pair TokenPair
+	var reused *domain.RefreshToken
+	var familyRevoked bool
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		stored, err := s.sessions.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+		if err != nil {
+			return fmt.Errorf("load refresh token: %w", err)
+		}
+		if stored == nil || stored.RevokedAt != nil {
+			return domain.ErrUnauthorized
+		}
+		now := time.Now().UTC()
+		if stored.RotatedAt != nil {
+			reused = stored
+			return s.sessions.RevokeRefreshTokenFamily(ctx, stored.FamilyID, now)
+		}
+		if now.After(stored.ExpiresAt) {
+			return domain.ErrUnauthorized
+		}
+
+		account, err := s.accounts.GetAccount(ctx, stored.AccountID)
+		if err != nil {
+			if err == domain.ErrNotFound {
+				return domain.ErrUnauthorized
+			}
+			return err
+		}
+		if account.Disabled || account.PasswordChangedAt.After(stored.CreatedAt) {
+			familyRevoked = true
+			return s.sessions.RevokeRefreshTokenFamily(ctx, stored.FamilyID, now)
+		}
+
+		if err := s.sessions.MarkRefreshTokenRotated(ctx, stored.ID, now); err != nil {
+			return fmt.Errorf("rotate refresh token: %w", err)
+		}
+		pair, err = s.issue(ctx, account, stored.FamilyID)
+		return err
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if reused != nil {
+		payload := AccountAudit{AccountID: reused.AccountID, Reason: "refresh token family " + reused.FamilyID + " revoked"}
+		if err := s.publisher.Publish(ctx, event.New(ctx, event.RefreshTokenReused, 0, payload)); err != nil {
+			return TokenPair{}, fmt.Errorf("publish %s: %w", event.RefreshTokenReused, err)
+		}
+		return TokenPair{}, domain.ErrUnauthorized
+	}
+	if familyRevoked {
+		// The family was revoked because the account was disabled or its
+		// password changed after the token was issued.
+		return TokenPair{}, domain.ErrUnauthorized
+	}
+	return pair, nil
+}
+
+// Logout revokes the caller's access token and, when given, the family of
+// the refresh token. Credentials without a token ID, such as API keys, have
+// no session to end and yield domain.ErrInvalidInput.
+func (s *SessionService) Logout(ctx context.Context, principal auth.Principal, refreshToken string) error {
+	if principal.TokenID == "" {
+		return domain.ErrInvalidInput
+	}
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		if err := s.sessions.RevokeAccessToken(ctx, principal.TokenID, principal.ExpiresAt); err != nil {
+			return fmt.Errorf("revoke access token: %w", err)
+		}
+		if refreshToken == "" {
+			return nil
+		}
+		stored, err := s.sessions.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+		if err != nil {
+			return fmt.Errorf("load refresh token: %w", err)
+		}
+		if stored == nil || stored.AccountID != principal.AccountID {
+			return nil
+		}
+		return s.sessions.RevokeRefreshTokenFamily(ctx, stored.FamilyID, now)
+	})
+}
+
+// IsRevoked reports whether the access token with the given jti was revoked.
+func (s *SessionService) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	return s.sessions.IsAccessTokenRevoked(ctx, jti)
+}
+
+func (s *SessionService) issue(ctx context.Context, account domain.Account, familyID string) (TokenPair, error) {
+	role := auth.Role(account.Role)
+	access, err := s.issuer.Issue(auth.Principal{
+		Subject:   account.Username,
+		AccountID: account.ID,
+		Role:      role,
+		Scopes:    role.Scopes(),
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	secret, err := auth.RandomSecret(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	now := time.Now().UTC()
+	record := domain.RefreshToken{
+		AccountID: account.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(secret),
+		ExpiresAt: now.Add(s.refreshTTL),
+		CreatedAt: now,
+	}
+	if err := s.sessions.CreateRefreshToken(ctx, &record); err != nil {
+		return TokenPair{}, fmt.Errorf("store refresh token: %w", err)
+	}
+
+	return TokenPair{
+		AccessToken:      access.Token,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(s.issuer.TTL().Seconds()),
+		RefreshToken:     secret,
+		RefreshExpiresIn: int64(s.refreshTTL.Seconds()),
+	}, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
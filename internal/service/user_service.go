@@ -2,7 +2,10 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"reflect"
 	"strings"
 	"time"
 
@@ -14,13 +17,18 @@ import (
 type UserService struct {
 	users     repository.UserRepository
 	files     repository.FileRepository
+	tx        repository.Transactor
 	publisher event.Publisher
 }
 
-func NewUserService(users repository.UserRepository, files repository.FileRepository, publisher event.Publisher) *UserService {
+// NewUserService wires the service. Writes and their events are issued inside
+// one transaction, so a transactional publisher (such as the Postgres outbox)
+// records an event if and only if the change is committed.
+func NewUserService(users repository.UserRepository, files repository.FileRepository, tx repository.Transactor, publisher event.Publisher) *UserService {
 	return &UserService{
 		users:     users,
 		files:     files,
+		tx:        tx,
 		publisher: publisher,
 	}
 }
@@ -37,13 +45,99 @@ type UpdateUserInput struct {
 	Age   *int    `json:"age"`
 }
 
+// FileInput describes a file attached by reference. SHA256 is the hex
+// digest of the content, when known. StorageKey is set only by
+// FileContentService for uploaded content.
 type FileInput struct {
-	Name string `json:"name" binding:"required"`
-	Path string `json:"path" binding:"required"`
+	Name        string            `json:"name" binding:"required"`
+	Path        string            `json:"path" binding:"required"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type"`
+	SHA256      string            `json:"sha256"`
+	Labels      map[string]string `json:"labels"`
+	StorageKey  string            `json:"-"`
 }
 
-func (s *UserService) ListUsers(ctx context.Context) ([]domain.User, error) {
-	return s.users.List(ctx)
+// UpdateFileInput changes the given fields of a file. Labels, when present,
+// replace the existing set.
+type UpdateFileInput struct {
+	Name        *string           `json:"name"`
+	Path        *string           `json:"path"`
+	Size        *int64            `json:"size"`
+	ContentType *string           `json:"content_type"`
+	SHA256      *string           `json:"sha256"`
+	Labels      map[string]string `json:"labels"`
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// ListUsersInput carries the query string of GET /users. Sort is a field name,
+// optionally prefixed with "-" for descending order.
+type ListUsersInput struct {
+	Limit         int        `form:"limit"`
+	Cursor        string     `form:"cursor"`
+	Sort          string     `form:"sort"`
+	EmailPrefix   string     `form:"email_prefix"`
+	NameContains  string     `form:"name"`
+	MinAge        *int       `form:"min_age"`
+	MaxAge        *int       `form:"max_age"`
+	CreatedAfter  *time.Time `form:"created_after"`
+	CreatedBefore *time.Time `form:"created_before"`
+}
+
+func (s *UserService) ListUsers(ctx context.Context, input ListUsersInput) (repository.UserPage, error) {
+	return s.listUsers(ctx, input, false)
+}
+
+// ListDeletedUsers pages through soft-deleted users, accepting the same
+// parameters as ListUsers.
+func (s *UserService) ListDeletedUsers(ctx context.Context, input ListUsersInput) (repository.UserPage, error) {
+	return s.listUsers(ctx, input, true)
+}
+
+func (s *UserService) listUsers(ctx context.Context, input ListUsersInput, deleted bool) (repository.UserPage, error) {
+	query := repository.UserQuery{
+		Limit:         input.Limit,
+		Cursor:        input.Cursor,
+		SortField:     repository.SortByID,
+		EmailPrefix:   strings.TrimSpace(input.EmailPrefix),
+		NameContains:  strings.TrimSpace(input.NameContains),
+		MinAge:        input.MinAge,
+		MaxAge:        input.MaxAge,
+		CreatedAfter:  input.CreatedAfter,
+		CreatedBefore: input.CreatedBefore,
+		Deleted:       deleted,
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
+	if query.Limit < 0 || query.Limit > maxPageSize {
+		return repository.UserPage{}, domain.ErrInvalidInput
+	}
+	if sort := strings.TrimSpace(input.Sort); sort != "" {
+		query.SortDesc = strings.HasPrefix(sort, "-")
+		query.SortField = repository.UserSortField(strings.TrimPrefix(sort, "-"))
+		switch query.SortField {
+		case repository.SortByID, repository.SortByName, repository.SortByEmail, repository.SortByAge, repository.SortByCreatedAt:
+		default:
+			return repository.UserPage{}, domain.ErrInvalidInput
+		}
+	}
+	if query.MinAge != nil && query.MaxAge != nil && *query.MinAge > *query.MaxAge {
+		return repository.UserPage{}, domain.ErrInvalidInput
+	}
+
+	page, err := s.users.List(ctx, query)
+	if err != nil {
+		if err == domain.ErrInvalidInput {
+			return repository.UserPage{}, err
+		}
+		return repository.UserPage{}, fmt.Errorf("list users: %w", err)
+	}
+	return page, nil
 }
 
 func (s *UserService) GetUser(ctx context.Context, id uint) (domain.User, error) {
@@ -75,27 +169,33 @@ func (s *UserService) CreateUser(ctx context.Context, input CreateUserInput) (do
 		Email: strings.ToLower(strings.TrimSpace(input.Email)),
 		Age:   input.Age,
 	}
-	if err := s.users.Create(ctx, &user); err != nil {
-		return domain.User{}, fmt.Errorf("create user: %w", err)
-	}
-
-	evt := event.Event{
-		Type:       event.UserCreated,
-		UserID:     user.ID,
-		Payload:    user,
-		OccurredAt: time.Now().UTC(),
-	}
-	if err := s.publisher.Publish(ctx, evt); err != nil {
-		return domain.User{}, fmt.Errorf("publish user created: %w", err)
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Create(ctx, &user); err != nil {
+			return fmt.Errorf("create user: %w", err)
+		}
+		evt := event.New(ctx, event.UserCreated, user.ID, user)
+		if err := s.publisher.Publish(ctx, evt); err != nil {
+			return fmt.Errorf("publish user created: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.User{}, err
 	}
 	return user, nil
 }
 
-func (s *UserService) UpdateUser(ctx context.Context, id uint, input UpdateUserInput) (domain.User, error) {
+// UpdateUser applies input to the user. A non-zero version must equal the
+// user's current version, otherwise domain.ErrPreconditionFailed is returned.
+func (s *UserService) UpdateUser(ctx context.Context, id uint, version int64, input UpdateUserInput) (domain.User, error) {
 	user, err := s.users.GetByID(ctx, id)
 	if err != nil {
 		return domain.User{}, err
 	}
+	if version != 0 && user.Version != version {
+		return domain.User{}, domain.ErrPreconditionFailed
+	}
+	before := *user
 
 	if input.Name != nil {
 		user.Name = strings.TrimSpace(*input.Name)
@@ -121,35 +221,116 @@ func (s *UserService) UpdateUser(ctx context.Context, id uint, input UpdateUserI
 		user.Age = *input.Age
 	}
 
-	if err := s.users.Update(ctx, user); err != nil {
-		return domain.User{}, fmt.Errorf("update user: %w", err)
+	changes := diffUser(before, *user)
+	if len(changes) == 0 {
+		return *user, nil
 	}
 
-	evt := event.Event{
-		Type:       event.UserUpdated,
-		UserID:     user.ID,
-		Payload:    user,
-		OccurredAt: time.Now().UTC(),
-	}
-	if err := s.publisher.Publish(ctx, evt); err != nil {
-		return domain.User{}, fmt.Errorf("publish user updated: %w", err)
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Update(ctx, user); err != nil {
+			if err == domain.ErrPreconditionFailed || err == domain.ErrNotFound {
+				return err
+			}
+			return fmt.Errorf("update user: %w", err)
+		}
+		evt := event.New(ctx, event.UserUpdated, user.ID, event.UserChanges{User: *user, Changes: changes})
+		if err := s.publisher.Publish(ctx, evt); err != nil {
+			return fmt.Errorf("publish user updated: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.User{}, err
 	}
 	return *user, nil
 }
 
-func (s *UserService) DeleteUser(ctx context.Context, id uint) error {
-	if err := s.users.Delete(ctx, id); err != nil {
-		return err
-	}
-	evt := event.Event{
-		Type:       event.UserDeleted,
-		UserID:     id,
-		OccurredAt: time.Now().UTC(),
+// userFields are the user attributes whose changes UserUpdated reports,
+// keyed by their JSON name. Add new attributes here.
+var userFields = []struct {
+	name  string
+	value func(domain.User) interface{}
+}{
+	{"name", func(u domain.User) interface{} { return u.Name }},
+	{"email", func(u domain.User) interface{} { return u.Email }},
+	{"age", func(u domain.User) interface{} { return u.Age }},
+}
+
+func diffUser(before, after domain.User) map[string]event.FieldChange {
+	changes := map[string]event.FieldChange{}
+	for _, field := range userFields {
+		old, updated := field.value(before), field.value(after)
+		if !reflect.DeepEqual(old, updated) {
+			changes[field.name] = event.FieldChange{Before: old, After: updated}
+		}
 	}
-	if err := s.publisher.Publish(ctx, evt); err != nil {
-		return fmt.Errorf("publish user deleted: %w", err)
+	return changes
+}
+
+// DeleteUser removes the user. A non-zero version must equal the user's
+// current version, otherwise domain.ErrPreconditionFailed is returned.
+func (s *UserService) DeleteUser(ctx context.Context, id uint, version int64) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Delete(ctx, id, version); err != nil {
+			return err
+		}
+		evt := event.New(ctx, event.UserDeleted, id, nil)
+		if err := s.publisher.Publish(ctx, evt); err != nil {
+			return fmt.Errorf("publish user deleted: %w", err)
+		}
+		return nil
+	})
+}
+
+// RestoreUser undoes a soft delete. It fails with domain.ErrConflict when the
+// user's email has since been taken by another user.
+func (s *UserService) RestoreUser(ctx context.Context, id uint) (domain.User, error) {
+	var restored domain.User
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		user, err := s.users.GetDeletedByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		existing, err := s.users.GetByEmail(ctx, user.Email)
+		if err != nil {
+			return fmt.Errorf("check email: %w", err)
+		}
+		if existing != nil {
+			return domain.ErrConflict
+		}
+		if err := s.users.Restore(ctx, id); err != nil {
+			return err
+		}
+		current, err := s.users.GetByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("reload user: %w", err)
+		}
+		restored = *current
+		evt := event.New(ctx, event.UserRestored, id, restored)
+		if err := s.publisher.Publish(ctx, evt); err != nil {
+			return fmt.Errorf("publish user restored: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.User{}, err
 	}
-	return nil
+	return restored, nil
+}
+
+// PurgeUser permanently removes a user, live or soft-deleted, and its files.
+// A non-zero version must equal the user's current version.
+func (s *UserService) PurgeUser(ctx context.Context, id uint, version int64) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.users.Purge(ctx, id, version); err != nil {
+			return err
+		}
+		evt := event.New(ctx, event.UserPurged, id, nil)
+		if err := s.publisher.Publish(ctx, evt); err != nil {
+			return fmt.Errorf("publish user purged: %w", err)
+		}
+		return nil
+	})
 }
 
 func (s *UserService) ListFiles(ctx context.Context, userID uint) ([]domain.File, error) {
@@ -165,25 +346,147 @@ func (s *UserService) AddFile(ctx context.Context, userID uint, input FileInput)
 	}
 
 	file := domain.File{
-		UserID: userID,
-		Name:   strings.TrimSpace(input.Name),
-		Path:   strings.TrimSpace(input.Path),
+		UserID:      userID,
+		Name:        strings.TrimSpace(input.Name),
+		Path:        strings.TrimSpace(input.Path),
+		Size:        input.Size,
+		ContentType: strings.TrimSpace(input.ContentType),
+		SHA256:      strings.ToLower(strings.TrimSpace(input.SHA256)),
+		Labels:      input.Labels,
+		StorageKey:  input.StorageKey,
 	}
-	if file.Name == "" || file.Path == "" {
-		return domain.File{}, domain.ErrInvalidInput
+	if err := validateFile(file); err != nil {
+		return domain.File{}, err
 	}
 
-	if err := s.files.Add(ctx, &file); err != nil {
-		return domain.File{}, fmt.Errorf("add file: %w", err)
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.files.Add(ctx, &file); err != nil {
+			if err == domain.ErrConflict {
+				return err
+			}
+			return fmt.Errorf("add file: %w", err)
+		}
+		return s.publishFileEvent(ctx, event.FileAdded, userID, file)
+	})
+	if err != nil {
+		return domain.File{}, err
 	}
 	return file, nil
 }
 
+// GetFile returns one file of a live user. Files of soft-deleted users are
+// reported as not found, which also guards UpdateFile, DeleteFile and
+// content downloads.
+func (s *UserService) GetFile(ctx context.Context, userID, fileID uint) (domain.File, error) {
+	if _, err := s.users.GetByID(ctx, userID); err != nil {
+		return domain.File{}, err
+	}
+	file, err := s.files.GetFile(ctx, userID, fileID)
+	if err != nil {
+		if err == domain.ErrNotFound {
+			return domain.File{}, err
+		}
+		return domain.File{}, fmt.Errorf("get file: %w", err)
+	}
+	return *file, nil
+}
+
+func (s *UserService) UpdateFile(ctx context.Context, userID, fileID uint, input UpdateFileInput) (domain.File, error) {
+	file, err := s.GetFile(ctx, userID, fileID)
+	if err != nil {
+		return domain.File{}, err
+	}
+	if input.Name != nil {
+		file.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.Path != nil {
+		file.Path = strings.TrimSpace(*input.Path)
+	}
+	if input.Size != nil {
+		file.Size = *input.Size
+	}
+	if input.ContentType != nil {
+		file.ContentType = strings.TrimSpace(*input.ContentType)
+	}
+	if input.SHA256 != nil {
+		file.SHA256 = strings.ToLower(strings.TrimSpace(*input.SHA256))
+	}
+	if input.Labels != nil {
+		file.Labels = input.Labels
+	}
+	if err := validateFile(file); err != nil {
+		return domain.File{}, err
+	}
+
+	if err := s.files.UpdateFile(ctx, &file); err != nil {
+		if err == domain.ErrConflict || err == domain.ErrNotFound {
+			return domain.File{}, err
+		}
+		return domain.File{}, fmt.Errorf("update file: %w", err)
+	}
+	return file, nil
+}
+
+func (s *UserService) DeleteFile(ctx context.Context, userID, fileID uint) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		file, err := s.GetFile(ctx, userID, fileID)
+		if err != nil {
+			return err
+		}
+		if err := s.files.DeleteFile(ctx, userID, fileID); err != nil {
+			return err
+		}
+		return s.publishFileEvent(ctx, event.FileDeleted, userID, file)
+	})
+}
+
+// DeleteFiles removes all files of a user. UserFilesCleared lists the
+// removed files and is only published when there were any.
 func (s *UserService) DeleteFiles(ctx context.Context, userID uint) error {
 	if _, err := s.users.GetByID(ctx, userID); err != nil {
 		return err
 	}
-	return s.files.DeleteByUser(ctx, userID)
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		files, err := s.files.ListByUser(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("list files: %w", err)
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		if err := s.files.DeleteByUser(ctx, userID); err != nil {
+			return fmt.Errorf("delete files: %w", err)
+		}
+		return s.publishFileEvent(ctx, event.UserFilesCleared, userID, FilesCleared{Files: files})
+	})
+}
+
+// FilesCleared is the payload of UserFilesCleared.
+type FilesCleared = event.FilesCleared
+
+func (s *UserService) publishFileEvent(ctx context.Context, typ event.Type, userID uint, payload interface{}) error {
+	evt := event.New(ctx, typ, userID, payload)
+	if err := s.publisher.Publish(ctx, evt); err != nil {
+		return fmt.Errorf("publish %s: %w", typ, err)
+	}
+	return nil
+}
+
+func validateFile(file domain.File) error {
+	if file.Name == "" || file.Path == "" || file.Size < 0 {
+		return domain.ErrInvalidInput
+	}
+	if file.SHA256 != "" {
+		if _, err := hex.DecodeString(file.SHA256); err != nil || len(file.SHA256) != sha256.Size*2 {
+			return domain.ErrInvalidInput
+		}
+	}
+	for key := range file.Labels {
+		if strings.TrimSpace(key) == "" {
+			return domain.ErrInvalidInput
+		}
+	}
+	return nil
 }
 
 func validateUserInput(name, email string, age int) error {
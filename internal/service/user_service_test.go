@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -53,7 +55,7 @@ func TestDeleteUser_PublishesEvent(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	err = svc.DeleteUser(ctx, user.ID)
+	err = svc.DeleteUser(ctx, user.ID, 0)
 	require.NoError(t, err)
 
 	events := publisher.Events()
@@ -80,12 +82,119 @@ func TestUpdateUser_RejectsDuplicateEmail(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	_, err = svc.UpdateUser(ctx, second.ID, UpdateUserInput{
+	_, err = svc.UpdateUser(ctx, second.ID, 0, UpdateUserInput{
 		Email: &first.Email,
 	})
 	require.ErrorIs(t, err, domain.ErrConflict)
 }
 
+func TestUpdateUser_RejectsStaleVersion(t *testing.T) {
+	svc, _, publisher := setupService(t)
+	ctx := context.Background()
+
+	user, err := svc.CreateUser(ctx, CreateUserInput{
+		Name:  "Versioned",
+		Email: "versioned@example.com",
+		Age:   30,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), user.Version)
+
+	age := 31
+	updated, err := svc.UpdateUser(ctx, user.ID, user.Version, UpdateUserInput{Age: &age})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), updated.Version)
+
+	_, err = svc.UpdateUser(ctx, user.ID, user.Version, UpdateUserInput{Age: &age})
+	require.ErrorIs(t, err, domain.ErrPreconditionFailed)
+	require.ErrorIs(t, svc.DeleteUser(ctx, user.ID, user.Version), domain.ErrPreconditionFailed)
+	require.NoError(t, svc.DeleteUser(ctx, user.ID, updated.Version))
+
+	require.Len(t, publisher.Events(), 3) // create + update + delete
+}
+
+func TestUpdateUser_PublishesChangedFieldsOnly(t *testing.T) {
+	svc, _, publisher := setupService(t)
+	ctx := context.Background()
+
+	user, err := svc.CreateUser(ctx, CreateUserInput{Name: "Diff", Email: "diff@example.com", Age: 30})
+	require.NoError(t, err)
+
+	name, email, age := "Diffed", "DIFF@example.com", 31
+	updated, err := svc.UpdateUser(ctx, user.ID, 0, UpdateUserInput{Name: &name, Email: &email, Age: &age})
+	require.NoError(t, err)
+
+	events := publisher.Events()
+	require.Len(t, events, 2)
+	require.Equal(t, 2, events[1].Version)
+	payload := events[1].Payload.(event.UserChanges)
+	require.Equal(t, updated.Version, payload.Version)
+	require.Equal(t, map[string]event.FieldChange{
+		"name": {Before: "Diff", After: "Diffed"},
+		"age":  {Before: 30, After: 31},
+	}, payload.Changes, "the email only differs in case and is stored unchanged")
+
+	// Re-sending the current values is a no-op: no write, no event.
+	same, err := svc.UpdateUser(ctx, user.ID, updated.Version, UpdateUserInput{Name: &name, Age: &age})
+	require.NoError(t, err)
+	require.Equal(t, updated.Version, same.Version)
+	require.Len(t, publisher.Events(), 2)
+}
+
+func TestDeletedUsers_CanBeRestoredOrPurged(t *testing.T) {
+	svc, _, publisher := setupService(t)
+	ctx := context.Background()
+
+	user, err := svc.CreateUser(ctx, CreateUserInput{
+		Name:  "Trash",
+		Email: "trash@example.com",
+		Age:   30,
+	})
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteUser(ctx, user.ID, 0))
+
+	trash, err := svc.ListDeletedUsers(ctx, ListUsersInput{})
+	require.NoError(t, err)
+	require.Len(t, trash.Users, 1)
+	require.NotNil(t, trash.Users[0].DeletedAt)
+
+	// The address is free again while the old user sits in the trash, so
+	// restoring it would clash.
+	replacement, err := svc.CreateUser(ctx, CreateUserInput{
+		Name:  "Replacement",
+		Email: "trash@example.com",
+		Age:   31,
+	})
+	require.NoError(t, err)
+	_, err = svc.RestoreUser(ctx, user.ID)
+	require.ErrorIs(t, err, domain.ErrConflict)
+
+	require.NoError(t, svc.PurgeUser(ctx, replacement.ID, replacement.Version))
+	restored, err := svc.RestoreUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Nil(t, restored.DeletedAt)
+	require.Equal(t, user.Version+1, restored.Version)
+
+	_, err = svc.RestoreUser(ctx, user.ID)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+
+	require.NoError(t, svc.PurgeUser(ctx, user.ID, 0))
+	_, err = svc.GetUser(ctx, user.ID)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+	trash, err = svc.ListDeletedUsers(ctx, ListUsersInput{})
+	require.NoError(t, err)
+	require.Empty(t, trash.Users)
+
+	var types []event.Type
+	for _, evt := range publisher.Events() {
+		types = append(types, evt.Type)
+	}
+	require.Equal(t, []event.Type{
+		event.UserCreated, event.UserDeleted, event.UserCreated,
+		event.UserPurged, event.UserRestored, event.UserPurged,
+	}, types)
+}
+
 func TestAddFile_ValidatesInput(t *testing.T) {
 	svc, _, _ := setupService(t)
 	ctx := context.Background()
@@ -104,10 +213,101 @@ func TestAddFile_ValidatesInput(t *testing.T) {
 	require.ErrorIs(t, err, domain.ErrInvalidInput)
 }
 
-func TestDeleteFiles_RemovesAll(t *testing.T) {
+func TestFiles_MetadataAndSingleFileOperations(t *testing.T) {
+	svc, _, publisher := setupService(t)
+	ctx := context.Background()
+
+	user, err := svc.CreateUser(ctx, CreateUserInput{
+		Name:  "Files",
+		Email: "files3@example.com",
+		Age:   29,
+	})
+	require.NoError(t, err)
+
+	file, err := svc.AddFile(ctx, user.ID, FileInput{
+		Name:        "passport",
+		Path:        "/docs/passport.pdf",
+		Size:        2048,
+		ContentType: "application/pdf",
+		SHA256:      strings.Repeat("AB", 32),
+		Labels:      map[string]string{"kind": "id"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("ab", 32), file.SHA256)
+
+	_, err = svc.AddFile(ctx, user.ID, FileInput{Name: "copy", Path: "/docs/passport.pdf"})
+	require.ErrorIs(t, err, domain.ErrConflict)
+	_, err = svc.AddFile(ctx, user.ID, FileInput{Name: "bad", Path: "/docs/bad.pdf", SHA256: "not-hex"})
+	require.ErrorIs(t, err, domain.ErrInvalidInput)
+
+	other, err := svc.AddFile(ctx, user.ID, FileInput{Name: "visa", Path: "/docs/visa.pdf"})
+	require.NoError(t, err)
+	path := "/docs/passport.pdf"
+	_, err = svc.UpdateFile(ctx, user.ID, other.ID, UpdateFileInput{Path: &path})
+	require.ErrorIs(t, err, domain.ErrConflict)
+
+	name := "passport-2026"
+	updated, err := svc.UpdateFile(ctx, user.ID, file.ID, UpdateFileInput{Name: &name, Labels: map[string]string{"kind": "id", "year": "2026"}})
+	require.NoError(t, err)
+	require.True(t, !updated.UpdatedAt.Before(file.UpdatedAt))
+
+	fetched, err := svc.GetFile(ctx, user.ID, file.ID)
+	require.NoError(t, err)
+	require.Equal(t, "passport-2026", fetched.Name)
+	require.Equal(t, int64(2048), fetched.Size)
+	require.Equal(t, "2026", fetched.Labels["year"])
+
+	require.NoError(t, svc.DeleteFile(ctx, user.ID, file.ID))
+	_, err = svc.GetFile(ctx, user.ID, file.ID)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+
+	// The path is free again once the file is gone.
+	_, err = svc.AddFile(ctx, user.ID, FileInput{Name: "passport", Path: "/docs/passport.pdf"})
+	require.NoError(t, err)
+
+	// Rejected files publish nothing; updates are not file events.
+	var types []event.Type
+	for _, evt := range publisher.Events() {
+		types = append(types, evt.Type)
+	}
+	require.Equal(t, []event.Type{
+		event.UserCreated, event.FileAdded, event.FileAdded,
+		event.FileDeleted, event.FileAdded,
+	}, types)
+	deleted := publisher.Events()[3]
+	require.Equal(t, user.ID, deleted.UserID)
+	require.Equal(t, file.ID, deleted.Payload.(domain.File).ID)
+	require.Equal(t, "passport-2026", deleted.Payload.(domain.File).Name)
+}
+
+func TestFiles_HiddenWhileUserIsDeleted(t *testing.T) {
 	svc, _, _ := setupService(t)
 	ctx := context.Background()
 
+	user, err := svc.CreateUser(ctx, CreateUserInput{Name: "Gone", Email: "gone@example.com", Age: 40})
+	require.NoError(t, err)
+	file, err := svc.AddFile(ctx, user.ID, FileInput{Name: "notes.txt", Path: "/docs/notes.txt", Size: 3})
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteUser(ctx, user.ID, 0))
+
+	_, err = svc.GetFile(ctx, user.ID, file.ID)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+	name := "renamed.txt"
+	_, err = svc.UpdateFile(ctx, user.ID, file.ID, UpdateFileInput{Name: &name})
+	require.ErrorIs(t, err, domain.ErrNotFound)
+	require.ErrorIs(t, svc.DeleteFile(ctx, user.ID, file.ID), domain.ErrNotFound)
+
+	_, err = svc.RestoreUser(ctx, user.ID)
+	require.NoError(t, err)
+	restored, err := svc.GetFile(ctx, user.ID, file.ID)
+	require.NoError(t, err)
+	require.Equal(t, "notes.txt", restored.Name)
+}
+
+func TestDeleteFiles_RemovesAll(t *testing.T) {
+	svc, _, publisher := setupService(t)
+	ctx := context.Background()
+
 	user, err := svc.CreateUser(ctx, CreateUserInput{
 		Name:  "Files",
 		Email: "files2@example.com",
@@ -127,6 +327,214 @@ func TestDeleteFiles_RemovesAll(t *testing.T) {
 	files, err := svc.ListFiles(ctx, user.ID)
 	require.NoError(t, err)
 	require.Len(t, files, 0)
+
+	// Clearing an empty set is not an event.
+	require.NoError(t, svc.DeleteFiles(ctx, user.ID))
+
+	events := publisher.Events()
+	require.Len(t, events, 3) // create + file added + cleared
+	require.Equal(t, event.FileAdded, events[1].Type)
+	require.Equal(t, "/tmp/doc.pdf", events[1].Payload.(domain.File).Path)
+	require.Equal(t, event.UserFilesCleared, events[2].Type)
+	cleared := events[2].Payload.(FilesCleared)
+	require.Len(t, cleared.Files, 1)
+	require.Equal(t, "/tmp/doc.pdf", cleared.Files[0].Path)
+}
+
+func TestListUsers_PaginatesWithCursor(t *testing.T) {
+	svc, _, _ := setupService(t)
+	ctx := context.Background()
+
+	for i, name := range []string{"Carol", "Alice", "Dave", "Bob", "Eve"} {
+		_, err := svc.CreateUser(ctx, CreateUserInput{
+			Name:  name,
+			Email: strings.ToLower(name) + "@example.com",
+			Age:   20 + i,
+		})
+		require.NoError(t, err)
+	}
+
+	var names []string
+	input := ListUsersInput{Limit: 2, Sort: "name"}
+	for {
+		page, err := svc.ListUsers(ctx, input)
+		require.NoError(t, err)
+		require.EqualValues(t, 5, page.Total)
+		for _, u := range page.Users {
+			names = append(names, u.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		input.Cursor = page.NextCursor
+	}
+	require.Equal(t, []string{"Alice", "Bob", "Carol", "Dave", "Eve"}, names)
+
+	_, err := svc.ListUsers(ctx, ListUsersInput{Limit: 2, Sort: "-name", Cursor: input.Cursor})
+	require.ErrorIs(t, err, domain.ErrInvalidInput, "cursor is bound to its sort order")
+}
+
+func TestListUsers_Filters(t *testing.T) {
+	svc, _, _ := setupService(t)
+	ctx := context.Background()
+
+	for _, in := range []CreateUserInput{
+		{Name: "Anna Smith", Email: "anna@corp.example", Age: 25},
+		{Name: "Annabel Jones", Email: "annabel@other.example", Age: 40},
+		{Name: "John Smith", Email: "john@corp.example", Age: 55},
+	} {
+		_, err := svc.CreateUser(ctx, in)
+		require.NoError(t, err)
+	}
+
+	page, err := svc.ListUsers(ctx, ListUsersInput{EmailPrefix: "ANNA"})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, page.Total)
+
+	page, err = svc.ListUsers(ctx, ListUsersInput{NameContains: "smith", Sort: "-age"})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 2)
+	require.Equal(t, "John Smith", page.Users[0].Name)
+
+	minAge, maxAge := 30, 50
+	page, err = svc.ListUsers(ctx, ListUsersInput{MinAge: &minAge, MaxAge: &maxAge})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	require.Equal(t, "Annabel Jones", page.Users[0].Name)
+
+	_, err = svc.ListUsers(ctx, ListUsersInput{Sort: "password"})
+	require.ErrorIs(t, err, domain.ErrInvalidInput)
+}
+
+func TestCreateUser_RollsBackWhenEventIsNotRecorded(t *testing.T) {
+	svc, repo, _ := setupService(t)
+	ctx := context.Background()
+
+	failing := NewUserService(repo, repo, repo, failingPublisher{})
+	_, err := failing.CreateUser(ctx, CreateUserInput{
+		Name:  "Ghost",
+		Email: "ghost@example.com",
+		Age:   40,
+	})
+	require.Error(t, err)
+
+	page, err := svc.ListUsers(ctx, ListUsersInput{})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 0)
+}
+
+func TestOutboxRelay_DeliversCommittedEvents(t *testing.T) {
+	_, repo, _ := setupService(t)
+	ctx := context.Background()
+
+	outbox := postgresstorage.NewOutbox(repo)
+	svc := NewUserService(repo, repo, repo, outbox)
+
+	user, err := svc.CreateUser(ctx, CreateUserInput{
+		Name:  "Relay",
+		Email: "relay@example.com",
+		Age:   33,
+	})
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteUser(ctx, user.ID, 0))
+
+	broker := event.NewInMemoryPublisher()
+	relay := event.NewOutboxRelay(outbox, broker, event.RelayConfig{BatchSize: 10}, nil)
+
+	delivered, err := relay.Flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, delivered)
+
+	events := broker.Events()
+	require.Len(t, events, 2)
+	require.Equal(t, event.UserCreated, events[0].Type)
+	require.Equal(t, event.UserDeleted, events[1].Type)
+	require.Equal(t, user.ID, events[1].UserID)
+
+	delivered, err = relay.Flush(ctx)
+	require.NoError(t, err)
+	require.Zero(t, delivered)
+}
+
+func TestOutboxRelay_ReschedulesFailedDelivery(t *testing.T) {
+	_, repo, _ := setupService(t)
+	ctx := context.Background()
+
+	outbox := postgresstorage.NewOutbox(repo)
+	svc := NewUserService(repo, repo, repo, outbox)
+
+	_, err := svc.CreateUser(ctx, CreateUserInput{
+		Name:  "Retry",
+		Email: "retry@example.com",
+		Age:   33,
+	})
+	require.NoError(t, err)
+
+	relay := event.NewOutboxRelay(outbox, failingPublisher{}, event.RelayConfig{BatchSize: 10}, nil)
+	delivered, err := relay.Flush(ctx)
+	require.NoError(t, err)
+	require.Zero(t, delivered)
+
+	pending, err := outbox.Pending(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, pending, "failed message must wait for its backoff")
+}
+
+func TestDeduplicator_PostgresStoreSkipsRelayedDuplicates(t *testing.T) {
+	_, repo, _ := setupService(t)
+	ctx := context.Background()
+
+	outbox := postgresstorage.NewOutbox(repo)
+	svc := NewUserService(repo, repo, repo, outbox)
+	_, err := svc.CreateUser(ctx, CreateUserInput{Name: "Dedup", Email: "dedup@example.com", Age: 33})
+	require.NoError(t, err)
+	broker := event.NewInMemoryPublisher()
+	_, err = event.NewOutboxRelay(outbox, broker, event.RelayConfig{BatchSize: 10}, nil).Flush(ctx)
+	require.NoError(t, err)
+	created := broker.Events()[0]
+
+	handled := 0
+	dedup := event.NewDeduplicator(postgresstorage.NewProcessedEvents(repo), time.Hour, time.Minute, nil)
+	handler := dedup.Wrap(func(context.Context, event.Event) error {
+		handled++
+		return nil
+	})
+	require.NoError(t, handler(ctx, created))
+	// A second consumer process sharing the table sees it as processed too.
+	other := event.NewDeduplicator(postgresstorage.NewProcessedEvents(repo), time.Hour, time.Minute, nil).Wrap(func(context.Context, event.Event) error {
+		handled++
+		return nil
+	})
+	require.NoError(t, other(ctx, created))
+	require.Equal(t, 1, handled)
+
+	store := postgresstorage.NewProcessedEvents(repo)
+	claim := func(id string, expiresAt time.Time) bool {
+		claimed, err := store.Claim(ctx, id, expiresAt)
+		require.NoError(t, err)
+		return claimed
+	}
+	require.True(t, claim("old", time.Now().Add(-time.Minute)))
+	require.True(t, claim("old", time.Now().Add(time.Hour)), "expired claims can be taken over")
+	require.False(t, claim("old", time.Now().Add(time.Hour)))
+	require.NoError(t, store.Release(ctx, "old"))
+	require.True(t, claim("old", time.Now().Add(time.Hour)))
+
+	// Completing outlasts a lease that ran out while the handler ran.
+	require.True(t, claim("slow", time.Now().Add(-time.Minute)))
+	require.NoError(t, store.Complete(ctx, "slow", time.Now().Add(time.Hour)))
+	require.False(t, claim("slow", time.Now().Add(time.Hour)))
+
+	require.True(t, claim("stale", time.Now().Add(-time.Minute)))
+	deleted, err := store.DeleteExpired(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+}
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, event.Event) error {
+	return errors.New("broker unavailable")
 }
 
 func setupService(t *testing.T) (*UserService, *postgresstorage.Repository, *event.InMemoryPublisher) {
@@ -142,6 +550,6 @@ func setupService(t *testing.T) (*UserService, *postgresstorage.Repository, *eve
 	})
 
 	publisher := event.NewInMemoryPublisher()
-	svc := NewUserService(repo, repo, publisher)
+	svc := NewUserService(repo, repo, repo, publisher)
 	return svc, repo, publisher
 }
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/repository"
+	"github.com/vele/temp_test_repo/internal/webhook"
+)
+
+// webhookSecretPrefix marks webhook signing secrets.
+const webhookSecretPrefix = "whsec_"
+
+// WebhookService manages webhook subscriptions and their delivery log. The
+// deliveries themselves are made by webhook.Dispatcher in cmd/consumer.
+type WebhookService struct {
+	webhooks repository.WebhookRepository
+}
+
+func NewWebhookService(webhooks repository.WebhookRepository) *WebhookService {
+	return &WebhookService{webhooks: webhooks}
+}
+
+// CreateWebhookInput describes a new subscription. EventTypes lists user
+// and file event types; empty subscribes to all of them.
+type CreateWebhookInput struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"event_types"`
+}
+
+// UpdateWebhookInput changes the fields that are set.
+type UpdateWebhookInput struct {
+	URL        *string   `json:"url"`
+	EventTypes *[]string `json:"event_types"`
+	Active     *bool     `json:"active"`
+}
+
+// CreatedWebhook carries the signing secret, which is only available once.
+type CreatedWebhook struct {
+	domain.Webhook
+	Secret string `json:"secret"`
+}
+
+// DeliveryQuery pages through a webhook's deliveries, newest first.
+// Continue with BeforeID set to the last ID returned.
+type DeliveryQuery struct {
+	Status   string `form:"status"`
+	BeforeID uint   `form:"before_id"`
+	Limit    int    `form:"limit"`
+}
+
+func (s *WebhookService) ListWebhooks(ctx context.Context) ([]domain.Webhook, error) {
+	return s.webhooks.ListWebhooks(ctx)
+}
+
+func (s *WebhookService) GetWebhook(ctx context.Context, id uint) (*domain.Webhook, error) {
+	return s.webhooks.GetWebhookByID(ctx, id)
+}
+
+func (s *WebhookService) CreateWebhook(ctx context.Context, input CreateWebhookInput) (CreatedWebhook, error) {
+	if err := validateWebhook(input.URL, input.EventTypes); err != nil {
+		return CreatedWebhook{}, err
+	}
+	secret, err := auth.RandomSecret(32)
+	if err != nil {
+		return CreatedWebhook{}, fmt.Errorf("generate webhook secret: %w", err)
+	}
+	now := time.Now().UTC()
+	hook := domain.Webhook{
+		URL:        input.URL,
+		EventTypes: input.EventTypes,
+		Secret:     webhookSecretPrefix + secret,
+		Active:     true,
+		CreatedBy:  actorFromContext(ctx),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.webhooks.CreateWebhook(ctx, &hook); err != nil {
+		return CreatedWebhook{}, fmt.Errorf("create webhook: %w", err)
+	}
+	return CreatedWebhook{Webhook: hook, Secret: hook.Secret}, nil
+}
+
+// UpdateWebhook changes a subscription. Deliveries already recorded keep
+// going to the new URL; a deactivated webhook keeps its pending deliveries
+// until it is activated again.
+func (s *WebhookService) UpdateWebhook(ctx context.Context, id uint, input UpdateWebhookInput) (*domain.Webhook, error) {
+	hook, err := s.webhooks.GetWebhookByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if input.URL != nil {
+		hook.URL = *input.URL
+	}
+	if input.EventTypes != nil {
+		hook.EventTypes = *input.EventTypes
+	}
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+	if err := validateWebhook(hook.URL, hook.EventTypes); err != nil {
+		return nil, err
+	}
+	hook.UpdatedAt = time.Now().UTC()
+	if err := s.webhooks.UpdateWebhook(ctx, hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// DeleteWebhook removes the subscription along with its delivery log.
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id uint) error {
+	return s.webhooks.DeleteWebhook(ctx, id)
+}
+
+func (s *WebhookService) ListDeliveries(ctx context.Context, webhookID uint, query DeliveryQuery) ([]domain.WebhookDelivery, error) {
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
+	if query.Limit < 0 || query.Limit > maxPageSize {
+		return nil, domain.ErrInvalidInput
+	}
+	switch query.Status {
+	case "", domain.DeliveryPending, domain.DeliverySucceeded, domain.DeliveryFailed:
+	default:
+		return nil, domain.ErrInvalidInput
+	}
+	if _, err := s.webhooks.GetWebhookByID(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	return s.webhooks.ListDeliveries(ctx, repository.DeliveryFilter{
+		WebhookID: webhookID,
+		Status:    query.Status,
+		BeforeID:  query.BeforeID,
+		Limit:     query.Limit,
+	})
+}
+
+// Redeliver schedules a failed delivery for an immediate attempt with a
+// fresh set of retries. Deliveries that are pending or succeeded yield
+// domain.ErrConflict.
+func (s *WebhookService) Redeliver(ctx context.Context, webhookID, deliveryID uint) (*domain.WebhookDelivery, error) {
+	if err := s.webhooks.RescheduleDelivery(ctx, webhookID, deliveryID); err != nil {
+		return nil, err
+	}
+	return s.webhooks.GetDelivery(ctx, webhookID, deliveryID)
+}
+
+func validateWebhook(rawURL string, types []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return domain.ErrInvalidInput
+	}
+	for _, t := range types {
+		if !webhook.Deliverable(event.Type(t)) {
+			return domain.ErrInvalidInput
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+	postgresstorage "github.com/vele/temp_test_repo/internal/storage/postgres"
+	"github.com/vele/temp_test_repo/internal/webhook"
+)
+
+func TestWebhookService_ValidatesSubscriptions(t *testing.T) {
+	_, repo, _ := setupService(t)
+	ctx := context.Background()
+	svc := NewWebhookService(repo)
+
+	for _, input := range []CreateWebhookInput{
+		{URL: "ftp://example.com/hook"},
+		{URL: "/relative"},
+		{URL: "https://example.com/hook", EventTypes: []string{"LoginFailed"}},
+		{URL: "https://example.com/hook", EventTypes: []string{"Bogus"}},
+	} {
+		_, err := svc.CreateWebhook(ctx, input)
+		require.ErrorIs(t, err, domain.ErrInvalidInput, input)
+	}
+
+	created, err := svc.CreateWebhook(ctx, CreateWebhookInput{URL: "https://example.com/hook", EventTypes: []string{"UserCreated"}})
+	require.NoError(t, err)
+	require.True(t, created.Active)
+	require.Regexp(t, `^whsec_`, created.Secret)
+
+	inactive := false
+	types := []string{"FileAdded", "FileDeleted"}
+	updated, err := svc.UpdateWebhook(ctx, created.ID, UpdateWebhookInput{EventTypes: &types, Active: &inactive})
+	require.NoError(t, err)
+	require.Equal(t, types, updated.EventTypes)
+	require.False(t, updated.Active)
+	require.Equal(t, created.Secret, updated.Secret, "updates keep the secret")
+
+	require.NoError(t, svc.DeleteWebhook(ctx, created.ID))
+	_, err = svc.GetWebhook(ctx, created.ID)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func TestWebhookDispatcher_LogsAndRedeliversFailedDeliveries(t *testing.T) {
+	_, repo, _ := setupService(t)
+	ctx := context.Background()
+	var fail atomic.Bool
+	fail.Store(true)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	svc := NewWebhookService(repo)
+	all, err := svc.CreateWebhook(ctx, CreateWebhookInput{URL: receiver.URL})
+	require.NoError(t, err)
+	files, err := svc.CreateWebhook(ctx, CreateWebhookInput{URL: receiver.URL, EventTypes: []string{"FileAdded"}})
+	require.NoError(t, err)
+
+	dispatcher := webhook.NewDispatcher(postgresstorage.NewWebhookDeliveries(repo), receiver.Client(), webhook.Config{MaxAttempts: 1}, nil)
+	registry := event.NewRegistry()
+	dispatcher.Register(registry)
+	evt := event.New(ctx, event.UserCreated, 1, domain.User{ID: 1})
+	require.NoError(t, registry.Dispatch(ctx, evt))
+	require.NoError(t, registry.Dispatch(ctx, evt))
+
+	attempted, err := dispatcher.Flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, attempted)
+
+	failed, err := svc.ListDeliveries(ctx, all.ID, DeliveryQuery{Status: domain.DeliveryFailed})
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	require.Equal(t, evt.ID, failed[0].EventID)
+	require.Equal(t, http.StatusInternalServerError, failed[0].ResponseStatus)
+	none, err := svc.ListDeliveries(ctx, files.ID, DeliveryQuery{})
+	require.NoError(t, err)
+	require.Empty(t, none)
+
+	fail.Store(false)
+	pending, err := svc.Redeliver(ctx, all.ID, failed[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, domain.DeliveryPending, pending.Status)
+	require.Zero(t, pending.Attempts)
+	_, err = svc.Redeliver(ctx, all.ID, failed[0].ID)
+	require.ErrorIs(t, err, domain.ErrConflict, "only failed deliveries are redelivered")
+	_, err = svc.Redeliver(ctx, files.ID, failed[0].ID)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+
+	attempted, err = dispatcher.Flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, attempted)
+	delivered, err := svc.ListDeliveries(ctx, all.ID, DeliveryQuery{})
+	require.NoError(t, err)
+	require.Equal(t, domain.DeliverySucceeded, delivered[0].Status)
+	require.NotNil(t, delivered[0].DeliveredAt)
+}
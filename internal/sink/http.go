@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// HTTPSink POSTs every event as JSON to a single URL. Server errors, 408 and
+// 429 responses and network failures are retried by the consumer; other
+// client errors are permanent and dead-letter the event.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink returns a sink posting to url. The consumer holds the event
+// for as long as a request takes, so client should carry a timeout.
+func NewHTTPSink(url string, client *http.Client) *HTTPSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPSink{url: url, client: client}
+}
+
+func (s *HTTPSink) Register(r *event.Registry) {
+	r.HandleAll(s.post)
+}
+
+func (s *HTTPSink) Close() error { return nil }
+
+func (s *HTTPSink) post(ctx context.Context, evt event.Event) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return event.Permanent(fmt.Errorf("marshal event: %w", err))
+	}
+	header := http.Header{}
+	header.Set("X-Event-ID", evt.ID)
+	header.Set("X-Event-Type", string(evt.Type))
+	_, err = PostJSON(ctx, s.client, s.url, body, header)
+	return err
+}
+
+// PostJSON posts body to url with the given headers and returns the
+// response status, or 0 if there was no response. A 2xx status yields nil.
+// Server errors, 408 and 429 responses and network failures yield plain
+// errors, worth retrying; any other response is event.Permanent.
+func PostJSON(ctx context.Context, client *http.Client, url string, body []byte, header http.Header) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, event.Permanent(fmt.Errorf("build request: %w", err))
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.StatusCode, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	default:
+		return resp.StatusCode, event.Permanent(fmt.Errorf("endpoint responded %s", resp.Status))
+	}
+}
@@ -0,0 +1,52 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// JSONLSink appends every event as one JSON line to a file, e.g. for
+// archiving or feeding batch jobs. A retried or replayed event may appear
+// more than once; its ID tells the copies apart.
+type JSONLSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewJSONLSink opens path for appending, creating it if needed.
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open jsonl sink: %w", err)
+	}
+	return &JSONLSink{file: file}, nil
+}
+
+func (s *JSONLSink) Register(r *event.Registry) {
+	r.HandleAll(s.write)
+}
+
+func (s *JSONLSink) Close() error {
+	return s.file.Close()
+}
+
+func (s *JSONLSink) write(_ context.Context, evt event.Event) error {
+	line, err := json.Marshal(evt)
+	if err != nil {
+		return event.Permanent(fmt.Errorf("marshal event: %w", err))
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A single write keeps lines whole even with other writers appending.
+	if _, err := s.file.Write(line); err != nil {
+		return fmt.Errorf("write jsonl sink: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// LogSink writes a structured log entry per event.
+type LogSink struct {
+	log *logrus.Logger
+}
+
+func NewLogSink(log *logrus.Logger) *LogSink {
+	if log == nil {
+		log = logrus.New()
+	}
+	return &LogSink{log: log}
+}
+
+func (s *LogSink) Register(r *event.Registry) {
+	r.HandleAll(s.logEvent)
+}
+
+func (s *LogSink) Close() error { return nil }
+
+// logEvent logs an event, adding the file metadata of file events. Events
+// whose payload cannot be decoded are rejected as permanent failures.
+func (s *LogSink) logEvent(_ context.Context, evt event.Event) error {
+	entry := s.log.WithFields(logrus.Fields{
+		"eventID":       evt.ID,
+		"type":          evt.Type,
+		"version":       evt.Version,
+		"userID":        evt.UserID,
+		"correlationID": evt.CorrelationID,
+	})
+	payload, err := evt.TypedPayload()
+	if err != nil {
+		return event.Permanent(err)
+	}
+	switch payload := payload.(type) {
+	case *domain.File:
+		file := payload
+		entry = entry.WithFields(logrus.Fields{
+			"fileID":      file.ID,
+			"path":        file.Path,
+			"size":        file.Size,
+			"contentType": file.ContentType,
+			"sha256":      file.SHA256,
+		})
+	case *event.FilesCleared:
+		cleared := payload
+		paths := make([]string, len(cleared.Files))
+		for i, file := range cleared.Files {
+			paths[i] = file.Path
+		}
+		entry = entry.WithFields(logrus.Fields{
+			"files": len(cleared.Files),
+			"paths": paths,
+		})
+	}
+	entry.Info("event received")
+	return nil
+}
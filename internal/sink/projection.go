@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// ProjectionStore keeps a read model of users and their files built from
+// events. Replays and retries deliver events again and out of order, e.g. a
+// retried UserUpdated after UserPurged, so every method must be safe to
+// apply more than once and in any order. Removals therefore leave
+// tombstones that later puts respect.
+type ProjectionStore interface {
+	// UpsertUser stores user unless a newer version is already stored or
+	// the user was purged.
+	UpsertUser(ctx context.Context, user domain.User) error
+	MarkUserDeleted(ctx context.Context, userID uint, deletedAt time.Time) error
+	// RemoveUser purges the user and its files for good.
+	RemoveUser(ctx context.Context, userID uint) error
+	// PutFile stores file unless it was removed or its user purged.
+	PutFile(ctx context.Context, file domain.File) error
+	// RemoveFile removes the file for good, even if it is put afterwards.
+	RemoveFile(ctx context.Context, userID, fileID uint) error
+	RemoveFiles(ctx context.Context, userID uint) error
+}
+
+// ProjectionSink maintains a ProjectionStore from user and file events.
+type ProjectionSink struct {
+	store ProjectionStore
+}
+
+func NewProjectionSink(store ProjectionStore) *ProjectionSink {
+	return &ProjectionSink{store: store}
+}
+
+func (s *ProjectionSink) Register(r *event.Registry) {
+	r.Handle(s.upsertUser, event.UserCreated, event.UserUpdated, event.UserRestored)
+	r.Handle(s.deleteUser, event.UserDeleted)
+	r.Handle(s.purgeUser, event.UserPurged)
+	r.Handle(s.putFile, event.FileAdded)
+	r.Handle(s.removeFile, event.FileDeleted)
+	r.Handle(s.clearFiles, event.UserFilesCleared)
+}
+
+func (s *ProjectionSink) Close() error { return nil }
+
+func (s *ProjectionSink) upsertUser(ctx context.Context, evt event.Event) error {
+	payload, err := evt.TypedPayload()
+	if err != nil {
+		return event.Permanent(err)
+	}
+	var user domain.User
+	switch payload := payload.(type) {
+	case *domain.User:
+		user = *payload
+	case *event.UserChanges:
+		user = payload.User
+	default:
+		return event.Permanent(fmt.Errorf("unexpected %s payload %T", evt.Type, payload))
+	}
+	if user.ID == 0 {
+		user.ID = evt.UserID
+	}
+	return s.store.UpsertUser(ctx, user)
+}
+
+func (s *ProjectionSink) deleteUser(ctx context.Context, evt event.Event) error {
+	return s.store.MarkUserDeleted(ctx, evt.UserID, evt.OccurredAt)
+}
+
+func (s *ProjectionSink) purgeUser(ctx context.Context, evt event.Event) error {
+	return s.store.RemoveUser(ctx, evt.UserID)
+}
+
+func (s *ProjectionSink) putFile(ctx context.Context, evt event.Event) error {
+	var file domain.File
+	if err := evt.DecodePayload(&file); err != nil {
+		return event.Permanent(err)
+	}
+	if file.UserID == 0 {
+		file.UserID = evt.UserID
+	}
+	return s.store.PutFile(ctx, file)
+}
+
+func (s *ProjectionSink) removeFile(ctx context.Context, evt event.Event) error {
+	var file domain.File
+	if err := evt.DecodePayload(&file); err != nil {
+		return event.Permanent(err)
+	}
+	if file.UserID == 0 {
+		file.UserID = evt.UserID
+	}
+	return s.store.RemoveFile(ctx, file.UserID, file.ID)
+}
+
+// clearFiles removes the listed files one by one, so that a FileAdded for
+// one of them retried later stays removed, and then any others.
+func (s *ProjectionSink) clearFiles(ctx context.Context, evt event.Event) error {
+	var cleared event.FilesCleared
+	if err := evt.DecodePayload(&cleared); err != nil {
+		return event.Permanent(err)
+	}
+	for _, file := range cleared.Files {
+		if err := s.store.RemoveFile(ctx, evt.UserID, file.ID); err != nil {
+			return err
+		}
+	}
+	return s.store.RemoveFiles(ctx, evt.UserID)
+}
@@ -0,0 +1,24 @@
+// Package sink delivers consumed events to their destinations. Each sink
+// registers its handlers with an event.Registry, which cmd/consumer feeds
+// from a queue of the sink's own.
+package sink
+
+import (
+	"context"
+
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// Sink is one destination for events.
+type Sink interface {
+	// Register adds the sink's handlers to r.
+	Register(r *event.Registry)
+	// Close releases the sink once no events are being handled.
+	Close() error
+}
+
+// Runner is implemented by sinks with background work of their own.
+// cmd/consumer runs it next to the sink's consumer until ctx is cancelled.
+type Runner interface {
+	Run(ctx context.Context) error
+}
@@ -0,0 +1,203 @@
+package sink_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/sink"
+)
+
+func dispatcher(s sink.Sink) *event.Registry {
+	r := event.NewRegistry()
+	s.Register(r)
+	return r
+}
+
+func TestJSONLSink_AppendsOneLinePerEvent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	s, err := sink.NewJSONLSink(path)
+	require.NoError(t, err)
+	r := dispatcher(s)
+	ctx := context.Background()
+
+	created := event.New(ctx, event.UserCreated, 1, domain.User{ID: 1, Name: "Alice"})
+	deleted := event.New(ctx, event.UserDeleted, 1, nil)
+	require.NoError(t, r.Dispatch(ctx, created))
+	require.NoError(t, r.Dispatch(ctx, deleted))
+	require.NoError(t, s.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var evt event.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &evt))
+		ids = append(ids, evt.ID)
+	}
+	require.NoError(t, scanner.Err())
+	require.Equal(t, []string{created.ID, deleted.ID}, ids)
+}
+
+func TestHTTPSink_ClassifiesResponses(t *testing.T) {
+	status := http.StatusNoContent
+	var received event.Event
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	r := dispatcher(sink.NewHTTPSink(srv.URL, srv.Client()))
+	ctx := context.Background()
+	evt := event.New(ctx, event.UserCreated, 7, domain.User{ID: 7})
+
+	require.NoError(t, r.Dispatch(ctx, evt))
+	require.Equal(t, evt.ID, received.ID)
+	require.Equal(t, evt.ID, header.Get("X-Event-ID"))
+	require.Equal(t, "UserCreated", header.Get("X-Event-Type"))
+	require.Equal(t, "application/json", header.Get("Content-Type"))
+
+	// Retried failures are plain errors; rejected events are permanent.
+	for code, permanent := range map[int]bool{
+		http.StatusBadGateway:      false,
+		http.StatusTooManyRequests: false,
+		http.StatusRequestTimeout:  false,
+		http.StatusBadRequest:      true,
+		http.StatusGone:            true,
+	} {
+		status = code
+		err := r.Dispatch(ctx, evt)
+		require.Error(t, err, code)
+		require.Equal(t, permanent, event.IsPermanent(err), code)
+	}
+}
+
+func TestProjectionSink_AppliesUserAndFileEvents(t *testing.T) {
+	store := newFakeProjection()
+	r := dispatcher(sink.NewProjectionSink(store))
+	ctx := context.Background()
+	dispatch := func(typ event.Type, userID uint, payload interface{}) {
+		t.Helper()
+		// Round trip through JSON as events read from the broker do.
+		raw, err := json.Marshal(event.New(ctx, typ, userID, payload))
+		require.NoError(t, err)
+		var evt event.Event
+		require.NoError(t, json.Unmarshal(raw, &evt))
+		require.NoError(t, r.Dispatch(ctx, evt))
+	}
+
+	dispatch(event.UserCreated, 1, domain.User{ID: 1, Name: "Alice", Version: 1})
+	dispatch(event.UserUpdated, 1, event.UserChanges{
+		User:    domain.User{ID: 1, Name: "Alicia", Version: 2},
+		Changes: map[string]event.FieldChange{"name": {Before: "Alice", After: "Alicia"}},
+	})
+	dispatch(event.FileAdded, 1, domain.File{ID: 10, UserID: 1, Path: "/a"})
+	dispatch(event.FileAdded, 1, domain.File{ID: 11, UserID: 1, Path: "/b"})
+	dispatch(event.FileDeleted, 1, domain.File{ID: 10, UserID: 1, Path: "/a"})
+	require.Equal(t, "Alicia", store.users[1].Name)
+	require.Equal(t, map[uint]uint{11: 1}, store.files)
+
+	dispatch(event.UserDeleted, 1, nil)
+	require.NotNil(t, store.users[1].DeletedAt)
+	dispatch(event.UserRestored, 1, domain.User{ID: 1, Name: "Alicia", Version: 2})
+	require.Nil(t, store.users[1].DeletedAt)
+
+	dispatch(event.FileAdded, 1, domain.File{ID: 12, UserID: 1, Path: "/c"})
+	dispatch(event.UserFilesCleared, 1, event.FilesCleared{Files: []domain.File{{ID: 11}, {ID: 12}, {ID: 13}}})
+	require.Empty(t, store.files)
+	// Events retried from a delay queue after the removal or purge do not
+	// bring the files or the user back.
+	dispatch(event.FileAdded, 1, domain.File{ID: 13, UserID: 1, Path: "/d"})
+	require.Empty(t, store.files)
+	dispatch(event.UserPurged, 1, nil)
+	require.Empty(t, store.users)
+	dispatch(event.UserUpdated, 1, event.UserChanges{User: domain.User{ID: 1, Name: "Alicia", Version: 2}})
+	dispatch(event.FileAdded, 1, domain.File{ID: 14, UserID: 1, Path: "/e"})
+	require.Empty(t, store.users)
+	require.Empty(t, store.files)
+
+	// Audit events are not projected.
+	dispatch(event.LoginFailed, 0, event.AccountAudit{Username: "bob"})
+}
+
+// fakeProjection keeps users by ID and the owner of each file by file ID,
+// plus tombstones for purged users and removed files.
+type fakeProjection struct {
+	users        map[uint]domain.User
+	files        map[uint]uint
+	purged       map[uint]bool
+	removedFiles map[uint]bool
+}
+
+func newFakeProjection() *fakeProjection {
+	return &fakeProjection{
+		users:        map[uint]domain.User{},
+		files:        map[uint]uint{},
+		purged:       map[uint]bool{},
+		removedFiles: map[uint]bool{},
+	}
+}
+
+func (f *fakeProjection) UpsertUser(_ context.Context, user domain.User) error {
+	if f.purged[user.ID] {
+		return nil
+	}
+	if stored, ok := f.users[user.ID]; ok && stored.Version > user.Version {
+		return nil
+	}
+	f.users[user.ID] = user
+	return nil
+}
+
+func (f *fakeProjection) MarkUserDeleted(_ context.Context, userID uint, deletedAt time.Time) error {
+	if user, ok := f.users[userID]; ok {
+		user.DeletedAt = &deletedAt
+		f.users[userID] = user
+	}
+	return nil
+}
+
+func (f *fakeProjection) RemoveUser(ctx context.Context, userID uint) error {
+	delete(f.users, userID)
+	f.purged[userID] = true
+	return f.RemoveFiles(ctx, userID)
+}
+
+func (f *fakeProjection) PutFile(_ context.Context, file domain.File) error {
+	if f.removedFiles[file.ID] || f.purged[file.UserID] {
+		return nil
+	}
+	f.files[file.ID] = file.UserID
+	return nil
+}
+
+func (f *fakeProjection) RemoveFile(_ context.Context, _, fileID uint) error {
+	delete(f.files, fileID)
+	f.removedFiles[fileID] = true
+	return nil
+}
+
+func (f *fakeProjection) RemoveFiles(ctx context.Context, userID uint) error {
+	for id, owner := range f.files {
+		if owner == userID {
+			_ = f.RemoveFile(ctx, userID, id)
+		}
+	}
+	return nil
+}
+
+var _ sink.ProjectionStore = (*fakeProjection)(nil)
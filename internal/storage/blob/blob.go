@@ -0,0 +1,36 @@
+// Package blob stores file content under opaque keys. Metadata, including
+// the key, lives in the file repository.
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Store is a flat key/value store for file content.
+type Store interface {
+	// Put streams r to key, replacing any existing content.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Open returns the content stored under key, or domain.ErrNotFound. The
+	// reader is seekable so that HTTP range requests can be served.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// validateKey accepts slash-separated relative keys without "." or ".."
+// segments, so keys can be mapped onto a filesystem safely.
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) || path.Clean(key) != key {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
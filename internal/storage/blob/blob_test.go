@@ -0,0 +1,78 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+	testStore(t, store)
+}
+
+func TestS3Store(t *testing.T) {
+	fake := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(fake.Close)
+	endpoint, err := url.Parse(fake.URL)
+	require.NoError(t, err)
+
+	store, err := NewS3Store(context.Background(), S3Config{
+		Endpoint:  endpoint.Host,
+		Region:    "us-east-1",
+		Bucket:    "user-files",
+		AccessKey: "test",
+		SecretKey: "test",
+	})
+	require.NoError(t, err)
+	testStore(t, store)
+}
+
+func TestValidateKey_RejectsEscapingKeys(t *testing.T) {
+	for _, key := range []string{"", "/abs", "a/../b", "../b", "a//b", "a/./b", `a\b`, "a/"} {
+		require.Error(t, validateKey(key), key)
+	}
+	require.NoError(t, validateKey("users/1/abc"))
+}
+
+// testStore exercises the behaviour every Store implementation shares.
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+	key := "users/1/report"
+
+	_, err := store.Open(ctx, key)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+
+	require.NoError(t, store.Put(ctx, key, strings.NewReader("first version"), "text/plain"))
+	require.NoError(t, store.Put(ctx, key, strings.NewReader("hello, blob store"), "text/plain"))
+
+	content, err := store.Open(ctx, key)
+	require.NoError(t, err)
+	size, err := content.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	require.EqualValues(t, len("hello, blob store"), size)
+	_, err = content.Seek(7, io.SeekStart)
+	require.NoError(t, err)
+	tail, err := io.ReadAll(content)
+	require.NoError(t, err)
+	require.Equal(t, "blob store", string(tail))
+	require.NoError(t, content.Close())
+
+	require.NoError(t, store.Delete(ctx, key))
+	require.NoError(t, store.Delete(ctx, key))
+	_, err = store.Open(ctx, key)
+	require.ErrorIs(t, err, domain.ErrNotFound)
+
+	require.Error(t, store.Put(ctx, "../escape", strings.NewReader("x"), ""))
+}
@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/repository"
+)
+
+func (r *Repository) ListAccounts(ctx context.Context) ([]domain.Account, error) {
+	var models []AccountModel
+	if err := r.conn(ctx).Order("id").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	accounts := make([]domain.Account, len(models))
+	for i := range models {
+		accounts[i] = models[i].toDomain()
+	}
+	return accounts, nil
+}
+
+func (r *Repository) CountAccounts(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.conn(ctx).Model(&AccountModel{}).Count(&count).Error
+	return count, err
+}
+
+func (r *Repository) GetAccountByID(ctx context.Context, id uint) (*domain.Account, error) {
+	var model AccountModel
+	if err := r.conn(ctx).First(&model, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	account := model.toDomain()
+	return &account, nil
+}
+
+func (r *Repository) GetAccountByUsername(ctx context.Context, username string) (*domain.Account, error) {
+	var model AccountModel
+	if err := r.conn(ctx).Where("username = ?", username).First(&model).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	account := model.toDomain()
+	return &account, nil
+}
+
+func (r *Repository) CreateAccount(ctx context.Context, account *domain.Account) error {
+	model := accountModelFromDomain(account)
+	if err := r.conn(ctx).Create(&model).Error; err != nil {
+		return err
+	}
+	*account = model.toDomain()
+	return nil
+}
+
+func (r *Repository) UpdateAccount(ctx context.Context, account *domain.Account) error {
+	model := accountModelFromDomain(account)
+	res := r.conn(ctx).Model(&AccountModel{ID: account.ID}).Updates(map[string]interface{}{
+		"password_hash":       model.PasswordHash,
+		"role":                model.Role,
+		"disabled":            model.Disabled,
+		"password_changed_at": model.PasswordChangedAt,
+		"updated_at":          time.Now().UTC(),
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+type AccountModel struct {
+	ID                uint `gorm:"primaryKey"`
+	Username          string
+	PasswordHash      string
+	Role              string
+	Disabled          bool
+	PasswordChangedAt time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+func (AccountModel) TableName() string {
+	return "accounts"
+}
+
+func (a AccountModel) toDomain() domain.Account {
+	return domain.Account{
+		ID:                a.ID,
+		Username:          a.Username,
+		PasswordHash:      a.PasswordHash,
+		Role:              a.Role,
+		Disabled:          a.Disabled,
+		PasswordChangedAt: a.PasswordChangedAt,
+		CreatedAt:         a.CreatedAt,
+		UpdatedAt:         a.UpdatedAt,
+	}
+}
+
+func accountModelFromDomain(a *domain.Account) AccountModel {
+	return AccountModel{
+		ID:                a.ID,
+		Username:          a.Username,
+		PasswordHash:      a.PasswordHash,
+		Role:              a.Role,
+		Disabled:          a.Disabled,
+		PasswordChangedAt: a.PasswordChangedAt,
+		CreatedAt:         a.CreatedAt,
+		UpdatedAt:         a.UpdatedAt,
+	}
+}
+
+var _ repository.AccountRepository = (*Repository)(nil)
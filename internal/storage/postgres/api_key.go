@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/repository"
+)
+
+func (r *Repository) ListAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	var models []APIKeyModel
+	if err := r.conn(ctx).Order("id").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	keys := make([]domain.APIKey, len(models))
+	for i := range models {
+		keys[i] = models[i].toDomain()
+	}
+	return keys, nil
+}
+
+func (r *Repository) GetAPIKeyByID(ctx context.Context, id uint) (*domain.APIKey, error) {
+	var model APIKeyModel
+	if err := r.conn(ctx).First(&model, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	key := model.toDomain()
+	return &key, nil
+}
+
+func (r *Repository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error) {
+	var model APIKeyModel
+	if err := r.conn(ctx).Where("prefix = ?", prefix).First(&model).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	key := model.toDomain()
+	return &key, nil
+}
+
+func (r *Repository) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	model := apiKeyModelFromDomain(key)
+	if err := r.conn(ctx).Create(&model).Error; err != nil {
+		return err
+	}
+	*key = model.toDomain()
+	return nil
+}
+
+func (r *Repository) RevokeAPIKey(ctx context.Context, id uint, at time.Time) error {
+	res := r.conn(ctx).Model(&APIKeyModel{ID: id}).Where("revoked_at IS NULL").Update("revoked_at", at)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		var count int64
+		if err := r.conn(ctx).Model(&APIKeyModel{}).Where("id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return domain.ErrNotFound
+		}
+	}
+	return nil
+}
+
+func (r *Repository) TouchAPIKey(ctx context.Context, id uint, at time.Time) error {
+	return r.conn(ctx).Model(&APIKeyModel{ID: id}).Update("last_used_at", at).Error
+}
+
+type APIKeyModel struct {
+	ID         uint `gorm:"primaryKey"`
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     string
+	CreatedBy  string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (APIKeyModel) TableName() string {
+	return "api_keys"
+}
+
+func (k APIKeyModel) toDomain() domain.APIKey {
+	return domain.APIKey{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		KeyHash:    k.KeyHash,
+		Scopes:     strings.Fields(k.Scopes),
+		CreatedBy:  k.CreatedBy,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
+func apiKeyModelFromDomain(k *domain.APIKey) APIKeyModel {
+	return APIKeyModel{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		KeyHash:    k.KeyHash,
+		Scopes:     strings.Join(k.Scopes, " "),
+		CreatedBy:  k.CreatedBy,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
+var _ repository.APIKeyRepository = (*Repository)(nil)
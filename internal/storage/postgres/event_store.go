@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// EventStore appends every published event to the events table. Used as an
+// event.Publisher next to the outbox, events are recorded in the caller's
+// transaction.
+type EventStore struct {
+	repo *Repository
+}
+
+func NewEventStore(repo *Repository) *EventStore {
+	return &EventStore{repo: repo}
+}
+
+func (s *EventStore) Publish(ctx context.Context, evt event.Event) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	model := EventModel{
+		EventType:  string(evt.Type),
+		UserID:     evt.UserID,
+		Body:       body,
+		OccurredAt: evt.OccurredAt,
+		RecordedAt: time.Now().UTC(),
+	}
+	if evt.ID != "" {
+		model.EventID = &evt.ID
+	}
+	return s.repo.conn(ctx).Create(&model).Error
+}
+
+func (s *EventStore) Events(ctx context.Context, filter event.EventFilter) ([]event.StoredEvent, error) {
+	query := s.repo.conn(ctx).Model(&EventModel{}).Where("sequence > ?", filter.AfterSequence)
+	if filter.ToSequence > 0 {
+		query = query.Where("sequence <= ?", filter.ToSequence)
+	}
+	if len(filter.Types) > 0 {
+		types := make([]string, len(filter.Types))
+		for i, t := range filter.Types {
+			types[i] = string(t)
+		}
+		query = query.Where("event_type IN ?", types)
+	}
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("occurred_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("occurred_at < ?", filter.Until)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var models []EventModel
+	if err := query.Order("sequence").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	events := make([]event.StoredEvent, 0, len(models))
+	for _, m := range models {
+		stored := event.StoredEvent{Sequence: m.Sequence}
+		if err := json.Unmarshal(m.Body, &stored.Event); err != nil {
+			return nil, fmt.Errorf("decode event %d: %w", m.Sequence, err)
+		}
+		events = append(events, stored)
+	}
+	return events, nil
+}
+
+type EventModel struct {
+	Sequence   int64   `gorm:"primaryKey"`
+	EventID    *string `gorm:"uniqueIndex"`
+	EventType  string  `gorm:"not null"`
+	UserID     uint
+	Body       []byte `gorm:"type:jsonb;not null"`
+	OccurredAt time.Time
+	RecordedAt time.Time
+}
+
+func (EventModel) TableName() string {
+	return "events"
+}
+
+var _ event.Publisher = (*EventStore)(nil)
+var _ event.EventStore = (*EventStore)(nil)
@@ -0,0 +1,25 @@
+package postgres
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedMigrations_AreOrderedAndReversible(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, mig := range migrations {
+		require.Equal(t, i+1, mig.Version, "migration versions must be contiguous")
+		require.NotEmpty(t, mig.down, "migration %04d_%s needs a down script", mig.Version, mig.Name)
+	}
+}
+
+func TestLoadMigrations_RejectsMissingUpScript(t *testing.T) {
+	_, err := loadMigrations(fstest.MapFS{
+		"migrations/0001_init.down.sql": {Data: []byte("DROP TABLE x;")},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS file_models;
+DROP TABLE IF EXISTS user_models;
@@ -0,0 +1,27 @@
+-- Baseline schema. IF NOT EXISTS lets databases previously managed by GORM
+-- AutoMigrate adopt the versioned migrations without changes.
+CREATE TABLE IF NOT EXISTS user_models (
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    name       TEXT,
+    email      TEXT,
+    age        BIGINT
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_models_email ON user_models (email);
+CREATE INDEX IF NOT EXISTS idx_user_models_deleted_at ON user_models (deleted_at);
+
+CREATE TABLE IF NOT EXISTS file_models (
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    user_id    BIGINT,
+    name       TEXT,
+    path       TEXT,
+    CONSTRAINT fk_user_models_files FOREIGN KEY (user_id) REFERENCES user_models (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_models_deleted_at ON file_models (deleted_at);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS outbox_messages (
+    id              BIGSERIAL PRIMARY KEY,
+    event_type      TEXT NOT NULL,
+    user_id         BIGINT,
+    body            JSONB NOT NULL,
+    attempts        BIGINT NOT NULL DEFAULT 0,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    delivered_at    TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_next_attempt_at ON outbox_messages (next_attempt_at);
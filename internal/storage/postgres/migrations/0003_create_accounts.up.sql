@@ -0,0 +1,11 @@
+CREATE TABLE accounts (
+    id                  BIGSERIAL PRIMARY KEY,
+    username            TEXT NOT NULL,
+    password_hash       TEXT NOT NULL,
+    disabled            BOOLEAN NOT NULL DEFAULT FALSE,
+    password_changed_at TIMESTAMPTZ NOT NULL,
+    created_at          TIMESTAMPTZ NOT NULL,
+    updated_at          TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_accounts_username ON accounts (username);
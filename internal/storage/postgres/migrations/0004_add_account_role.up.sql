@@ -0,0 +1,3 @@
+-- Accounts created before roles existed were all administrators.
+ALTER TABLE accounts ADD COLUMN role TEXT NOT NULL DEFAULT 'admin';
+ALTER TABLE accounts ALTER COLUMN role DROP DEFAULT;
@@ -0,0 +1,15 @@
+CREATE TABLE api_keys (
+    id           BIGSERIAL PRIMARY KEY,
+    name         TEXT NOT NULL,
+    prefix       TEXT NOT NULL,
+    key_hash     TEXT NOT NULL,
+    -- Space-separated, like the "scope" claim of access tokens.
+    scopes       TEXT NOT NULL DEFAULT '',
+    created_by   TEXT NOT NULL DEFAULT '',
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_api_keys_prefix ON api_keys (prefix);
@@ -0,0 +1,2 @@
+-- Incremented on every update; exposed to clients as the user's ETag.
+ALTER TABLE user_models ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+-- Fails if an address was re-registered after its previous owner was
+-- soft-deleted; purge or rename those rows first.
+DROP INDEX IF EXISTS idx_user_models_email;
+CREATE UNIQUE INDEX idx_user_models_email ON user_models (email);
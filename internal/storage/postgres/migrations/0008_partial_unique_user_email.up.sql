@@ -0,0 +1,3 @@
+-- Soft-deleted users no longer reserve their email address.
+DROP INDEX IF EXISTS idx_user_models_email;
+CREATE UNIQUE INDEX idx_user_models_email ON user_models (email) WHERE deleted_at IS NULL;
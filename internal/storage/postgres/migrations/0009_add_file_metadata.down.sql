@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_file_models_user_path;
+ALTER TABLE file_models
+    DROP COLUMN labels,
+    DROP COLUMN sha256,
+    DROP COLUMN content_type,
+    DROP COLUMN size;
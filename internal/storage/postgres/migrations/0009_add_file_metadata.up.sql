@@ -0,0 +1,16 @@
+ALTER TABLE file_models
+    ADD COLUMN size         BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN content_type TEXT NOT NULL DEFAULT '',
+    ADD COLUMN sha256       TEXT NOT NULL DEFAULT '',
+    ADD COLUMN labels       JSONB NOT NULL DEFAULT '{}';
+
+-- Keep only the newest live file per user and path before enforcing
+-- uniqueness.
+UPDATE file_models f SET deleted_at = now()
+WHERE f.deleted_at IS NULL AND EXISTS (
+    SELECT 1 FROM file_models g
+    WHERE g.user_id = f.user_id AND g.path = f.path
+      AND g.deleted_at IS NULL AND g.id > f.id
+);
+
+CREATE UNIQUE INDEX idx_file_models_user_path ON file_models (user_id, path) WHERE deleted_at IS NULL;
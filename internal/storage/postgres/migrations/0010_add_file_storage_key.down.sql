@@ -0,0 +1 @@
+ALTER TABLE file_models DROP COLUMN storage_key;
@@ -0,0 +1 @@
+ALTER TABLE file_models ADD COLUMN storage_key TEXT NOT NULL DEFAULT '';
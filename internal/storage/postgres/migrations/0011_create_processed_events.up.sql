@@ -0,0 +1,9 @@
+-- IDs of events a consumer has handled, kept for deduplication until they
+-- expire.
+CREATE TABLE processed_events (
+    event_id     TEXT PRIMARY KEY,
+    processed_at TIMESTAMPTZ NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_processed_events_expires_at ON processed_events (expires_at);
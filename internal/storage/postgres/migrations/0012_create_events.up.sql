@@ -0,0 +1,22 @@
+-- Every event ever published, in publish order. Events recorded before
+-- this table existed are copied from the outbox.
+CREATE TABLE events (
+    sequence    BIGSERIAL PRIMARY KEY,
+    event_id    TEXT UNIQUE,
+    event_type  TEXT NOT NULL,
+    user_id     BIGINT,
+    body        JSONB NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_events_event_type ON events (event_type, sequence);
+CREATE INDEX idx_events_user_id ON events (user_id, sequence);
+CREATE INDEX idx_events_occurred_at ON events (occurred_at);
+
+INSERT INTO events (event_id, event_type, user_id, body, occurred_at, recorded_at)
+SELECT NULLIF(body->>'id', ''), event_type, user_id, body,
+       COALESCE((body->>'occurred_at')::timestamptz, created_at, now()),
+       COALESCE(created_at, now())
+FROM outbox_messages
+ORDER BY id;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_file_projections;
+DROP TABLE IF EXISTS user_projections;
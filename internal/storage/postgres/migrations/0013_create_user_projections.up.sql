@@ -0,0 +1,24 @@
+-- Read model maintained by the projection sink of cmd/consumer from user
+-- and file events.
+CREATE TABLE user_projections (
+    user_id      BIGINT PRIMARY KEY,
+    name         TEXT NOT NULL,
+    email        TEXT NOT NULL,
+    age          INTEGER NOT NULL,
+    version      BIGINT NOT NULL,
+    created_at   TIMESTAMPTZ,
+    updated_at   TIMESTAMPTZ,
+    deleted_at   TIMESTAMPTZ,
+    projected_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE user_file_projections (
+    file_id      BIGINT PRIMARY KEY,
+    user_id      BIGINT NOT NULL,
+    path         TEXT NOT NULL,
+    size         BIGINT NOT NULL,
+    content_type TEXT,
+    projected_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_user_file_projections_user_id ON user_file_projections (user_id);
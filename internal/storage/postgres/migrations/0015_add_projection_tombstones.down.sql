@@ -0,0 +1,4 @@
+DELETE FROM user_file_projections WHERE deleted_at IS NOT NULL;
+DELETE FROM user_projections WHERE purged_at IS NOT NULL;
+ALTER TABLE user_file_projections DROP COLUMN deleted_at;
+ALTER TABLE user_projections DROP COLUMN purged_at;
@@ -0,0 +1,4 @@
+-- Purged users and deleted files stay in the projection as tombstones so
+-- that events retried after the purge or delete cannot bring them back.
+ALTER TABLE user_projections ADD COLUMN purged_at TIMESTAMPTZ;
+ALTER TABLE user_file_projections ADD COLUMN deleted_at TIMESTAMPTZ;
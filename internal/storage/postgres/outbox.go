@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// Outbox records events in the outbox_messages table. Used as the service's
+// event.Publisher, events are written in the caller's transaction and later
+// forwarded to the broker by event.OutboxRelay.
+type Outbox struct {
+	repo *Repository
+}
+
+func NewOutbox(repo *Repository) *Outbox {
+	return &Outbox{repo: repo}
+}
+
+func (o *Outbox) Publish(ctx context.Context, evt event.Event) error {
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	model := OutboxModel{
+		EventType:     string(evt.Type),
+		UserID:        evt.UserID,
+		Body:          payload,
+		NextAttemptAt: time.Now().UTC(),
+	}
+	return o.repo.conn(ctx).Create(&model).Error
+}
+
+func (o *Outbox) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return o.repo.WithinTransaction(ctx, fn)
+}
+
+func (o *Outbox) Pending(ctx context.Context, limit int) ([]event.OutboxMessage, error) {
+	var models []OutboxModel
+	err := o.repo.conn(ctx).
+		Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+		Where("delivered_at IS NULL AND next_attempt_at <= ?", time.Now().UTC()).
+		Order("id").
+		Limit(limit).
+		Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]event.OutboxMessage, 0, len(models))
+	for _, m := range models {
+		var evt event.Event
+		if err := json.Unmarshal(m.Body, &evt); err != nil {
+			return nil, fmt.Errorf("decode outbox message %d: %w", m.ID, err)
+		}
+		msgs = append(msgs, event.OutboxMessage{ID: m.ID, Event: evt, Attempts: m.Attempts})
+	}
+	return msgs, nil
+}
+
+func (o *Outbox) MarkDelivered(ctx context.Context, id uint64) error {
+	return o.repo.conn(ctx).Model(&OutboxModel{ID: id}).Updates(map[string]interface{}{
+		"delivered_at": time.Now().UTC(),
+		"attempts":     gorm.Expr("attempts + 1"),
+		"last_error":   "",
+	}).Error
+}
+
+func (o *Outbox) MarkFailed(ctx context.Context, id uint64, nextAttempt time.Time, reason string) error {
+	return o.repo.conn(ctx).Model(&OutboxModel{ID: id}).Updates(map[string]interface{}{
+		"next_attempt_at": nextAttempt,
+		"attempts":        gorm.Expr("attempts + 1"),
+		"last_error":      reason,
+	}).Error
+}
+
+type OutboxModel struct {
+	ID            uint64 `gorm:"primaryKey"`
+	EventType     string `gorm:"not null"`
+	UserID        uint
+	Body          []byte `gorm:"type:jsonb;not null"`
+	Attempts      int    `gorm:"not null;default:0"`
+	LastError     string
+	NextAttemptAt time.Time `gorm:"not null;index"`
+	DeliveredAt   *time.Time
+	CreatedAt     time.Time
+}
+
+func (OutboxModel) TableName() string {
+	return "outbox_messages"
+}
+
+var _ event.Publisher = (*Outbox)(nil)
+var _ event.OutboxStore = (*Outbox)(nil)
@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// ProcessedEvents records handled event IDs in the processed_events table,
+// so deduplication is shared by all consumer processes and survives
+// restarts. Expired rows are ignored by Claim and removed by DeleteExpired.
+type ProcessedEvents struct {
+	repo *Repository
+}
+
+func NewProcessedEvents(repo *Repository) *ProcessedEvents {
+	return &ProcessedEvents{repo: repo}
+}
+
+// Claim inserts id, or takes over its row once expired. The conflict clause
+// makes this a single atomic statement, so concurrent claims for the same
+// id affect one row between them.
+func (p *ProcessedEvents) Claim(ctx context.Context, id string, leaseUntil time.Time) (bool, error) {
+	now := time.Now().UTC()
+	result := p.repo.conn(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "event_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"processed_at", "expires_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "processed_events.expires_at <= ?", Vars: []interface{}{now}},
+		}},
+	}).Create(&ProcessedEventModel{EventID: id, ProcessedAt: now, ExpiresAt: leaseUntil})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Complete extends the row of id to expiresAt, recreating it if the lease
+// ran out and was swept while the event was handled.
+func (p *ProcessedEvents) Complete(ctx context.Context, id string, expiresAt time.Time) error {
+	return p.repo.conn(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "event_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"processed_at", "expires_at"}),
+	}).Create(&ProcessedEventModel{EventID: id, ProcessedAt: time.Now().UTC(), ExpiresAt: expiresAt}).Error
+}
+
+func (p *ProcessedEvents) Release(ctx context.Context, id string) error {
+	return p.repo.conn(ctx).Where("event_id = ?", id).Delete(&ProcessedEventModel{}).Error
+}
+
+// DeleteExpired removes expired rows and returns how many there were.
+func (p *ProcessedEvents) DeleteExpired(ctx context.Context) (int64, error) {
+	result := p.repo.conn(ctx).Where("expires_at <= ?", time.Now().UTC()).Delete(&ProcessedEventModel{})
+	return result.RowsAffected, result.Error
+}
+
+type ProcessedEventModel struct {
+	EventID     string `gorm:"primaryKey"`
+	ProcessedAt time.Time
+	ExpiresAt   time.Time
+}
+
+func (ProcessedEventModel) TableName() string {
+	return "processed_events"
+}
+
+var _ event.ProcessedStore = (*ProcessedEvents)(nil)
@@ -0,0 +1,169 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/sink"
+)
+
+// UserProjection keeps the read model of the projection sink in the
+// user_projections and user_file_projections tables. Files are only
+// referenced by user ID, so they may be projected before their user.
+//
+// Purged users and deleted files are kept as tombstones (purged_at and
+// deleted_at set, personal fields cleared) rather than removed, so that an
+// older event retried after them cannot insert the row again. Writes that
+// touch a user's files take a per-user advisory lock, which keeps a file
+// from being added concurrently with its user's purge.
+type UserProjection struct {
+	repo *Repository
+}
+
+func NewUserProjection(repo *Repository) *UserProjection {
+	return &UserProjection{repo: repo}
+}
+
+// projectionLockSpace is the first key of the per-user advisory locks; the
+// user ID is the second.
+const projectionLockSpace int32 = 0x55504a // "UPJ"
+
+// UpsertUser keeps the stored row when it has a newer version or is a
+// tombstone, so events applied out of order or replayed do not roll the
+// user back. Soft deletes do not bump the version, so a deletion survives
+// events of the same version.
+func (p *UserProjection) UpsertUser(ctx context.Context, user domain.User) error {
+	model := UserProjectionModel{
+		UserID:      user.ID,
+		Name:        user.Name,
+		Email:       user.Email,
+		Age:         user.Age,
+		Version:     user.Version,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		DeletedAt:   user.DeletedAt,
+		ProjectedAt: time.Now().UTC(),
+	}
+	updates := clause.AssignmentColumns([]string{
+		"name", "email", "age", "version", "created_at", "updated_at", "projected_at",
+	})
+	updates = append(updates, clause.Assignment{
+		Column: clause.Column{Name: "deleted_at"},
+		Value: gorm.Expr("CASE WHEN EXCLUDED.version > user_projections.version " +
+			"THEN EXCLUDED.deleted_at ELSE COALESCE(user_projections.deleted_at, EXCLUDED.deleted_at) END"),
+	})
+	return p.repo.conn(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: updates,
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "user_projections.purged_at IS NULL AND user_projections.version <= EXCLUDED.version"},
+		}},
+	}).Create(&model).Error
+}
+
+func (p *UserProjection) MarkUserDeleted(ctx context.Context, userID uint, deletedAt time.Time) error {
+	return p.repo.conn(ctx).Model(&UserProjectionModel{}).
+		Where("user_id = ? AND purged_at IS NULL", userID).
+		Updates(map[string]interface{}{"deleted_at": deletedAt, "projected_at": time.Now().UTC()}).Error
+}
+
+// RemoveUser turns the user into a tombstone, creating one if the user was
+// never projected, and marks its files deleted.
+func (p *UserProjection) RemoveUser(ctx context.Context, userID uint) error {
+	now := time.Now().UTC()
+	return p.withUserLock(ctx, userID, func(tx *gorm.DB) error {
+		err := tx.Exec(`INSERT INTO user_projections (user_id, name, email, age, version, purged_at, projected_at)
+VALUES (?, '', '', 0, 0, ?, ?)
+ON CONFLICT (user_id) DO UPDATE SET name = '', email = '', age = 0,
+    purged_at = COALESCE(user_projections.purged_at, EXCLUDED.purged_at), projected_at = EXCLUDED.projected_at`,
+			userID, now, now).Error
+		if err != nil {
+			return err
+		}
+		return p.deleteFiles(tx, userID, now)
+	})
+}
+
+// PutFile stores file unless it was deleted or its user purged.
+func (p *UserProjection) PutFile(ctx context.Context, file domain.File) error {
+	return p.withUserLock(ctx, file.UserID, func(tx *gorm.DB) error {
+		return tx.Exec(`INSERT INTO user_file_projections (file_id, user_id, path, size, content_type, projected_at)
+SELECT ?::bigint, ?::bigint, ?::text, ?::bigint, ?::text, ?::timestamptz
+WHERE NOT EXISTS (SELECT 1 FROM user_projections WHERE user_id = ? AND purged_at IS NOT NULL)
+ON CONFLICT (file_id) DO UPDATE SET user_id = EXCLUDED.user_id, path = EXCLUDED.path, size = EXCLUDED.size,
+    content_type = EXCLUDED.content_type, projected_at = EXCLUDED.projected_at
+WHERE user_file_projections.deleted_at IS NULL`,
+			file.ID, file.UserID, file.Path, file.Size, file.ContentType, time.Now().UTC(), file.UserID).Error
+	})
+}
+
+// RemoveFile marks the file deleted, leaving a tombstone if it was never
+// projected.
+func (p *UserProjection) RemoveFile(ctx context.Context, userID, fileID uint) error {
+	now := time.Now().UTC()
+	return p.repo.conn(ctx).Exec(`INSERT INTO user_file_projections (file_id, user_id, path, size, content_type, deleted_at, projected_at)
+VALUES (?, ?, '', 0, '', ?, ?)
+ON CONFLICT (file_id) DO UPDATE SET deleted_at = EXCLUDED.deleted_at, projected_at = EXCLUDED.projected_at
+WHERE user_file_projections.deleted_at IS NULL`,
+		fileID, userID, now, now).Error
+}
+
+// RemoveFiles marks every projected file of the user deleted.
+func (p *UserProjection) RemoveFiles(ctx context.Context, userID uint) error {
+	return p.withUserLock(ctx, userID, func(tx *gorm.DB) error {
+		return p.deleteFiles(tx, userID, time.Now().UTC())
+	})
+}
+
+func (p *UserProjection) deleteFiles(tx *gorm.DB, userID uint, now time.Time) error {
+	return tx.Model(&UserFileProjectionModel{}).
+		Where("user_id = ? AND deleted_at IS NULL", userID).
+		Updates(map[string]interface{}{"deleted_at": now, "projected_at": now}).Error
+}
+
+func (p *UserProjection) withUserLock(ctx context.Context, userID uint, fn func(tx *gorm.DB) error) error {
+	return p.repo.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", projectionLockSpace, int32(userID)).Error; err != nil {
+			return fmt.Errorf("lock user projection: %w", err)
+		}
+		return fn(tx)
+	})
+}
+
+type UserProjectionModel struct {
+	UserID      uint `gorm:"primaryKey;autoIncrement:false"`
+	Name        string
+	Email       string
+	Age         int
+	Version     int64
+	CreatedAt   time.Time `gorm:"autoCreateTime:false"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime:false"`
+	DeletedAt   *time.Time
+	PurgedAt    *time.Time
+	ProjectedAt time.Time
+}
+
+func (UserProjectionModel) TableName() string {
+	return "user_projections"
+}
+
+type UserFileProjectionModel struct {
+	FileID      uint `gorm:"primaryKey;autoIncrement:false"`
+	UserID      uint
+	Path        string
+	Size        int64
+	ContentType string
+	DeletedAt   *time.Time
+	ProjectedAt time.Time
+}
+
+func (UserFileProjectionModel) TableName() string {
+	return "user_file_projections"
+}
+
+var _ sink.ProjectionStore = (*UserProjection)(nil)
@@ -3,6 +3,7 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -15,14 +16,13 @@ type Repository struct {
 	db *gorm.DB
 }
 
+// NewRepository connects to Postgres. It does not touch the schema; apply
+// migrations with Migrator first.
 func NewRepository(dsn string) (*Repository, error) {
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, fmt.Errorf("connect postgres: %w", err)
 	}
-	if err := db.AutoMigrate(&UserModel{}, &FileModel{}); err != nil {
-		return nil, fmt.Errorf("auto migrate: %w", err)
-	}
 	return &Repository{db: db}, nil
 }
 
@@ -30,6 +30,15 @@ func (r *Repository) DB() *gorm.DB {
 	return r.db
 }
 
+// Ping checks that the database is reachable.
+func (r *Repository) Ping(ctx context.Context) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
 func (r *Repository) Close() error {
 	sqlDB, err := r.db.DB()
 	if err != nil {
@@ -38,21 +47,31 @@ func (r *Repository) Close() error {
 	return sqlDB.Close()
 }
 
-func (r *Repository) List(ctx context.Context) ([]domain.User, error) {
-	var models []UserModel
-	if err := r.db.WithContext(ctx).Preload("Files").Find(&models).Error; err != nil {
-		return nil, err
+// WithinTransaction runs fn inside a database transaction. Repository calls
+// made with the context passed to fn share that transaction; nested calls
+// reuse the outer one.
+func (r *Repository) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
 	}
-	users := make([]domain.User, len(models))
-	for i := range models {
-		users[i] = models[i].toDomain()
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+type txKey struct{}
+
+// conn returns the transaction bound to ctx, falling back to the pool.
+func (r *Repository) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
 	}
-	return users, nil
+	return r.db.WithContext(ctx)
 }
 
 func (r *Repository) GetByID(ctx context.Context, id uint) (*domain.User, error) {
 	var model UserModel
-	if err := r.db.WithContext(ctx).Preload("Files").First(&model, id).Error; err != nil {
+	if err := r.conn(ctx).Preload("Files").First(&model, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, domain.ErrNotFound
 		}
@@ -64,7 +83,7 @@ func (r *Repository) GetByID(ctx context.Context, id uint) (*domain.User, error)
 
 func (r *Repository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
 	var model UserModel
-	if err := r.db.WithContext(ctx).Where("email = ?", email).First(&model).Error; err != nil {
+	if err := r.conn(ctx).Where("email = ?", email).First(&model).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, nil
 		}
@@ -76,7 +95,10 @@ func (r *Repository) GetByEmail(ctx context.Context, email string) (*domain.User
 
 func (r *Repository) Create(ctx context.Context, user *domain.User) error {
 	model := fromDomain(user)
-	if err := r.db.WithContext(ctx).Create(&model).Error; err != nil {
+	if model.Version == 0 {
+		model.Version = 1
+	}
+	if err := r.conn(ctx).Create(&model).Error; err != nil {
 		return err
 	}
 	*user = model.toDomain()
@@ -84,17 +106,65 @@ func (r *Repository) Create(ctx context.Context, user *domain.User) error {
 }
 
 func (r *Repository) Update(ctx context.Context, user *domain.User) error {
-	model := fromDomain(user)
-	model.ID = user.ID
-	target := &UserModel{Model: gorm.Model{ID: user.ID}}
-	if err := r.db.WithContext(ctx).Model(target).Updates(model).Error; err != nil {
-		return err
+	now := time.Now().UTC()
+	res := r.conn(ctx).Model(&UserModel{}).
+		Where("id = ? AND version = ?", user.ID, user.Version).
+		Updates(map[string]interface{}{
+			"name":       user.Name,
+			"email":      user.Email,
+			"age":        user.Age,
+			"version":    gorm.Expr("version + 1"),
+			"updated_at": now,
+		})
+	if res.Error != nil {
+		return res.Error
 	}
+	if res.RowsAffected == 0 {
+		return r.missOrStale(ctx, user.ID)
+	}
+	user.Version++
+	user.UpdatedAt = now
 	return nil
 }
 
-func (r *Repository) Delete(ctx context.Context, id uint) error {
-	res := r.db.WithContext(ctx).Delete(&UserModel{}, id)
+func (r *Repository) Delete(ctx context.Context, id uint, version int64) error {
+	db := r.conn(ctx)
+	if version != 0 {
+		db = db.Where("version = ?", version)
+	}
+	res := db.Delete(&UserModel{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return r.missOrStale(ctx, id)
+	}
+	return nil
+}
+
+func (r *Repository) GetDeletedByID(ctx context.Context, id uint) (*domain.User, error) {
+	var model UserModel
+	err := r.conn(ctx).Unscoped().Preload("Files").
+		Where("deleted_at IS NOT NULL").
+		First(&model, id).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	user := model.toDomain()
+	return &user, nil
+}
+
+func (r *Repository) Restore(ctx context.Context, id uint) error {
+	res := r.conn(ctx).Unscoped().Model(&UserModel{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Updates(map[string]interface{}{
+			"deleted_at": nil,
+			"version":    gorm.Expr("version + 1"),
+			"updated_at": time.Now().UTC(),
+		})
 	if res.Error != nil {
 		return res.Error
 	}
@@ -104,9 +174,43 @@ func (r *Repository) Delete(ctx context.Context, id uint) error {
 	return nil
 }
 
+func (r *Repository) Purge(ctx context.Context, id uint, version int64) error {
+	db := r.conn(ctx).Unscoped()
+	if version != 0 {
+		db = db.Where("version = ?", version)
+	}
+	res := db.Delete(&UserModel{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		var count int64
+		if err := r.conn(ctx).Unscoped().Model(&UserModel{}).Where("id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return domain.ErrNotFound
+		}
+		return domain.ErrPreconditionFailed
+	}
+	return nil
+}
+
+// missOrStale explains a conditional write that matched no row.
+func (r *Repository) missOrStale(ctx context.Context, id uint) error {
+	var count int64
+	if err := r.conn(ctx).Model(&UserModel{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return domain.ErrNotFound
+	}
+	return domain.ErrPreconditionFailed
+}
+
 func (r *Repository) ListByUser(ctx context.Context, userID uint) ([]domain.File, error) {
 	var models []FileModel
-	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&models).Error; err != nil {
+	if err := r.conn(ctx).Where("user_id = ?", userID).Order("id").Find(&models).Error; err != nil {
 		return nil, err
 	}
 	files := make([]domain.File, len(models))
@@ -116,32 +220,82 @@ func (r *Repository) ListByUser(ctx context.Context, userID uint) ([]domain.File
 	return files, nil
 }
 
+func (r *Repository) GetFile(ctx context.Context, userID, fileID uint) (*domain.File, error) {
+	var model FileModel
+	if err := r.conn(ctx).Where("user_id = ?", userID).First(&model, fileID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	file := model.toDomain()
+	return &file, nil
+}
+
 func (r *Repository) Add(ctx context.Context, file *domain.File) error {
 	model := fileModelFromDomain(file)
-	if err := r.db.WithContext(ctx).Create(&model).Error; err != nil {
+	if err := r.conn(ctx).Create(&model).Error; err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrConflict
+		}
 		return err
 	}
 	*file = model.toDomain()
 	return nil
 }
 
+func (r *Repository) UpdateFile(ctx context.Context, file *domain.File) error {
+	model := fileModelFromDomain(file)
+	model.UpdatedAt = time.Now().UTC()
+	res := r.conn(ctx).Model(&FileModel{}).
+		Where("id = ? AND user_id = ?", file.ID, file.UserID).
+		Select("name", "path", "size", "content_type", "sha256", "labels", "storage_key", "updated_at").
+		Updates(&model)
+	if res.Error != nil {
+		if isUniqueViolation(res.Error) {
+			return domain.ErrConflict
+		}
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrNotFound
+	}
+	file.UpdatedAt = model.UpdatedAt
+	return nil
+}
+
+func (r *Repository) DeleteFile(ctx context.Context, userID, fileID uint) error {
+	res := r.conn(ctx).Where("user_id = ?", userID).Delete(&FileModel{}, fileID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
 func (r *Repository) DeleteByUser(ctx context.Context, userID uint) error {
-	return r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&FileModel{}).Error
+	return r.conn(ctx).Where("user_id = ?", userID).Delete(&FileModel{}).Error
 }
 
 func (r *Repository) Truncate(ctx context.Context) error {
-	if err := r.db.WithContext(ctx).Exec("TRUNCATE TABLE file_models RESTART IDENTITY CASCADE").Error; err != nil {
+	if err := r.conn(ctx).Exec("TRUNCATE TABLE outbox_messages, events, processed_events, user_projections, user_file_projections, webhooks, webhook_deliveries, accounts, refresh_tokens, revoked_tokens, api_keys RESTART IDENTITY CASCADE").Error; err != nil {
 		return err
 	}
-	return r.db.WithContext(ctx).Exec("TRUNCATE TABLE user_models RESTART IDENTITY CASCADE").Error
+	if err := r.conn(ctx).Exec("TRUNCATE TABLE file_models RESTART IDENTITY CASCADE").Error; err != nil {
+		return err
+	}
+	return r.conn(ctx).Exec("TRUNCATE TABLE user_models RESTART IDENTITY CASCADE").Error
 }
 
 type UserModel struct {
 	gorm.Model
-	Name  string
-	Email string `gorm:"uniqueIndex"`
-	Age   int
-	Files []FileModel `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	Name    string
+	Email   string `gorm:"uniqueIndex:idx_user_models_email,where:deleted_at IS NULL"`
+	Age     int
+	Version int64
+	Files   []FileModel `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
 }
 
 func (u UserModel) toDomain() domain.User {
@@ -154,12 +308,22 @@ func (u UserModel) toDomain() domain.User {
 		Name:      u.Name,
 		Email:     u.Email,
 		Age:       u.Age,
+		Version:   u.Version,
 		Files:     files,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
+		DeletedAt: deletedAt(u.DeletedAt),
 	}
 }
 
+func deletedAt(d gorm.DeletedAt) *time.Time {
+	if !d.Valid {
+		return nil
+	}
+	t := d.Time
+	return &t
+}
+
 func fromDomain(u *domain.User) UserModel {
 	return UserModel{
 		Model: gorm.Model{
@@ -167,40 +331,63 @@ func fromDomain(u *domain.User) UserModel {
 			CreatedAt: u.CreatedAt,
 			UpdatedAt: u.UpdatedAt,
 		},
-		Name:  u.Name,
-		Email: u.Email,
-		Age:   u.Age,
+		Name:    u.Name,
+		Email:   u.Email,
+		Age:     u.Age,
+		Version: u.Version,
 	}
 }
 
 type FileModel struct {
 	gorm.Model
-	UserID uint
-	Name   string
-	Path   string
+	UserID      uint
+	Name        string
+	Path        string
+	Size        int64
+	ContentType string
+	SHA256      string            `gorm:"column:sha256"`
+	Labels      map[string]string `gorm:"serializer:json;type:jsonb"`
+	StorageKey  string
 }
 
 func (f FileModel) toDomain() domain.File {
 	return domain.File{
-		ID:        uint(f.ID),
-		UserID:    f.UserID,
-		Name:      f.Name,
-		Path:      f.Path,
-		CreatedAt: f.CreatedAt,
+		ID:          uint(f.ID),
+		UserID:      f.UserID,
+		Name:        f.Name,
+		Path:        f.Path,
+		Size:        f.Size,
+		ContentType: f.ContentType,
+		SHA256:      f.SHA256,
+		Labels:      f.Labels,
+		StorageKey:  f.StorageKey,
+		CreatedAt:   f.CreatedAt,
+		UpdatedAt:   f.UpdatedAt,
 	}
 }
 
 func fileModelFromDomain(f *domain.File) FileModel {
+	labels := f.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
 	return FileModel{
 		Model: gorm.Model{
 			ID:        uint(f.ID),
 			CreatedAt: f.CreatedAt,
+			UpdatedAt: f.UpdatedAt,
 		},
-		UserID: f.UserID,
-		Name:   f.Name,
-		Path:   f.Path,
+		UserID:      f.UserID,
+		Name:        f.Name,
+		Path:        f.Path,
+		Size:        f.Size,
+		ContentType: f.ContentType,
+		SHA256:      f.SHA256,
+		Labels:      labels,
+		StorageKey:  f.StorageKey,
 	}
 }
 
 var _ repository.UserRepository = (*Repository)(nil)
 var _ repository.FileRepository = (*Repository)(nil)
+var _ repository.Transactor = (*Repository)(nil)
@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/repository"
+)
+
+func (r *Repository) CreateRefreshToken(ctx context.Context, token *domain.RefreshToken) error {
+	model := refreshTokenModelFromDomain(token)
+	if err := r.conn(ctx).Create(&model).Error; err != nil {
+		return err
+	}
+	*token = model.toDomain()
+	return nil
+}
+
+func (r *Repository) GetRefreshTokenByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	var model RefreshTokenModel
+	err := r.conn(ctx).
+		Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		Where("token_hash = ?", hash).
+		First(&model).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	token := model.toDomain()
+	return &token, nil
+}
+
+func (r *Repository) MarkRefreshTokenRotated(ctx context.Context, id uint, at time.Time) error {
+	return r.conn(ctx).Model(&RefreshTokenModel{ID: id}).Update("rotated_at", at).Error
+}
+
+func (r *Repository) RevokeRefreshTokenFamily(ctx context.Context, familyID string, at time.Time) error {
+	return r.conn(ctx).Model(&RefreshTokenModel{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", at).Error
+}
+
+// RevokeAccessToken adds jti to the revocation list and prunes entries whose
+// tokens have expired on their own.
+func (r *Repository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	db := r.conn(ctx)
+	if err := db.Where("expires_at < ?", time.Now().UTC()).Delete(&RevokedTokenModel{}).Error; err != nil {
+		return err
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&RevokedTokenModel{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (r *Repository) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	err := r.conn(ctx).Model(&RevokedTokenModel{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+type RefreshTokenModel struct {
+	ID        uint `gorm:"primaryKey"`
+	AccountID uint
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+}
+
+func (RefreshTokenModel) TableName() string {
+	return "refresh_tokens"
+}
+
+func (m RefreshTokenModel) toDomain() domain.RefreshToken {
+	return domain.RefreshToken{
+		ID:        m.ID,
+		AccountID: m.AccountID,
+		FamilyID:  m.FamilyID,
+		TokenHash: m.TokenHash,
+		ExpiresAt: m.ExpiresAt,
+		CreatedAt: m.CreatedAt,
+		RotatedAt: m.RotatedAt,
+		RevokedAt: m.RevokedAt,
+	}
+}
+
+func refreshTokenModelFromDomain(t *domain.RefreshToken) RefreshTokenModel {
+	return RefreshTokenModel{
+		ID:        t.ID,
+		AccountID: t.AccountID,
+		FamilyID:  t.FamilyID,
+		TokenHash: t.TokenHash,
+		ExpiresAt: t.ExpiresAt,
+		CreatedAt: t.CreatedAt,
+		RotatedAt: t.RotatedAt,
+		RevokedAt: t.RevokedAt,
+	}
+}
+
+type RevokedTokenModel struct {
+	JTI       string `gorm:"column:jti;primaryKey"`
+	ExpiresAt time.Time
+}
+
+func (RevokedTokenModel) TableName() string {
+	return "revoked_tokens"
+}
+
+var _ repository.SessionRepository = (*Repository)(nil)
@@ -0,0 +1,189 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/repository"
+)
+
+func (r *Repository) List(ctx context.Context, query repository.UserQuery) (repository.UserPage, error) {
+	if query.SortField == "" {
+		query.SortField = repository.SortByID
+	}
+	if !sortable(query.SortField) {
+		return repository.UserPage{}, domain.ErrInvalidInput
+	}
+
+	base := r.conn(ctx).Model(&UserModel{})
+	if query.Deleted {
+		base = base.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	filtered := applyUserFilters(base, query)
+
+	var total int64
+	if err := filtered.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return repository.UserPage{}, err
+	}
+
+	page := filtered.Session(&gorm.Session{})
+	if query.Cursor != "" {
+		cur, err := decodeCursor(query.Cursor)
+		if err != nil || cur.Field != query.SortField || cur.Desc != query.SortDesc {
+			return repository.UserPage{}, domain.ErrInvalidInput
+		}
+		value, err := cur.sortValue()
+		if err != nil {
+			return repository.UserPage{}, domain.ErrInvalidInput
+		}
+		op := ">"
+		if query.SortDesc {
+			op = "<"
+		}
+		if query.SortField == repository.SortByID {
+			page = page.Where("id "+op+" ?", cur.ID)
+		} else {
+			page = page.Where(fmt.Sprintf("(%s, id) %s (?, ?)", query.SortField, op), value, cur.ID)
+		}
+	}
+
+	dir := "ASC"
+	if query.SortDesc {
+		dir = "DESC"
+	}
+	if query.SortField != repository.SortByID {
+		page = page.Order(fmt.Sprintf("%s %s", query.SortField, dir))
+	}
+	page = page.Order("id " + dir)
+
+	var models []UserModel
+	if err := page.Preload("Files").Limit(query.Limit + 1).Find(&models).Error; err != nil {
+		return repository.UserPage{}, err
+	}
+
+	result := repository.UserPage{Total: total}
+	if len(models) > query.Limit {
+		models = models[:query.Limit]
+		next, err := encodeCursor(query, models[len(models)-1])
+		if err != nil {
+			return repository.UserPage{}, err
+		}
+		result.NextCursor = next
+	}
+	result.Users = make([]domain.User, len(models))
+	for i := range models {
+		result.Users[i] = models[i].toDomain()
+	}
+	return result, nil
+}
+
+func applyUserFilters(db *gorm.DB, query repository.UserQuery) *gorm.DB {
+	if query.EmailPrefix != "" {
+		db = db.Where("email LIKE ?", escapeLike(strings.ToLower(query.EmailPrefix))+"%")
+	}
+	if query.NameContains != "" {
+		db = db.Where("name ILIKE ?", "%"+escapeLike(query.NameContains)+"%")
+	}
+	if query.MinAge != nil {
+		db = db.Where("age >= ?", *query.MinAge)
+	}
+	if query.MaxAge != nil {
+		db = db.Where("age <= ?", *query.MaxAge)
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *query.CreatedBefore)
+	}
+	return db
+}
+
+func sortable(field repository.UserSortField) bool {
+	switch field {
+	case repository.SortByID, repository.SortByName, repository.SortByEmail, repository.SortByAge, repository.SortByCreatedAt:
+		return true
+	default:
+		return false
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// userCursor is the keyset position after which the next page starts. It
+// remembers the sort order so it cannot be replayed against another one.
+type userCursor struct {
+	Field repository.UserSortField `json:"f"`
+	Desc  bool                     `json:"d,omitempty"`
+	Value json.RawMessage          `json:"v,omitempty"`
+	ID    uint                     `json:"id"`
+}
+
+func encodeCursor(query repository.UserQuery, last UserModel) (string, error) {
+	var value interface{}
+	switch query.SortField {
+	case repository.SortByName:
+		value = last.Name
+	case repository.SortByEmail:
+		value = last.Email
+	case repository.SortByAge:
+		value = last.Age
+	case repository.SortByCreatedAt:
+		value = last.CreatedAt
+	}
+	cur := userCursor{Field: query.SortField, Desc: query.SortDesc, ID: last.ID}
+	if value != nil {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		cur.Value = raw
+	}
+	raw, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(s string) (userCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return userCursor{}, err
+	}
+	var cur userCursor
+	if err := json.Unmarshal(raw, &cur); err != nil {
+		return userCursor{}, err
+	}
+	return cur, nil
+}
+
+func (c userCursor) sortValue() (interface{}, error) {
+	switch c.Field {
+	case repository.SortByID:
+		return c.ID, nil
+	case repository.SortByName, repository.SortByEmail:
+		var v string
+		err := json.Unmarshal(c.Value, &v)
+		return v, err
+	case repository.SortByAge:
+		var v int
+		err := json.Unmarshal(c.Value, &v)
+		return v, err
+	case repository.SortByCreatedAt:
+		var v time.Time
+		err := json.Unmarshal(c.Value, &v)
+		return v, err
+	default:
+		return nil, fmt.Errorf("unknown sort field %q", c.Field)
+	}
+}
@@ -0,0 +1,321 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/repository"
+	"github.com/vele/temp_test_repo/internal/webhook"
+)
+
+func (r *Repository) ListWebhooks(ctx context.Context) ([]domain.Webhook, error) {
+	var models []WebhookModel
+	if err := r.conn(ctx).Order("id").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	webhooks := make([]domain.Webhook, len(models))
+	for i := range models {
+		webhooks[i] = models[i].toDomain()
+	}
+	return webhooks, nil
+}
+
+func (r *Repository) GetWebhookByID(ctx context.Context, id uint) (*domain.Webhook, error) {
+	var model WebhookModel
+	if err := r.conn(ctx).First(&model, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	webhook := model.toDomain()
+	return &webhook, nil
+}
+
+func (r *Repository) CreateWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	model := webhookModelFromDomain(webhook)
+	if err := r.conn(ctx).Create(&model).Error; err != nil {
+		return err
+	}
+	*webhook = model.toDomain()
+	return nil
+}
+
+func (r *Repository) UpdateWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	model := webhookModelFromDomain(webhook)
+	res := r.conn(ctx).Model(&WebhookModel{ID: webhook.ID}).Select("url", "event_types", "active", "updated_at").Updates(&model)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repository) DeleteWebhook(ctx context.Context, id uint) error {
+	res := r.conn(ctx).Delete(&WebhookModel{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repository) ListDeliveries(ctx context.Context, filter repository.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	query := r.conn(ctx).Where("webhook_id = ?", filter.WebhookID)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	var models []WebhookDeliveryModel
+	if err := query.Order("id DESC").Limit(filter.Limit).Find(&models).Error; err != nil {
+		return nil, err
+	}
+	deliveries := make([]domain.WebhookDelivery, len(models))
+	for i := range models {
+		deliveries[i] = models[i].toDomain()
+	}
+	return deliveries, nil
+}
+
+func (r *Repository) GetDelivery(ctx context.Context, webhookID, id uint) (*domain.WebhookDelivery, error) {
+	var model WebhookDeliveryModel
+	if err := r.conn(ctx).Where("webhook_id = ?", webhookID).First(&model, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	delivery := model.toDomain()
+	return &delivery, nil
+}
+
+func (r *Repository) RescheduleDelivery(ctx context.Context, webhookID, id uint) error {
+	res := r.conn(ctx).Model(&WebhookDeliveryModel{}).
+		Where("id = ? AND webhook_id = ? AND status = ?", id, webhookID, domain.DeliveryFailed).
+		Updates(map[string]interface{}{
+			"status":          domain.DeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now().UTC(),
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		if _, err := r.GetDelivery(ctx, webhookID, id); err != nil {
+			return err
+		}
+		return domain.ErrConflict
+	}
+	return nil
+}
+
+// WebhookDeliveries is the webhook.Store of the dispatcher.
+type WebhookDeliveries struct {
+	repo *Repository
+}
+
+func NewWebhookDeliveries(repo *Repository) *WebhookDeliveries {
+	return &WebhookDeliveries{repo: repo}
+}
+
+func (s *WebhookDeliveries) Subscribers(ctx context.Context, typ string) ([]domain.Webhook, error) {
+	var models []WebhookModel
+	err := s.repo.conn(ctx).
+		Where("active AND (event_types = '' OR ' ' || event_types || ' ' LIKE ?)", "% "+typ+" %").
+		Order("id").
+		Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+	webhooks := make([]domain.Webhook, len(models))
+	for i := range models {
+		webhooks[i] = models[i].toDomain()
+	}
+	return webhooks, nil
+}
+
+func (s *WebhookDeliveries) Enqueue(ctx context.Context, deliveries []domain.WebhookDelivery) error {
+	models := make([]WebhookDeliveryModel, len(deliveries))
+	for i := range deliveries {
+		models[i] = webhookDeliveryModelFromDomain(&deliveries[i])
+	}
+	return s.repo.conn(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&models).Error
+}
+
+// ClaimDue locks the due rows, skipping those locked by a concurrent claim,
+// and moves their next attempt to leaseUntil in one short transaction; the
+// posts happen after it commits.
+func (s *WebhookDeliveries) ClaimDue(ctx context.Context, limit int, exclude []uint, leaseUntil time.Time) ([]webhook.Due, error) {
+	var models []WebhookDeliveryModel
+	err := s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		query := s.repo.conn(ctx).
+			Clauses(clause.Locking{
+				Strength: clause.LockingStrengthUpdate,
+				Table:    clause.Table{Name: "webhook_deliveries"},
+				Options:  clause.LockingOptionsSkipLocked,
+			}).
+			Joins("JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id AND webhooks.active").
+			Where("webhook_deliveries.status = ? AND webhook_deliveries.next_attempt_at <= ?", domain.DeliveryPending, time.Now().UTC())
+		if len(exclude) > 0 {
+			query = query.Where("webhook_deliveries.webhook_id NOT IN ?", exclude)
+		}
+		err := query.
+			Order("webhook_deliveries.next_attempt_at, webhook_deliveries.id").
+			Limit(limit).
+			Find(&models).Error
+		if err != nil || len(models) == 0 {
+			return err
+		}
+		ids := make([]uint, len(models))
+		for i := range models {
+			ids[i] = models[i].ID
+		}
+		return s.repo.conn(ctx).Model(&WebhookDeliveryModel{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", leaseUntil).Error
+	})
+	if err != nil || len(models) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(models))
+	for _, m := range models {
+		ids = append(ids, m.WebhookID)
+	}
+	var hooks []WebhookModel
+	if err := s.repo.conn(ctx).Where("id IN ?", ids).Find(&hooks).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]domain.Webhook, len(hooks))
+	for _, h := range hooks {
+		byID[h.ID] = h.toDomain()
+	}
+	due := make([]webhook.Due, len(models))
+	for i := range models {
+		due[i] = webhook.Due{Delivery: models[i].toDomain(), Webhook: byID[models[i].WebhookID]}
+	}
+	return due, nil
+}
+
+func (s *WebhookDeliveries) RecordAttempt(ctx context.Context, delivery domain.WebhookDelivery) error {
+	return s.repo.conn(ctx).Model(&WebhookDeliveryModel{ID: delivery.ID}).Updates(map[string]interface{}{
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"response_status": delivery.ResponseStatus,
+		"last_error":      delivery.LastError,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"last_attempt_at": delivery.LastAttemptAt,
+		"delivered_at":    delivery.DeliveredAt,
+	}).Error
+}
+
+type WebhookModel struct {
+	ID         uint `gorm:"primaryKey"`
+	URL        string
+	EventTypes string
+	Secret     string
+	Active     bool
+	CreatedBy  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (WebhookModel) TableName() string {
+	return "webhooks"
+}
+
+func (w WebhookModel) toDomain() domain.Webhook {
+	return domain.Webhook{
+		ID:         w.ID,
+		URL:        w.URL,
+		EventTypes: strings.Fields(w.EventTypes),
+		Secret:     w.Secret,
+		Active:     w.Active,
+		CreatedBy:  w.CreatedBy,
+		CreatedAt:  w.CreatedAt,
+		UpdatedAt:  w.UpdatedAt,
+	}
+}
+
+func webhookModelFromDomain(w *domain.Webhook) WebhookModel {
+	return WebhookModel{
+		ID:         w.ID,
+		URL:        w.URL,
+		EventTypes: strings.Join(w.EventTypes, " "),
+		Secret:     w.Secret,
+		Active:     w.Active,
+		CreatedBy:  w.CreatedBy,
+		CreatedAt:  w.CreatedAt,
+		UpdatedAt:  w.UpdatedAt,
+	}
+}
+
+type WebhookDeliveryModel struct {
+	ID             uint `gorm:"primaryKey"`
+	WebhookID      uint
+	EventID        string
+	EventType      string
+	Payload        []byte `gorm:"type:jsonb"`
+	Status         string
+	Attempts       int
+	ResponseStatus int
+	LastError      string
+	NextAttemptAt  *time.Time
+	LastAttemptAt  *time.Time
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+}
+
+func (WebhookDeliveryModel) TableName() string {
+	return "webhook_deliveries"
+}
+
+func (d WebhookDeliveryModel) toDomain() domain.WebhookDelivery {
+	return domain.WebhookDelivery{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		LastError:      d.LastError,
+		NextAttemptAt:  d.NextAttemptAt,
+		LastAttemptAt:  d.LastAttemptAt,
+		DeliveredAt:    d.DeliveredAt,
+		CreatedAt:      d.CreatedAt,
+	}
+}
+
+func webhookDeliveryModelFromDomain(d *domain.WebhookDelivery) WebhookDeliveryModel {
+	return WebhookDeliveryModel{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		LastError:      d.LastError,
+		NextAttemptAt:  d.NextAttemptAt,
+		LastAttemptAt:  d.LastAttemptAt,
+		DeliveredAt:    d.DeliveredAt,
+		CreatedAt:      d.CreatedAt,
+	}
+}
+
+var _ repository.WebhookRepository = (*Repository)(nil)
+var _ webhook.Store = (*WebhookDeliveries)(nil)
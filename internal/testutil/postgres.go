@@ -54,7 +54,7 @@ func ConnectRepository(t *testing.T, dsn string) *postgresstorage.Repository {
 	const attempts = 20
 	var lastErr error
 	for i := 0; i < attempts; i++ {
-		repo, err := postgresstorage.NewRepository(dsn)
+		repo, err := connectAndMigrate(dsn)
 		if err == nil {
 			return repo
 		}
@@ -64,3 +64,19 @@ func ConnectRepository(t *testing.T, dsn string) *postgresstorage.Repository {
 	require.NoError(t, lastErr)
 	return nil
 }
+
+func connectAndMigrate(dsn string) (*postgresstorage.Repository, error) {
+	repo, err := postgresstorage.NewRepository(dsn)
+	if err != nil {
+		return nil, err
+	}
+	migrator, err := postgresstorage.NewMigrator(repo.DB())
+	if err == nil {
+		err = migrator.Up(context.Background())
+	}
+	if err != nil {
+		_ = repo.Close()
+		return nil, err
+	}
+	return repo, nil
+}
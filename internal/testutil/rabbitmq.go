@@ -0,0 +1,18 @@
+package testutil
+
+import (
+	"os"
+	"testing"
+)
+
+// RabbitDSN returns the broker named by TEST_RABBITMQ_DSN and skips the test
+// when it is unset. Tests must use their own exchange and queue names.
+func RabbitDSN(tb testing.TB) string {
+	tb.Helper()
+
+	dsn := os.Getenv("TEST_RABBITMQ_DSN")
+	if dsn == "" {
+		tb.Skip("TEST_RABBITMQ_DSN not set")
+	}
+	return dsn
+}
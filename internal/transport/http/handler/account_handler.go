@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/service"
+	"github.com/vele/temp_test_repo/internal/transport/http/middleware"
+)
+
+type AccountHandler struct {
+	accounts *service.AccountService
+}
+
+func NewAccountHandler(accounts *service.AccountService) *AccountHandler {
+	return &AccountHandler{accounts: accounts}
+}
+
+func (h *AccountHandler) RegisterRoutes(router *gin.RouterGroup) {
+	manage := middleware.RequireScopes(auth.ScopeAccountsManage)
+
+	router.GET("/accounts", manage, h.listAccounts)
+	router.POST("/accounts", manage, h.createAccount)
+	router.POST("/accounts/:id/disable", manage, h.disableAccount)
+	router.PUT("/accounts/:id/password", manage, h.rotatePassword)
+}
+
+func (h *AccountHandler) listAccounts(c *gin.Context) {
+	accounts, err := h.accounts.ListAccounts(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+func (h *AccountHandler) createAccount(c *gin.Context) {
+	var input service.CreateAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	account, err := h.accounts.CreateAccount(c.Request.Context(), input)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, account)
+}
+
+func (h *AccountHandler) disableAccount(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	account, err := h.accounts.DisableAccount(c.Request.Context(), id)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, account)
+}
+
+func (h *AccountHandler) rotatePassword(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var input service.RotatePasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.accounts.RotatePassword(c.Request.Context(), id, input); err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/service"
+	"github.com/vele/temp_test_repo/internal/transport/http/middleware"
+)
+
+type APIKeyHandler struct {
+	keys *service.APIKeyService
+}
+
+func NewAPIKeyHandler(keys *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{keys: keys}
+}
+
+func (h *APIKeyHandler) RegisterRoutes(router *gin.RouterGroup) {
+	manage := middleware.RequireScopes(auth.ScopeAccountsManage)
+
+	router.GET("/api-keys", manage, h.listAPIKeys)
+	router.POST("/api-keys", manage, h.createAPIKey)
+	router.DELETE("/api-keys/:id", manage, h.revokeAPIKey)
+}
+
+func (h *APIKeyHandler) listAPIKeys(c *gin.Context) {
+	keys, err := h.keys.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func (h *APIKeyHandler) createAPIKey(c *gin.Context) {
+	var input service.CreateAPIKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	key, err := h.keys.CreateAPIKey(c.Request.Context(), input)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, key)
+}
+
+func (h *APIKeyHandler) revokeAPIKey(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := h.keys.RevokeAPIKey(c.Request.Context(), id); err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -2,26 +2,28 @@ package handler
 
 import (
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/service"
+	"github.com/vele/temp_test_repo/internal/transport/http/middleware"
 )
 
 type AuthHandler struct {
-	secret      []byte
-	adminUser   string
-	adminPass   string
-	tokenExpiry time.Duration
-}
-
-func NewAuthHandler(secret, adminUser, adminPass string, ttl time.Duration) *AuthHandler {
-	return &AuthHandler{
-		secret:      []byte(secret),
-		adminUser:   adminUser,
-		adminPass:   adminPass,
-		tokenExpiry: ttl,
-	}
+	sessions *service.SessionService
+	keys     *auth.KeySet
+}
+
+func NewAuthHandler(sessions *service.SessionService, keys *auth.KeySet) *AuthHandler {
+	return &AuthHandler{sessions: sessions, keys: keys}
+}
+
+// JWKS publishes the public keys that verify tokens issued by this API.
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -30,25 +32,71 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if req.Username != h.adminUser || req.Password != h.adminPass {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	pair, err := h.sessions.Login(c.Request.Context(), req.Username, req.Password, c.ClientIP())
+	if err != nil {
+		if err == domain.ErrUnauthorized {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not issue token"})
 		return
 	}
-	claims := jwt.MapClaims{
-		"sub": req.Username,
-		"exp": time.Now().Add(h.tokenExpiry).Unix(),
-		"iat": time.Now().Unix(),
+	c.JSON(http.StatusOK, pair)
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(h.secret)
+	pair, err := h.sessions.Refresh(c.Request.Context(), req.RefreshToken)
 	if err != nil {
+		if err == domain.ErrUnauthorized {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not issue token"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	c.JSON(http.StatusOK, pair)
+}
+
+// Logout must run behind the auth middleware; it revokes the presented access
+// token and the optional refresh token in the body.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	principal, ok := middleware.PrincipalFrom(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+		return
+	}
+	var req logoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := h.sessions.Logout(c.Request.Context(), principal, req.RefreshToken); err != nil {
+		if err == domain.ErrInvalidInput {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "credential cannot be logged out"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
 
 type loginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type logoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
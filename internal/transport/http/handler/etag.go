@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag renders a resource version as a strong entity tag.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// requireIfMatch reads the If-Match header of a conditional write. It returns
+// the version the client expects, 0 for "*", and false after writing 428
+// when the header is missing. Tags that are not a version can never match and
+// yield -1.
+func requireIfMatch(c *gin.Context) (int64, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header required"})
+		return 0, false
+	}
+	if header == "*" {
+		return 0, true
+	}
+	tag := strings.TrimSpace(strings.Split(header, ",")[0])
+	if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2 {
+		return -1, true
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return -1, true
+	}
+	return version, true
+}
+
+// notModified reports whether the If-None-Match header matches tag.
+func notModified(c *gin.Context, tag string) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/service"
+	"github.com/vele/temp_test_repo/internal/transport/http/middleware"
+)
+
+type EventHandler struct {
+	events *service.EventService
+}
+
+func NewEventHandler(events *service.EventService) *EventHandler {
+	return &EventHandler{events: events}
+}
+
+func (h *EventHandler) RegisterRoutes(router *gin.RouterGroup) {
+	manage := middleware.RequireScopes(auth.ScopeEventsManage)
+
+	router.GET("/admin/events", manage, h.listEvents)
+	router.POST("/admin/events/replay", manage, h.replayEvents)
+}
+
+func (h *EventHandler) listEvents(c *gin.Context) {
+	var query service.EventQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := h.events.ListEvents(c.Request.Context(), query)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// replayEvents replays synchronously. When it fails part-way the response
+// still reports how far it got, so the caller can resume.
+func (h *EventHandler) replayEvents(c *gin.Context) {
+	var input service.ReplayInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := h.events.Replay(c.Request.Context(), input)
+	if err != nil {
+		status := statusForError(err)
+		if errors.Is(err, event.ErrUnroutable) {
+			// The target exchange or queue does not exist.
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"error":         err.Error(),
+			"replayed":      result.Replayed,
+			"last_sequence": result.LastSequence,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthCheck reports whether a dependency is usable.
+type HealthCheck func(ctx context.Context) error
+
+type HealthHandler struct {
+	checks map[string]HealthCheck
+}
+
+func NewHealthHandler(checks map[string]HealthCheck) *HealthHandler {
+	return &HealthHandler{checks: checks}
+}
+
+// Health runs every check and answers 503 when any of them fails, so that
+// orchestrators stop routing traffic to an instance that cannot serve it.
+func (h *HealthHandler) Health(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	status := http.StatusOK
+	results := gin.H{}
+	for name, check := range h.checks {
+		if err := check(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+			continue
+		}
+		results[name] = "ok"
+	}
+	overall := "ok"
+	if status != http.StatusOK {
+		overall = "unavailable"
+	}
+	c.JSON(status, gin.H{"status": overall, "checks": results})
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/transport/http/middleware"
+)
+
+// StreamQuery filters the event stream. Types are event type names such as
+// "UserUpdated"; LastEventID stands in for the Last-Event-ID header for
+// clients that cannot set it.
+type StreamQuery struct {
+	Types       []string `form:"type"`
+	UserID      uint     `form:"user_id"`
+	LastEventID string   `form:"last_event_id"`
+}
+
+// StreamHandler pushes user and file events to clients as Server-Sent
+// Events.
+type StreamHandler struct {
+	hub       *event.Hub
+	heartbeat time.Duration
+}
+
+// NewStreamHandler streams the events published to hub, sending a comment
+// every heartbeat to keep idle connections open through proxies.
+func NewStreamHandler(hub *event.Hub, heartbeat time.Duration) *StreamHandler {
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	return &StreamHandler{hub: hub, heartbeat: heartbeat}
+}
+
+func (h *StreamHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/events/stream", middleware.RequireScopes(auth.ScopeUsersRead), h.stream)
+}
+
+// stream sends each event with its ID as the SSE id and its type as the SSE
+// event name. A client that reconnects with Last-Event-ID gets the events
+// it missed, as long as the hub still holds them; otherwise it receives a
+// "resync" event and should reload its state.
+func (h *StreamHandler) stream(c *gin.Context) {
+	var query StreamQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	types := make(map[event.Type]bool, len(query.Types))
+	for _, name := range query.Types {
+		t := event.Type(name)
+		if !t.IsUserEvent() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown event type %q", name)})
+			return
+		}
+		types[t] = true
+	}
+	match := func(evt event.Event) bool {
+		if !evt.Type.IsUserEvent() {
+			return false
+		}
+		if len(types) > 0 && !types[evt.Type] {
+			return false
+		}
+		return query.UserID == 0 || evt.UserID == query.UserID
+	}
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.LastEventID
+	}
+
+	sub, backlog, resumed := h.hub.Subscribe(lastEventID, match)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stop reverse proxies such as nginx from buffering the stream.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	if !resumed {
+		if err := writeSSE(w, "", "resync", []byte(`{}`)); err != nil {
+			return
+		}
+	}
+	for _, evt := range backlog {
+		if err := writeEvent(w, evt); err != nil {
+			return
+		}
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case evt, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and
+				// resumes from its last event.
+				return
+			}
+			if err := writeEvent(w, evt); err != nil {
+				return
+			}
+			w.Flush()
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, evt event.Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return writeSSE(w, evt.ID, string(evt.Type), data)
+}
+
+// writeSSE writes one event. data must not contain newlines, which holds
+// for compact JSON.
+func writeSSE(w io.Writer, id, name string, data []byte) error {
+	var err error
+	if id != "" {
+		_, err = fmt.Fprintf(w, "id: %s\n", id)
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	}
+	return err
+}
@@ -1,41 +1,86 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/vele/temp_test_repo/internal/auth"
 	"github.com/vele/temp_test_repo/internal/domain"
 	"github.com/vele/temp_test_repo/internal/service"
+	"github.com/vele/temp_test_repo/internal/transport/http/middleware"
 )
 
 type UserHandler struct {
 	users *service.UserService
+	files *service.FileContentService
 }
 
-func NewUserHandler(users *service.UserService) *UserHandler {
-	return &UserHandler{users: users}
+// NewUserHandler builds the handler. files may be nil, in which case file
+// content cannot be uploaded or downloaded and files are recorded by
+// reference only.
+func NewUserHandler(users *service.UserService, files *service.FileContentService) *UserHandler {
+	return &UserHandler{users: users, files: files}
 }
 
 func (h *UserHandler) RegisterRoutes(router *gin.RouterGroup) {
-	router.GET("/users", h.listUsers)
-	router.GET("/users/:id", h.getUser)
-	router.POST("/users", h.createUser)
-	router.PUT("/users/:id", h.updateUser)
-	router.DELETE("/users/:id", h.deleteUser)
-	router.GET("/users/:id/files", h.listFiles)
-	router.POST("/users/:id/files", h.addFile)
-	router.DELETE("/users/:id/files", h.deleteFiles)
+	read := middleware.RequireScopes(auth.ScopeUsersRead)
+	write := middleware.RequireScopes(auth.ScopeUsersWrite)
+	del := middleware.RequireScopes(auth.ScopeUsersDelete)
+
+	router.GET("/users", read, h.listUsers)
+	router.GET("/users/trash", del, h.listDeletedUsers)
+	router.GET("/users/:id", read, h.getUser)
+	router.POST("/users", write, h.createUser)
+	router.PUT("/users/:id", write, h.updateUser)
+	router.DELETE("/users/:id", del, h.deleteUser)
+	router.POST("/users/:id/restore", del, h.restoreUser)
+	router.GET("/users/:id/files", read, h.listFiles)
+	router.POST("/users/:id/files", write, h.addFile)
+	router.DELETE("/users/:id/files", del, h.deleteFiles)
+	router.GET("/users/:id/files/:fileId", read, h.getFile)
+	router.PUT("/users/:id/files/:fileId", write, h.updateFile)
+	router.DELETE("/users/:id/files/:fileId", del, h.deleteFile)
+	if h.files != nil {
+		router.GET("/users/:id/files/:fileId/content", read, h.downloadFile)
+		router.PUT("/users/:id/files/:fileId/content", write, h.replaceFileContent)
+	}
 }
 
 func (h *UserHandler) listUsers(c *gin.Context) {
-	users, err := h.users.ListUsers(c.Request.Context())
+	var input service.ListUsersInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := h.users.ListUsers(c.Request.Context(), input)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *UserHandler) listDeletedUsers(c *gin.Context) {
+	var input service.ListUsersInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := h.users.ListDeletedUsers(c.Request.Context(), input)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *UserHandler) getUser(c *gin.Context) {
@@ -50,6 +95,12 @@ func (h *UserHandler) getUser(c *gin.Context) {
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
+	tag := etag(user.Version)
+	c.Header("ETag", tag)
+	if notModified(c, tag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
 	c.JSON(http.StatusOK, user)
 }
 
@@ -65,6 +116,7 @@ func (h *UserHandler) createUser(c *gin.Context) {
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
+	c.Header("ETag", etag(user.Version))
 	c.JSON(http.StatusCreated, user)
 }
 
@@ -74,17 +126,22 @@ func (h *UserHandler) updateUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	version, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
 	var input service.UpdateUserInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	user, err := h.users.UpdateUser(c.Request.Context(), id, input)
+	user, err := h.users.UpdateUser(c.Request.Context(), id, version, input)
 	if err != nil {
 		status := statusForError(err)
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
+	c.Header("ETag", etag(user.Version))
 	c.JSON(http.StatusOK, user)
 }
 
@@ -94,7 +151,18 @@ func (h *UserHandler) deleteUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	if err := h.users.DeleteUser(c.Request.Context(), id); err != nil {
+	version, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+	remove := h.users.DeleteUser
+	if hard, _ := strconv.ParseBool(c.Query("hard")); hard {
+		remove = h.users.PurgeUser
+		if h.files != nil {
+			remove = h.files.PurgeUser
+		}
+	}
+	if err := remove(c.Request.Context(), id, version); err != nil {
 		status := statusForError(err)
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
@@ -102,6 +170,22 @@ func (h *UserHandler) deleteUser(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+func (h *UserHandler) restoreUser(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	user, err := h.users.RestoreUser(c.Request.Context(), id)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("ETag", etag(user.Version))
+	c.JSON(http.StatusOK, user)
+}
+
 func (h *UserHandler) listFiles(c *gin.Context) {
 	id, err := parseID(c.Param("id"))
 	if err != nil {
@@ -123,6 +207,10 @@ func (h *UserHandler) addFile(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if h.files != nil && c.ContentType() == "multipart/form-data" {
+		h.uploadFile(c, id)
+		return
+	}
 	var input service.FileInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -137,13 +225,163 @@ func (h *UserHandler) addFile(c *gin.Context) {
 	c.JSON(http.StatusCreated, file)
 }
 
+// uploadFile streams a multipart upload. The optional name, path,
+// content_type and labels (a JSON object) fields must precede the file
+// part, each at most once; name and path default to the uploaded file name.
+func (h *UserHandler) uploadFile(c *gin.Context, userID uint) {
+	limit := h.files.MaxBytes() + int64(len(uploadFields))*maxFormFieldBytes + maxFormOverheadBytes
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var input service.FileInput
+	seen := make(map[string]bool, len(uploadFields))
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing file part"})
+			return
+		}
+		if err != nil {
+			c.JSON(uploadStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+		name := part.FormName()
+		if name == "file" {
+			if input.Name == "" {
+				input.Name = part.FileName()
+			}
+			if input.Path == "" {
+				input.Path = part.FileName()
+			}
+			if input.ContentType == "" {
+				input.ContentType = part.Header.Get("Content-Type")
+			}
+			file, err := h.files.Upload(c.Request.Context(), userID, input, part)
+			if err != nil {
+				c.JSON(uploadStatus(err, statusForError(err)), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusCreated, file)
+			return
+		}
+		if !uploadFields[name] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown form field " + name})
+			return
+		}
+		if seen[name] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "repeated form field " + name})
+			return
+		}
+		seen[name] = true
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes+1))
+		if err != nil {
+			c.JSON(uploadStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+		if len(value) > maxFormFieldBytes {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "form field " + name + " too long"})
+			return
+		}
+		switch name {
+		case "name":
+			input.Name = string(value)
+		case "path":
+			input.Path = string(value)
+		case "content_type":
+			input.ContentType = string(value)
+		case "labels":
+			if err := json.Unmarshal(value, &input.Labels); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "labels must be a JSON object of strings"})
+				return
+			}
+		}
+	}
+}
+
+// uploadFields are the metadata fields a multipart upload may carry.
+var uploadFields = map[string]bool{"name": true, "path": true, "content_type": true, "labels": true}
+
+// maxFormFieldBytes bounds each metadata field of a multipart upload, and
+// maxFormOverheadBytes the part headers and boundaries around them.
+const (
+	maxFormFieldBytes    = 64 << 10
+	maxFormOverheadBytes = 16 << 10
+)
+
+// uploadStatus is the status for an error reading an upload: 413 once the
+// body exceeds its limit, fallback otherwise.
+func uploadStatus(err error, fallback int) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
+
 func (h *UserHandler) deleteFiles(c *gin.Context) {
 	id, err := parseID(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	if err := h.users.DeleteFiles(c.Request.Context(), id); err != nil {
+	remove := h.users.DeleteFiles
+	if h.files != nil {
+		remove = h.files.DeleteFiles
+	}
+	if err := remove(c.Request.Context(), id); err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *UserHandler) getFile(c *gin.Context) {
+	userID, fileID, ok := parseFileIDs(c)
+	if !ok {
+		return
+	}
+	file, err := h.users.GetFile(c.Request.Context(), userID, fileID)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+func (h *UserHandler) updateFile(c *gin.Context) {
+	userID, fileID, ok := parseFileIDs(c)
+	if !ok {
+		return
+	}
+	var input service.UpdateFileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	file, err := h.users.UpdateFile(c.Request.Context(), userID, fileID, input)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+func (h *UserHandler) deleteFile(c *gin.Context) {
+	userID, fileID, ok := parseFileIDs(c)
+	if !ok {
+		return
+	}
+	remove := h.users.DeleteFile
+	if h.files != nil {
+		remove = h.files.DeleteFile
+	}
+	if err := remove(c.Request.Context(), userID, fileID); err != nil {
 		status := statusForError(err)
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
@@ -151,6 +389,67 @@ func (h *UserHandler) deleteFiles(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// downloadFile serves uploaded content with range and conditional request
+// support. The ETag is the SHA-256 of the content.
+func (h *UserHandler) downloadFile(c *gin.Context) {
+	userID, fileID, ok := parseFileIDs(c)
+	if !ok {
+		return
+	}
+	file, content, err := h.files.Open(c.Request.Context(), userID, fileID)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	if file.ContentType != "" {
+		c.Header("Content-Type", file.ContentType)
+	}
+	if file.SHA256 != "" {
+		c.Header("ETag", `"`+file.SHA256+`"`)
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	http.ServeContent(c.Writer, c.Request, file.Name, file.UpdatedAt, content)
+}
+
+// replaceFileContent stores the raw request body as the file's new content.
+func (h *UserHandler) replaceFileContent(c *gin.Context) {
+	userID, fileID, ok := parseFileIDs(c)
+	if !ok {
+		return
+	}
+	contentType := c.GetHeader("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && strings.HasPrefix(mediaType, "multipart/") {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "send the content as the raw request body"})
+		return
+	}
+	file, err := h.files.ReplaceContent(c.Request.Context(), userID, fileID, contentType, c.Request.Body)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+// parseFileIDs reads the :id and :fileId parameters, answering 400 when
+// either is malformed.
+func parseFileIDs(c *gin.Context) (userID, fileID uint, ok bool) {
+	userID, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return 0, 0, false
+	}
+	fileID, err = parseID(c.Param("fileId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file id"})
+		return 0, 0, false
+	}
+	return userID, fileID, true
+}
+
 func parseID(raw string) (uint, error) {
 	id64, err := strconv.ParseUint(raw, 10, 64)
 	if err != nil {
@@ -167,6 +466,14 @@ func statusForError(err error) int {
 		return http.StatusConflict
 	case domain.ErrNotFound:
 		return http.StatusNotFound
+	case domain.ErrUnauthorized:
+		return http.StatusUnauthorized
+	case domain.ErrForbidden:
+		return http.StatusForbidden
+	case domain.ErrPreconditionFailed:
+		return http.StatusPreconditionFailed
+	case domain.ErrTooLarge:
+		return http.StatusRequestEntityTooLarge
 	default:
 		return http.StatusInternalServerError
 	}
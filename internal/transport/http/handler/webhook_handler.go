@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/service"
+	"github.com/vele/temp_test_repo/internal/transport/http/middleware"
+)
+
+type WebhookHandler struct {
+	webhooks *service.WebhookService
+}
+
+func NewWebhookHandler(webhooks *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhooks: webhooks}
+}
+
+func (h *WebhookHandler) RegisterRoutes(router *gin.RouterGroup) {
+	manage := middleware.RequireScopes(auth.ScopeWebhooksManage)
+
+	router.GET("/webhooks", manage, h.listWebhooks)
+	router.POST("/webhooks", manage, h.createWebhook)
+	router.GET("/webhooks/:id", manage, h.getWebhook)
+	router.PUT("/webhooks/:id", manage, h.updateWebhook)
+	router.DELETE("/webhooks/:id", manage, h.deleteWebhook)
+	router.GET("/webhooks/:id/deliveries", manage, h.listDeliveries)
+	router.POST("/webhooks/:id/deliveries/:deliveryId/redeliver", manage, h.redeliver)
+}
+
+func (h *WebhookHandler) listWebhooks(c *gin.Context) {
+	webhooks, err := h.webhooks.ListWebhooks(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+func (h *WebhookHandler) createWebhook(c *gin.Context) {
+	var input service.CreateWebhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	webhook, err := h.webhooks.CreateWebhook(c.Request.Context(), input)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, webhook)
+}
+
+func (h *WebhookHandler) getWebhook(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	webhook, err := h.webhooks.GetWebhook(c.Request.Context(), id)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+func (h *WebhookHandler) updateWebhook(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var input service.UpdateWebhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	webhook, err := h.webhooks.UpdateWebhook(c.Request.Context(), id, input)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+func (h *WebhookHandler) deleteWebhook(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := h.webhooks.DeleteWebhook(c.Request.Context(), id); err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *WebhookHandler) listDeliveries(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var query service.DeliveryQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	deliveries, err := h.webhooks.ListDeliveries(c.Request.Context(), id, query)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func (h *WebhookHandler) redeliver(c *gin.Context) {
+	id, err := parseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	deliveryID, err := parseID(c.Param("deliveryId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid delivery id"})
+		return
+	}
+	delivery, err := h.webhooks.Redeliver(c.Request.Context(), id, deliveryID)
+	if err != nil {
+		status := statusForError(err)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
@@ -1,36 +1,140 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/vele/temp_test_repo/internal/auth"
+	"github.com/vele/temp_test_repo/internal/domain"
 )
 
+// PrincipalKey is the gin context key holding the authenticated auth.Principal.
+const PrincipalKey = "principal"
+
+type TokenVerifier interface {
+	Verify(token string) (auth.Principal, error)
+}
+
+// RevocationChecker reports whether an access token, identified by its jti,
+// was revoked before expiring.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// APIKeyAuthenticator resolves an API key to its principal. It returns
+// domain.ErrUnauthorized for keys that are unknown, revoked or expired.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (auth.Principal, error)
+}
+
 type Auth struct {
-	secret []byte
+	tokens      TokenVerifier
+	revocations RevocationChecker
+	apiKeys     APIKeyAuthenticator
 }
 
-func NewAuth(secret string) *Auth {
-	return &Auth{secret: []byte(secret)}
+// NewAuth builds the authentication middleware. revocations and apiKeys may
+// be nil; without apiKeys only JWTs are accepted.
+func NewAuth(tokens TokenVerifier, revocations RevocationChecker, apiKeys APIKeyAuthenticator) *Auth {
+	return &Auth{tokens: tokens, revocations: revocations, apiKeys: apiKeys}
 }
 
+// Handler authenticates the request from an X-API-Key header or a Bearer
+// token, which may be a JWT or an API key.
 func (a *Auth) Handler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		auth := c.GetHeader("Authorization")
-		if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			a.authenticateAPIKey(c, key)
+			return
+		}
+		header := c.GetHeader("Authorization")
+		if header == "" || !strings.HasPrefix(header, "Bearer ") {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
 			return
 		}
-		tokenString := strings.TrimPrefix(auth, "Bearer ")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return a.secret, nil
-		})
-		if err != nil || !token.Valid {
+		token := strings.TrimPrefix(header, "Bearer ")
+		if strings.HasPrefix(token, auth.APIKeyPrefix) {
+			a.authenticateAPIKey(c, token)
+			return
+		}
+		principal, err := a.tokens.Verify(token)
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
+		if a.revocations != nil && principal.TokenID != "" {
+			revoked, err := a.revocations.IsRevoked(c.Request.Context(), principal.TokenID)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not verify token"})
+				return
+			}
+			if revoked {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token revoked"})
+				return
+			}
+		}
+		setPrincipal(c, principal)
+		c.Next()
+	}
+}
+
+func (a *Auth) authenticateAPIKey(c *gin.Context, key string) {
+	if a.apiKeys == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "api keys are not accepted"})
+		return
+	}
+	principal, err := a.apiKeys.AuthenticateAPIKey(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, domain.ErrUnauthorized) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not verify api key"})
+		return
+	}
+	setPrincipal(c, principal)
+	c.Next()
+}
+
+// RequireScopes rejects requests whose principal lacks any of the scopes with
+// 403 and a body naming the missing ones.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := PrincipalFrom(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+			return
+		}
+		if missing := principal.MissingScopes(scopes...); len(missing) > 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":           "insufficient scope",
+				"code":            "insufficient_scope",
+				"required_scopes": scopes,
+				"missing_scopes":  missing,
+			})
+			return
+		}
 		c.Next()
 	}
 }
+
+// PrincipalFrom returns the principal stored by Auth.Handler.
+func PrincipalFrom(c *gin.Context) (auth.Principal, bool) {
+	v, ok := c.Get(PrincipalKey)
+	if !ok {
+		return auth.Principal{}, false
+	}
+	p, ok := v.(auth.Principal)
+	return p, ok
+}
+
+// setPrincipal exposes p to later handlers and, through the request context,
+// to the service layer.
+func setPrincipal(c *gin.Context, p auth.Principal) {
+	c.Set(PrincipalKey, p)
+	c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), p))
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/vele/temp_test_repo/internal/event"
+)
+
+// CorrelationHeader carries the correlation ID of a request. Callers may
+// set it to tie the events of several requests together.
+const CorrelationHeader = "X-Correlation-ID"
+
+// Correlation assigns every request a correlation ID, taken from the
+// request header or generated, echoes it in the response and stores it in
+// the request context so that events published for the request carry it.
+func Correlation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(CorrelationHeader)
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+		c.Header(CorrelationHeader, id)
+		c.Request = c.Request.WithContext(event.WithCorrelationID(c.Request.Context(), id))
+		c.Next()
+	}
+}
@@ -14,11 +14,12 @@ func RequestLogger(log *logrus.Logger) gin.HandlerFunc {
 		latency := time.Since(start)
 		status := c.Writer.Status()
 		log.WithFields(logrus.Fields{
-			"method":  c.Request.Method,
-			"path":    c.Request.URL.Path,
-			"status":  status,
-			"latency": latency.String(),
-			"client":  c.ClientIP(),
+			"method":        c.Request.Method,
+			"path":          c.Request.URL.Path,
+			"status":        status,
+			"latency":       latency.String(),
+			"client":        c.ClientIP(),
+			"correlationID": c.Writer.Header().Get(CorrelationHeader),
 		}).Info("request processed")
 	}
 }
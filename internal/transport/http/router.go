@@ -9,24 +9,52 @@ import (
 )
 
 type RouterDeps struct {
-	UserHandler *handler.UserHandler
-	AuthHandler *handler.AuthHandler
-	Auth        *middleware.Auth
-	Logger      *logrus.Logger
+	UserHandler    *handler.UserHandler
+	AccountHandler *handler.AccountHandler
+	APIKeyHandler  *handler.APIKeyHandler
+	AuthHandler    *handler.AuthHandler
+	HealthHandler  *handler.HealthHandler
+	EventHandler   *handler.EventHandler
+	WebhookHandler *handler.WebhookHandler
+	StreamHandler  *handler.StreamHandler
+	Auth           *middleware.Auth
+	Logger         *logrus.Logger
 }
 
 func NewRouter(deps RouterDeps) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 	router.Use(gin.Recovery())
+	router.Use(middleware.Correlation())
 	if deps.Logger != nil {
 		router.Use(middleware.RequestLogger(deps.Logger))
 	}
+	if deps.HealthHandler != nil {
+		router.GET("/healthz", deps.HealthHandler.Health)
+	}
+	router.GET("/.well-known/jwks.json", deps.AuthHandler.JWKS)
 	router.POST("/auth/login", deps.AuthHandler.Login)
+	router.POST("/auth/refresh", deps.AuthHandler.Refresh)
+	router.POST("/auth/logout", deps.Auth.Handler(), deps.AuthHandler.Logout)
 
 	api := router.Group("/api/v1")
 	api.Use(deps.Auth.Handler())
 	deps.UserHandler.RegisterRoutes(api)
+	if deps.AccountHandler != nil {
+		deps.AccountHandler.RegisterRoutes(api)
+	}
+	if deps.APIKeyHandler != nil {
+		deps.APIKeyHandler.RegisterRoutes(api)
+	}
+	if deps.EventHandler != nil {
+		deps.EventHandler.RegisterRoutes(api)
+	}
+	if deps.WebhookHandler != nil {
+		deps.WebhookHandler.RegisterRoutes(api)
+	}
+	if deps.StreamHandler != nil {
+		deps.StreamHandler.RegisterRoutes(api)
+	}
 
 	return router
 }
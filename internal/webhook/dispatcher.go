@@ -0,0 +1,320 @@
+// Package webhook delivers user and file events to the HTTP endpoints of
+// webhook subscriptions.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/sink"
+)
+
+// Deliverable reports whether webhooks may subscribe to events of type t:
+// user and file events are, audit events are not.
+func Deliverable(t event.Type) bool {
+	return t.IsUserEvent()
+}
+
+// Due is a pending delivery whose attempt is due, with its webhook.
+type Due struct {
+	Delivery domain.WebhookDelivery
+	Webhook  domain.Webhook
+}
+
+// Store gives the dispatcher access to subscriptions and deliveries.
+type Store interface {
+	// Subscribers returns the active webhooks subscribed to events of typ.
+	Subscribers(ctx context.Context, typ string) ([]domain.Webhook, error)
+	// Enqueue records pending deliveries, skipping those already recorded
+	// for the same webhook and event.
+	Enqueue(ctx context.Context, deliveries []domain.WebhookDelivery) error
+	// ClaimDue returns pending deliveries of active webhooks whose next
+	// attempt is due, oldest first, skipping the webhooks in exclude. The
+	// claimed deliveries are leased: their next attempt moves to leaseUntil
+	// before ClaimDue returns, so concurrent dispatchers skip them while
+	// they are attempted, and a dispatcher that dies mid-attempt only
+	// delays them until the lease runs out.
+	ClaimDue(ctx context.Context, limit int, exclude []uint, leaseUntil time.Time) ([]Due, error)
+	// RecordAttempt saves the outcome of an attempt.
+	RecordAttempt(ctx context.Context, delivery domain.WebhookDelivery) error
+}
+
+type Config struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// MaxAttempts is how often a delivery is attempted before it fails for
+	// good. Retries wait MinBackoff, doubling up to MaxBackoff.
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	// Lease is how long claimed deliveries are hidden from other
+	// dispatchers. It must outlast a batch of posts to one webhook and
+	// defaults to BatchSize client timeouts plus a minute.
+	Lease time.Duration
+}
+
+// Dispatcher is the consumer sink behind webhooks. Consumed events are
+// recorded as one pending delivery per subscribed webhook, which Run posts
+// with retries, so a slow or failing endpoint only delays its own
+// deliveries and never the queue. Different webhooks are attempted in
+// parallel. Deliveries carry no ordering guarantee: a retried delivery is
+// overtaken by later ones, and other dispatchers may post to the same
+// webhook at the same time.
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	cfg    Config
+	log    *logrus.Logger
+	now    func() time.Time
+
+	// busy holds the webhooks with attempts in flight, so that this
+	// dispatcher does not post to one webhook concurrently.
+	mu   sync.Mutex
+	busy map[uint]bool
+}
+
+// NewDispatcher returns a dispatcher that signs each delivery with the
+// secret of its webhook. Without a timeout on client, a hung endpoint holds
+// its deliveries until the lease runs out, so one should be set.
+func NewDispatcher(store Store, client *http.Client, cfg Config, log *logrus.Logger) *Dispatcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 20
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 10 * time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Hour
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = 10 * time.Minute
+		if client.Timeout > 0 {
+			cfg.Lease = time.Duration(cfg.BatchSize)*client.Timeout + time.Minute
+		}
+	}
+	if log == nil {
+		log = logrus.New()
+	}
+	return &Dispatcher{
+		store:  store,
+		client: client,
+		cfg:    cfg,
+		log:    log,
+		now:    time.Now,
+		busy:   make(map[uint]bool),
+	}
+}
+
+func (d *Dispatcher) Register(r *event.Registry) {
+	r.HandleAll(d.enqueue)
+}
+
+func (d *Dispatcher) Close() error { return nil }
+
+// enqueue records a delivery of evt to every subscribed webhook. Events
+// without an ID predate webhooks and are only seen in replays; they are
+// skipped, as deliveries are keyed by event ID.
+func (d *Dispatcher) enqueue(ctx context.Context, evt event.Event) error {
+	if !Deliverable(evt.Type) || evt.ID == "" {
+		return nil
+	}
+	webhooks, err := d.store.Subscribers(ctx, string(evt.Type))
+	if err != nil {
+		return fmt.Errorf("load subscribers: %w", err)
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return event.Permanent(fmt.Errorf("marshal event: %w", err))
+	}
+	now := d.now().UTC()
+	deliveries := make([]domain.WebhookDelivery, len(webhooks))
+	for i, webhook := range webhooks {
+		deliveries[i] = domain.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			EventID:       evt.ID,
+			EventType:     string(evt.Type),
+			Payload:       body,
+			Status:        domain.DeliveryPending,
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+		}
+	}
+	if err := d.store.Enqueue(ctx, deliveries); err != nil {
+		return fmt.Errorf("enqueue deliveries: %w", err)
+	}
+	return nil
+}
+
+// Run attempts due deliveries until ctx is cancelled, and then waits for
+// the attempts in flight.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		for {
+			claimed, err := d.dispatch(ctx, &wg)
+			if err != nil {
+				d.log.WithError(err).Warn("webhook dispatch failed")
+				break
+			}
+			if claimed < d.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush attempts one batch of due deliveries and returns how many were
+// attempted, successfully or not.
+func (d *Dispatcher) Flush(ctx context.Context) (int, error) {
+	var wg sync.WaitGroup
+	claimed, err := d.dispatch(ctx, &wg)
+	wg.Wait()
+	return claimed, err
+}
+
+// dispatch claims a batch of due deliveries of the webhooks that are not
+// busy and attempts them in one goroutine per webhook, added to wg. It
+// returns how many deliveries it claimed.
+func (d *Dispatcher) dispatch(ctx context.Context, wg *sync.WaitGroup) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	busy := make([]uint, 0, len(d.busy))
+	for id := range d.busy {
+		busy = append(busy, id)
+	}
+	due, err := d.store.ClaimDue(ctx, d.cfg.BatchSize, busy, d.now().UTC().Add(d.cfg.Lease))
+	if err != nil {
+		return 0, fmt.Errorf("claim due deliveries: %w", err)
+	}
+	byWebhook := make(map[uint][]Due)
+	for _, next := range due {
+		byWebhook[next.Webhook.ID] = append(byWebhook[next.Webhook.ID], next)
+	}
+	for id, batch := range byWebhook {
+		d.busy[id] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.deliver(ctx, batch)
+			d.mu.Lock()
+			delete(d.busy, id)
+			d.mu.Unlock()
+		}()
+	}
+	return len(due), nil
+}
+
+// deliver attempts the claimed deliveries of one webhook in turn. Those
+// left unattempted when ctx is cancelled, or whose outcome cannot be
+// recorded, are attempted again once their lease runs out.
+func (d *Dispatcher) deliver(ctx context.Context, batch []Due) {
+	for _, next := range batch {
+		if ctx.Err() != nil {
+			return
+		}
+		delivery := d.attempt(ctx, next)
+		if err := d.store.RecordAttempt(ctx, delivery); err != nil {
+			d.log.WithError(err).WithFields(logrus.Fields{
+				"webhookID":  next.Webhook.ID,
+				"deliveryID": delivery.ID,
+			}).Warn("record webhook attempt failed")
+		}
+	}
+}
+
+// attempt posts the delivery once and returns it updated with the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, due Due) domain.WebhookDelivery {
+	delivery := due.Delivery
+	now := d.now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+
+	status, err := d.post(ctx, due.Webhook, delivery)
+	delivery.ResponseStatus = status
+	entry := d.log.WithFields(logrus.Fields{
+		"webhookID":  due.Webhook.ID,
+		"deliveryID": delivery.ID,
+		"eventID":    delivery.EventID,
+		"attempts":   delivery.Attempts,
+	})
+	switch {
+	case err == nil:
+		delivery.Status = domain.DeliverySucceeded
+		delivery.LastError = ""
+		delivery.NextAttemptAt = nil
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= d.cfg.MaxAttempts:
+		delivery.Status = domain.DeliveryFailed
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = nil
+		entry.WithError(err).Warn("webhook delivery failed for good")
+	default:
+		next := now.Add(d.backoff(delivery.Attempts))
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = &next
+		entry.WithError(err).Info("webhook delivery failed; retrying")
+	}
+	return delivery
+}
+
+// post sends the delivery and returns the response status, if any. Any
+// status other than 2xx is a failure and retried, even those sink.PostJSON
+// deems permanent: the subscriber may fix its endpoint before the last
+// attempt.
+func (d *Dispatcher) post(ctx context.Context, webhook domain.Webhook, delivery domain.WebhookDelivery) (int, error) {
+	timestamp := d.now().Unix()
+	header := http.Header{}
+	header.Set("User-Agent", "user-management-api-webhooks")
+	header.Set(EventIDHeader, delivery.EventID)
+	header.Set(EventTypeHeader, delivery.EventType)
+	header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+	return sink.PostJSON(ctx, d.client, webhook.URL, delivery.Payload, header)
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.cfg.MinBackoff
+	for i := 1; i < attempts; i++ {
+		b *= 2
+		if b >= d.cfg.MaxBackoff {
+			return d.cfg.MaxBackoff
+		}
+	}
+	return b
+}
+
+var _ sink.Sink = (*Dispatcher)(nil)
+var _ sink.Runner = (*Dispatcher)(nil)
@@ -0,0 +1,292 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vele/temp_test_repo/internal/domain"
+	"github.com/vele/temp_test_repo/internal/event"
+	"github.com/vele/temp_test_repo/internal/webhook"
+)
+
+func TestSign_VerifiesOnlyTheSignedBody(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	sig := webhook.Sign("whsec_a", 1700000000, body)
+
+	require.True(t, webhook.Verify("whsec_a", 1700000000, body, sig))
+	require.False(t, webhook.Verify("whsec_b", 1700000000, body, sig))
+	require.False(t, webhook.Verify("whsec_a", 1700000001, body, sig))
+	require.False(t, webhook.Verify("whsec_a", 1700000000, []byte(`{"id":"2"}`), sig))
+	require.False(t, webhook.Verify("whsec_a", 1700000000, body, sig[len("sha256="):]))
+}
+
+func TestDispatcher_DeliversSignedEventsToSubscribers(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	var (
+		mu       sync.Mutex
+		received []request
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, request{header: r.Header, body: body})
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	store := newFakeStore(
+		domain.Webhook{ID: 1, URL: receiver.URL, Secret: "whsec_all", Active: true},
+		domain.Webhook{ID: 2, URL: receiver.URL, Secret: "whsec_files", Active: true, EventTypes: []string{"FileAdded"}},
+		domain.Webhook{ID: 3, URL: receiver.URL, Secret: "whsec_off", Active: false},
+	)
+	d := webhook.NewDispatcher(store, receiver.Client(), webhook.Config{}, nil)
+	r := event.NewRegistry()
+	d.Register(r)
+	ctx := context.Background()
+
+	created := event.New(ctx, event.UserCreated, 1, domain.User{ID: 1})
+	require.NoError(t, r.Dispatch(ctx, created))
+	require.NoError(t, r.Dispatch(ctx, created), "a redelivered event is enqueued once")
+	require.NoError(t, r.Dispatch(ctx, event.New(ctx, event.FileAdded, 1, domain.File{ID: 5})))
+	require.NoError(t, r.Dispatch(ctx, event.New(ctx, event.LoginFailed, 0, event.AccountAudit{})))
+	require.Len(t, store.deliveries, 3)
+
+	attempted, err := d.Flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, attempted)
+	require.Len(t, received, 3)
+
+	// Webhooks are posted to in parallel, so find the first delivery.
+	var first request
+	for _, req := range received {
+		if req.header.Get(webhook.DeliveryHeader) == "1" {
+			first = req
+		}
+	}
+	require.Equal(t, created.ID, first.header.Get(webhook.EventIDHeader))
+	require.Equal(t, "UserCreated", first.header.Get(webhook.EventTypeHeader))
+	timestamp, err := strconv.ParseInt(first.header.Get(webhook.TimestampHeader), 10, 64)
+	require.NoError(t, err)
+	require.True(t, webhook.Verify("whsec_all", timestamp, first.body, first.header.Get(webhook.SignatureHeader)))
+	var evt event.Event
+	require.NoError(t, json.Unmarshal(first.body, &evt))
+	require.Equal(t, created.ID, evt.ID)
+
+	for _, delivery := range store.deliveries {
+		require.Equal(t, domain.DeliverySucceeded, delivery.Status)
+		require.Equal(t, 1, delivery.Attempts)
+		require.Equal(t, http.StatusOK, delivery.ResponseStatus)
+		require.NotNil(t, delivery.DeliveredAt)
+	}
+}
+
+func TestDispatcher_RetriesWithBackoffThenFails(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	store := newFakeStore(domain.Webhook{ID: 1, URL: receiver.URL, Secret: "s", Active: true})
+	d := webhook.NewDispatcher(store, receiver.Client(), webhook.Config{
+		MaxAttempts: 3,
+		MinBackoff:  time.Minute,
+		MaxBackoff:  90 * time.Second,
+	}, nil)
+	r := event.NewRegistry()
+	d.Register(r)
+	ctx := context.Background()
+	require.NoError(t, r.Dispatch(ctx, event.New(ctx, event.UserDeleted, 1, nil)))
+
+	attempt := func() domain.WebhookDelivery {
+		t.Helper()
+		store.advance()
+		_, err := d.Flush(ctx)
+		require.NoError(t, err)
+		return store.deliveries[0]
+	}
+
+	delivery := attempt()
+	require.Equal(t, domain.DeliveryPending, delivery.Status)
+	require.Equal(t, http.StatusServiceUnavailable, delivery.ResponseStatus)
+	require.Contains(t, delivery.LastError, "503")
+	require.WithinDuration(t, delivery.LastAttemptAt.Add(time.Minute), *delivery.NextAttemptAt, time.Second)
+
+	// Nothing is due before the backoff has passed.
+	attempted, err := d.Flush(ctx)
+	require.NoError(t, err)
+	require.Zero(t, attempted)
+
+	delivery = attempt()
+	require.WithinDuration(t, delivery.LastAttemptAt.Add(90*time.Second), *delivery.NextAttemptAt, time.Second)
+
+	delivery = attempt()
+	require.Equal(t, domain.DeliveryFailed, delivery.Status)
+	require.Equal(t, 3, delivery.Attempts)
+	require.Nil(t, delivery.NextAttemptAt)
+
+	// A failed delivery is only attempted again once it is rescheduled.
+	status = http.StatusNoContent
+	store.advance()
+	attempted, err = d.Flush(ctx)
+	require.NoError(t, err)
+	require.Zero(t, attempted)
+}
+
+func TestDispatcher_PostsOutsideTheClaim(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer fast.Close()
+
+	store := newFakeStore(
+		domain.Webhook{ID: 1, URL: slow.URL, Secret: "s", Active: true},
+		domain.Webhook{ID: 2, URL: fast.URL, Secret: "s", Active: true},
+	)
+	d := webhook.NewDispatcher(store, http.DefaultClient, webhook.Config{}, nil)
+	r := event.NewRegistry()
+	d.Register(r)
+	ctx := context.Background()
+	require.NoError(t, r.Dispatch(ctx, event.New(ctx, event.UserCreated, 1, domain.User{ID: 1})))
+
+	flushed := make(chan int)
+	go func() {
+		attempted, err := d.Flush(ctx)
+		require.NoError(t, err)
+		flushed <- attempted
+	}()
+	<-started
+
+	// The fast webhook is not held up by the slow one, and the slow
+	// delivery stays leased to the first dispatcher while it is posted.
+	require.Eventually(t, func() bool {
+		return store.delivery(2).Status == domain.DeliverySucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+	other := webhook.NewDispatcher(store, http.DefaultClient, webhook.Config{}, nil)
+	attempted, err := other.Flush(ctx)
+	require.NoError(t, err)
+	require.Zero(t, attempted)
+	require.Equal(t, domain.DeliveryPending, store.delivery(1).Status)
+
+	close(release)
+	require.Equal(t, 2, <-flushed)
+	require.Equal(t, domain.DeliverySucceeded, store.delivery(1).Status)
+}
+
+// fakeStore keeps webhooks and deliveries in memory. Due deliveries are
+// those whose next attempt is before the store's clock. The clock starts
+// past every attempt scheduled so far, is reset to the present by each
+// ClaimDue, and advance moves it ahead again.
+type fakeStore struct {
+	mu         sync.Mutex
+	webhooks   []domain.Webhook
+	deliveries []domain.WebhookDelivery
+	clock      time.Time
+}
+
+func newFakeStore(webhooks ...domain.Webhook) *fakeStore {
+	s := &fakeStore{webhooks: webhooks}
+	s.advance()
+	return s
+}
+
+func (s *fakeStore) advance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = time.Now().UTC().Add(24 * time.Hour)
+}
+
+func (s *fakeStore) delivery(id uint) domain.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deliveries[id-1]
+}
+
+func (s *fakeStore) Subscribers(_ context.Context, typ string) ([]domain.Webhook, error) {
+	var out []domain.Webhook
+	for _, w := range s.webhooks {
+		if !w.Active {
+			continue
+		}
+		if len(w.EventTypes) == 0 {
+			out = append(out, w)
+			continue
+		}
+		for _, t := range w.EventTypes {
+			if t == typ {
+				out = append(out, w)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+func (s *fakeStore) Enqueue(_ context.Context, deliveries []domain.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+next:
+	for _, d := range deliveries {
+		for _, existing := range s.deliveries {
+			if existing.WebhookID == d.WebhookID && existing.EventID == d.EventID {
+				continue next
+			}
+		}
+		d.ID = uint(len(s.deliveries) + 1)
+		s.deliveries = append(s.deliveries, d)
+	}
+	return nil
+}
+
+func (s *fakeStore) ClaimDue(_ context.Context, limit int, exclude []uint, leaseUntil time.Time) ([]webhook.Due, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []webhook.Due
+	for i, d := range s.deliveries {
+		if d.Status != domain.DeliveryPending || d.NextAttemptAt.After(s.clock) || len(due) == limit ||
+			slices.Contains(exclude, d.WebhookID) {
+			continue
+		}
+		for _, w := range s.webhooks {
+			if w.ID == d.WebhookID && w.Active {
+				due = append(due, webhook.Due{Delivery: d, Webhook: w})
+				lease := leaseUntil
+				s.deliveries[i].NextAttemptAt = &lease
+			}
+		}
+	}
+	// Attempts made from now on are only due once the clock advances.
+	s.clock = time.Now().UTC()
+	return due, nil
+}
+
+func (s *fakeStore) RecordAttempt(_ context.Context, delivery domain.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[delivery.ID-1] = delivery
+	return nil
+}
+
+var _ webhook.Store = (*fakeStore)(nil)
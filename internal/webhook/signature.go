@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Headers sent with every delivery. Receivers verify the signature over the
+// timestamp and the body, and may reject old timestamps to stop replays.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventIDHeader   = "X-Event-ID"
+	EventTypeHeader = "X-Event-Type"
+)
+
+const signaturePrefix = "sha256="
+
+// Sign returns the signature header value for body sent at timestamp (Unix
+// seconds): "sha256=" and the hex HMAC-SHA256, keyed with secret, of the
+// timestamp, a dot and the body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the valid signature of body sent at
+// timestamp.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}